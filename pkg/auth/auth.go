@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/united-manufacturing-hub/expiremap/pkg/expiremap"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/mod/module"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// identityKey is the gin context key of the authenticated identity
+const identityKey = "identity"
+
+// authenticatorKey is the gin context key of the Authenticator that authenticated the request
+const authenticatorKey = "authenticator"
+
+// Authenticator authenticates proxy clients and checks which modules they may access
+type Authenticator struct {
+	// users maps user names to bcrypt hashes
+	users map[string][]byte
+	// unknownUserHash is compared against for unknown users, so they take as long to reject as wrong passwords
+	unknownUserHash []byte
+	// tokens maps the sha256 of bearer tokens to identities
+	tokens    map[string]string
+	clientCAs *x509.CertPool
+	rules     map[string]string
+	// verified remembers successful basic authentications, as bcrypt is too slow to run on every request
+	verified *expiremap.ExpireMap[string, string]
+}
+
+// New returns an Authenticator for cfg, or nil if cfg enables no authentication method
+func New(cfg Config) (*Authenticator, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	a := &Authenticator{
+		users:    make(map[string][]byte),
+		tokens:   make(map[string]string),
+		verified: expiremap.NewEx[string, string](time.Minute, 5*time.Minute),
+	}
+	var err error
+	if cfg.UsersFile != "" {
+		a.users, err = loadUsers(cfg.UsersFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	a.unknownUserHash, err = dummyHash(a.users)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := parseTokens(cfg.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	for token, identity := range tokens {
+		a.tokens[hashToken(token)] = identity
+	}
+	if cfg.ClientCA != "" {
+		pem, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		a.clientCAs = x509.NewCertPool()
+		if !a.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA file %s", cfg.ClientCA)
+		}
+	}
+	a.rules, err = parseRules(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// loadUsers reads user:bcrypt-hash lines, empty lines and lines starting with # are skipped
+func loadUsers(path string) (map[string][]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(entry, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("invalid entry in users file %s line %d, expected user:bcrypt-hash", path, line)
+		}
+		if _, err = bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash of %s in users file %s: %w", user, path, err)
+		}
+		users[user] = []byte(hash)
+	}
+	return users, scanner.Err()
+}
+
+// dummyHash returns a bcrypt hash of no password, with the highest cost of the hashes of users
+func dummyHash(users map[string][]byte) ([]byte, error) {
+	cost := bcrypt.MinCost
+	for _, hash := range users {
+		if hashCost, err := bcrypt.Cost(hash); err == nil && hashCost > cost {
+			cost = hashCost
+		}
+	}
+	return bcrypt.GenerateFromPassword([]byte("goFastCache unknown user"), cost)
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// TLSConfig returns the server settings requesting client certificates, if client certificates are enabled.
+// Clients without a certificate can still use the other methods.
+func (a *Authenticator) TLSConfig() *tls.Config {
+	if a == nil || a.clientCAs == nil {
+		return nil
+	}
+	return &tls.Config{
+		ClientCAs:  a.clientCAs,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+var errUnauthenticated = errors.New("authentication required")
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticate returns the identity of the client sending r.
+// Credentials in the Authorization header take precedence over a client certificate.
+func (a *Authenticator) Authenticate(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, credentials, _ := strings.Cut(header, " ")
+		switch strings.ToLower(scheme) {
+		case "basic":
+			user, password, ok := r.BasicAuth()
+			if !ok || !a.checkPassword(user, password) {
+				return "", errInvalidCredentials
+			}
+			return user, nil
+		case "bearer":
+			identity, found := a.tokens[hashToken(strings.TrimSpace(credentials))]
+			if !found {
+				return "", errInvalidCredentials
+			}
+			return identity, nil
+		default:
+			return "", errInvalidCredentials
+		}
+	}
+	if a.clientCAs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		identity := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if identity != "" {
+			return identity, nil
+		}
+	}
+	return "", errUnauthenticated
+}
+
+func (a *Authenticator) checkPassword(user, password string) bool {
+	hash, found := a.users[user]
+	if !found {
+		// Which users exist must not show in how long rejecting them takes
+		_ = bcrypt.CompareHashAndPassword(a.unknownUserHash, []byte(password))
+		return false
+	}
+	// The key depends on the hash too, so it only matches the password it was verified with
+	key := hashToken(user + "\x00" + password + "\x00" + string(hash))
+	if _, found := a.verified.Get(key); found {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	a.verified.Set(key, user)
+	return true
+}
+
+// Allowed reports whether identity may access the module at modulePath
+func (a *Authenticator) Allowed(identity, modulePath string) bool {
+	if len(a.rules) == 0 {
+		return true
+	}
+	patterns, found := a.rules[identity]
+	if !found {
+		patterns, found = a.rules["*"]
+	}
+	return found && patterns != "" && module.MatchPrefixPatterns(patterns, modulePath)
+}
+
+// Middleware rejects unauthenticated clients with 401 and clients accessing modules outside their rules with 403.
+// modulePath returns the module path a request is for, or "" if it is for no module.
+// Requests for the public paths pass without authentication.
+func (a *Authenticator) Middleware(modulePath func(c *gin.Context) string, public ...string) gin.HandlerFunc {
+	publicPaths := make(map[string]bool)
+	for _, p := range public {
+		publicPaths[p] = true
+	}
+	return func(c *gin.Context) {
+		if publicPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		identity, err := a.Authenticate(c.Request)
+		if err != nil {
+			zap.S().Debugf("Rejected client %s: %v", c.ClientIP(), err)
+			c.Header("WWW-Authenticate", `Basic realm="goFastCache"`)
+			c.String(401, err.Error())
+			c.Abort()
+			return
+		}
+		c.Set(identityKey, identity)
+		c.Set(authenticatorKey, a)
+		if path := modulePath(c); path != "" && !a.Allowed(identity, path) {
+			zap.S().Debugf("Denied %s access to %s", identity, path)
+			c.String(403, fmt.Sprintf("%s may not access %s", identity, path))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Identity returns the identity the request was authenticated as, or "" if authentication is disabled
+func Identity(c *gin.Context) string {
+	return c.GetString(identityKey)
+}
+
+// Allows reports whether the client of c may access the module at modulePath.
+// Handlers reporting on several modules use it to leave out the ones outside the rules of the client.
+// Every module is allowed if authentication is disabled.
+func Allows(c *gin.Context, modulePath string) bool {
+	a, found := c.Get(authenticatorKey)
+	if !found {
+		return true
+	}
+	return a.(*Authenticator).Allowed(Identity(c), modulePath)
+}
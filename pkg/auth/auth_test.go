@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/logger"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeUsers(t *testing.T, users map[string]string) string {
+	var lines []string
+	for user, password := range users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		assert.NoError(t, err)
+		lines = append(lines, user+":"+string(hash))
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	assert.NoError(t, os.WriteFile(path, []byte("# user:bcrypt-hash\n\n"+strings.Join(lines, "\n")+"\n"), 0o600))
+	return path
+}
+
+// newTestEngine serves the module path of every request, which is the request path without the leading slash
+func newTestEngine(a *Authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(a.Middleware(func(c *gin.Context) string {
+		path := strings.TrimPrefix(c.Request.URL.Path, "/")
+		if !strings.Contains(path, ".") {
+			return ""
+		}
+		return path
+	}, "/healthz"))
+	engine.GET("/*TRAIL", func(c *gin.Context) {
+		c.String(200, Identity(c))
+	})
+	return engine
+}
+
+func serve(engine *gin.Engine, path string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if setup != nil {
+		setup(request)
+	}
+	engine.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestNew_Disabled(t *testing.T) {
+	a, err := New(Config{Rules: "*=example.com"})
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+	assert.Nil(t, a.TLSConfig())
+}
+
+func TestMiddleware_Basic(t *testing.T) {
+	logger.InitLogger()
+	a, err := New(Config{UsersFile: writeUsers(t, map[string]string{"alice": "wonderland"})})
+	assert.NoError(t, err)
+	engine := newTestEngine(a)
+
+	// Verified passwords are remembered, so the second request skips bcrypt
+	for i := 0; i < 2; i++ {
+		recorder := serve(engine, "/example.com/a", func(r *http.Request) { r.SetBasicAuth("alice", "wonderland") })
+		assert.Equal(t, recorder.Code, 200)
+		assert.Equal(t, recorder.Body.String(), "alice")
+	}
+
+	for _, credentials := range [][2]string{{"alice", "wrong"}, {"bob", "wonderland"}, {"", ""}} {
+		recorder := serve(engine, "/example.com/a", func(r *http.Request) { r.SetBasicAuth(credentials[0], credentials[1]) })
+		assert.Equal(t, recorder.Code, 401)
+	}
+	recorder := serve(engine, "/example.com/a", nil)
+	assert.Equal(t, recorder.Code, 401)
+	assert.Equal(t, recorder.Header().Get("WWW-Authenticate"), `Basic realm="goFastCache"`)
+
+	// Public paths need no credentials
+	recorder = serve(engine, "/healthz", nil)
+	assert.Equal(t, recorder.Code, 200)
+}
+
+func TestDummyHash(t *testing.T) {
+	cheap, err := bcrypt.GenerateFromPassword([]byte("wonderland"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	costly, err := bcrypt.GenerateFromPassword([]byte("looking-glass"), bcrypt.MinCost+2)
+	assert.NoError(t, err)
+
+	// Unknown users cost as much as the most costly known user
+	hash, err := dummyHash(map[string][]byte{"alice": cheap, "bob": costly})
+	assert.NoError(t, err)
+	cost, err := bcrypt.Cost(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, cost, bcrypt.MinCost+2)
+
+	hash, err = dummyHash(nil)
+	assert.NoError(t, err)
+	cost, err = bcrypt.Cost(hash)
+	assert.NoError(t, err)
+	assert.Equal(t, cost, bcrypt.MinCost)
+}
+
+func TestMiddleware_Bearer(t *testing.T) {
+	logger.InitLogger()
+	a, err := New(Config{Tokens: "ci=secret-ci, deploy=secret-deploy"})
+	assert.NoError(t, err)
+	engine := newTestEngine(a)
+
+	recorder := serve(engine, "/example.com/a", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-deploy") })
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "deploy")
+	for _, header := range []string{"Bearer wrong", "Bearer ", "Token secret-ci"} {
+		recorder = serve(engine, "/example.com/a", func(r *http.Request) { r.Header.Set("Authorization", header) })
+		assert.Equal(t, recorder.Code, 401)
+	}
+}
+
+func TestMiddleware_Rules(t *testing.T) {
+	logger.InitLogger()
+	a, err := New(Config{
+		Tokens: "alice=a,ci=c,guest=g",
+		Rules:  "alice=example.com/team-a,github.com; ci = *; *=github.com/public",
+	})
+	assert.NoError(t, err)
+	engine := newTestEngine(a)
+
+	for _, test := range []struct {
+		token    string
+		path     string
+		expected int
+	}{
+		{"a", "/example.com/team-a/tool", 200},
+		{"a", "/github.com/org/repo", 200},
+		{"a", "/example.com/team-b/tool", 403},
+		{"c", "/example.com/team-b/tool", 200},
+		{"g", "/github.com/public/repo", 200},
+		{"g", "/github.com/org/repo", 403},
+		// Requests for no module are allowed for every identity
+		{"g", "/metrics", 200},
+	} {
+		recorder := serve(engine, test.path, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+test.token) })
+		assert.Equal(t, recorder.Code, test.expected)
+	}
+}
+
+// newCertificate returns a certificate for commonName signed by parent, or a self-signed CA if parent is nil
+func newCertificate(t *testing.T, commonName string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, any(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestMiddleware_ClientCertificate(t *testing.T) {
+	logger.InitLogger()
+	ca := newCertificate(t, "test ca", nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600))
+	a, err := New(Config{ClientCA: caFile, Rules: "build-agent=example.com"})
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(newTestEngine(a))
+	server.TLS = a.TLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	request := func(certificates ...tls.Certificate) *http.Response {
+		// Every request needs its own connection, as the certificate is only sent in the handshake
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certificates
+		client := &http.Client{Transport: transport}
+		response, err := client.Get(server.URL + "/example.com/a")
+		assert.NoError(t, err)
+		_ = response.Body.Close()
+		return response
+	}
+	assert.Equal(t, request(newCertificate(t, "build-agent", &ca)).StatusCode, 200)
+	assert.Equal(t, request(newCertificate(t, "intruder", &ca)).StatusCode, 403)
+	// Without a certificate the other methods are still available
+	assert.Equal(t, request().StatusCode, 401)
+}
+
+func TestLoadUsers_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	for _, content := range []string{"alice\n", "alice:plaintext\n", ":$2a$10$abc\n"} {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := New(Config{UsersFile: path})
+		assert.Error(t, err)
+	}
+	_, err := New(Config{UsersFile: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Config{Tokens: "ci=secret", Rules: "ci=example.com;*="}.Validate())
+	for _, cfg := range []Config{
+		{Tokens: "secret"},
+		{Tokens: "a=secret,b=secret"},
+		{Rules: "example.com"},
+		{Rules: "a=example.com;a=github.com"},
+	} {
+		assert.Error(t, cfg.Validate())
+	}
+}
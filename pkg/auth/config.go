@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config selects how proxy clients authenticate, every client is allowed if no method is configured
+type Config struct {
+	// UsersFile lists the users of HTTP basic authentication as user:bcrypt-hash lines, like `htpasswd -B` writes them
+	UsersFile string `yaml:"users_file" env:"AUTH_USERS_FILE"`
+	// Tokens are static bearer tokens as comma separated identity=token entries
+	Tokens string `yaml:"tokens" env:"AUTH_TOKENS"`
+	// ClientCA is a PEM file with the CAs client certificates are verified against, it requires TLS.
+	// The identity of a certificate is its subject common name.
+	ClientCA string `yaml:"client_ca" env:"AUTH_CLIENT_CA"`
+	// Rules restrict identities to module paths, as semicolon separated identity=patterns entries with GOPRIVATE style patterns.
+	// The identity "*" applies to every identity without an entry. Without rules every identity may access every module.
+	Rules string `yaml:"rules" env:"AUTH_RULES"`
+	// ProtectMetrics requires credentials for /metrics too, by default it is public like the probes so scrapers need none
+	ProtectMetrics bool `yaml:"protect_metrics" env:"AUTH_PROTECT_METRICS"`
+}
+
+// Enabled reports whether clients have to authenticate
+func (cfg Config) Enabled() bool {
+	return cfg.UsersFile != "" || cfg.Tokens != "" || cfg.ClientCA != ""
+}
+
+func (cfg Config) Validate() error {
+	_, err := parseTokens(cfg.Tokens)
+	if err != nil {
+		return err
+	}
+	_, err = parseRules(cfg.Rules)
+	return err
+}
+
+// parseTokens returns the identity of every token
+func parseTokens(tokensX string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(tokensX, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		identity, token, found := strings.Cut(entry, "=")
+		if !found || identity == "" || token == "" {
+			return nil, fmt.Errorf("invalid bearer token entry for %q, expected identity=token", identity)
+		}
+		if _, found = tokens[token]; found {
+			return nil, fmt.Errorf("bearer token of %s is used twice", identity)
+		}
+		tokens[token] = identity
+	}
+	return tokens, nil
+}
+
+// parseRules returns the module path patterns of every identity
+func parseRules(rulesX string) (map[string]string, error) {
+	rules := make(map[string]string)
+	for _, entry := range strings.Split(rulesX, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		identity, patterns, found := strings.Cut(entry, "=")
+		identity = strings.TrimSpace(identity)
+		if !found || identity == "" {
+			return nil, fmt.Errorf("invalid access rule %q, expected identity=patterns", entry)
+		}
+		if _, found = rules[identity]; found {
+			return nil, fmt.Errorf("access rule of %s is defined twice", identity)
+		}
+		rules[identity] = strings.TrimSpace(patterns)
+	}
+	return rules, nil
+}
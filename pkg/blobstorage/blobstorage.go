@@ -3,6 +3,7 @@ package blobstorage
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"github.com/goccy/go-json"
@@ -10,51 +11,39 @@ import (
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/minio/sha256-simd"
 	"go.uber.org/zap"
-	"os"
+	"hash"
+	"io"
 	"strings"
 )
 
+// streamPartSize bounds the memory used by multipart uploads of unknown size
+const streamPartSize = 16 * 1024 * 1024
+
+// uploadPrefix is where streamed objects are staged until their checksum is known
+const uploadPrefix = "uploads/"
+
 type Blobstore struct {
 	MinioClient *minio.Client
 	BucketName  string
 }
 
-func NewBlobstore() (*Blobstore, error) {
+func NewBlobstore(cfg MinioConfig) (*Blobstore, error) {
 	zap.S().Info("Connecting to Minio")
-	minioAccessKey, found := os.LookupEnv("MINIO_ACCESS_KEY")
-	if !found {
-		return nil, errors.New("MINIO_ACCESS_KEY not found")
-	}
-	minioAccessKey = strings.Trim(minioAccessKey, "\n\r")
-
-	minioSecretKey, found := os.LookupEnv("MINIO_SECRET_KEY")
-	if !found {
-		return nil, errors.New("MINIO_SECRET_KEY not found")
-	}
-	minioSecretKey = strings.Trim(minioSecretKey, "\n\r")
-
-	minioBucketName, found := os.LookupEnv("MINIO_BUCKET_NAME")
-	if !found {
-		return nil, errors.New("MINIO_BUCKET_NAME not found")
-	}
-	minioBucketName = strings.Trim(minioBucketName, "\n\r")
-
-	minioDomain, found := os.LookupEnv("MINIO_DOMAIN")
-	if !found {
-		return nil, errors.New("MINIO_DOMAIN not found")
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
 	}
-	minioDomain = strings.Trim(minioDomain, "\n\r")
 
-	minioClient, err := minio.New(minioDomain, &minio.Options{
-		Creds:  credentials.NewStaticV4(minioAccessKey, minioSecretKey, ""),
-		Secure: false,
+	minioClient, err := minio.New(cfg.Domain, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	b := &Blobstore{
-		BucketName:  minioBucketName,
+		BucketName:  cfg.BucketName,
 		MinioClient: minioClient,
 	}
 	err = b.createBucket()
@@ -82,6 +71,17 @@ func (b *Blobstore) createBucket() error {
 	return nil
 }
 
+func (b *Blobstore) Ping(ctx context.Context) error {
+	exists, err := b.MinioClient.BucketExists(ctx, b.BucketName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("bucket " + b.BucketName + " does not exist")
+	}
+	return nil
+}
+
 func (b *Blobstore) PutString(object string, path string) (info minio.UploadInfo, err error) {
 	return b.putStream([]byte(object), path, "text/plain")
 }
@@ -111,41 +111,108 @@ func (b *Blobstore) putStream(object []byte, path, contentType string) (info min
 }
 
 func (b *Blobstore) getObject(path string) (object []byte, err error) {
-	var objectX *minio.Object
-	objectX, err = b.MinioClient.GetObject(context.Background(), b.BucketName, path, minio.GetObjectOptions{})
+	var reader io.ReadCloser
+	reader, _, err = b.getObjectReader(path)
 	if err != nil {
 		return nil, err
 	}
-	objectInfo, err := objectX.Stat()
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// getObjectReader opens the object for streaming.
+// The checksum is verified incrementally, the final Read returns an error instead of io.EOF if it does not match.
+func (b *Blobstore) getObjectReader(path string) (io.ReadCloser, int64, error) {
+	objectX, err := b.MinioClient.GetObject(context.Background(), b.BucketName, path, minio.GetObjectOptions{})
 	if err != nil {
-		return nil, err
-	}
-	object = make([]byte, objectInfo.Size)
-	var read int
-	read, err = objectX.Read(object)
-	if err != nil && err.Error() != "EOF" {
-		return nil, err
+		return nil, 0, err
 	}
-	if int64(read) != objectInfo.Size {
-		return nil, errors.New("unable to read entire object")
+	objectInfo, err := objectX.Stat()
+	if err != nil {
+		_ = objectX.Close()
+		return nil, 0, err
 	}
 
 	// Verify the checksum if it exists
 	sha256Sum := objectInfo.Metadata.Get("X-Amz-Meta-Sha256")
 	if sha256Sum == "" {
-		return nil, errors.New("checksum not found")
+		_ = objectX.Close()
+		return nil, 0, errors.New("checksum not found")
 	}
 
-	// Calculate the checksum
-	sha256SumCalculated := sha256.Sum256(object)
-	sha256SumCalculatedString := hex.EncodeToString(sha256SumCalculated[:])
+	return &verifyingReader{
+		reader:   objectX,
+		hash:     sha256.New(),
+		expected: sha256Sum,
+		size:     objectInfo.Size,
+	}, objectInfo.Size, nil
+}
 
-	// Compare the checksums
-	if sha256Sum != sha256SumCalculatedString {
-		return nil, errors.New("checksums do not match")
+// putObjectReader streams reader into path.
+// Since the metadata of an object can only be set when it is created, the object is staged below uploadPrefix
+// while its checksum is calculated, and then copied to its final path with the checksum attached.
+func (b *Blobstore) putObjectReader(reader io.Reader, size int64, path, contentType string) (info minio.UploadInfo, err error) {
+	sha256Hash := sha256.New()
+	// Every upload has its own staging object, so concurrent uploads of path never copy or remove each other's
+	suffix := make([]byte, 16)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return minio.UploadInfo{}, err
 	}
+	stagingPath := uploadPrefix + path + "." + hex.EncodeToString(suffix)
+	_, err = b.MinioClient.PutObject(context.Background(), b.BucketName, stagingPath, io.TeeReader(reader, sha256Hash), size, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    streamPartSize,
+	})
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer func() {
+		errX := b.removeObject(stagingPath)
+		if errX != nil {
+			zap.S().Warnf("Unable to remove staged object %s: %v", stagingPath, errX)
+		}
+	}()
+
+	return b.MinioClient.CopyObject(context.Background(), minio.CopyDestOptions{
+		Bucket: b.BucketName,
+		Object: path,
+		UserMetadata: map[string]string{
+			"X-Amz-Meta-Sha256": hex.EncodeToString(sha256Hash.Sum(nil)),
+		},
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket: b.BucketName,
+		Object: stagingPath,
+	})
+}
 
-	return object, nil
+// verifyingReader hashes everything read from reader and compares the result with expected once reader is drained
+type verifyingReader struct {
+	reader   io.ReadCloser
+	hash     hash.Hash
+	expected string
+	size     int64
+	read     int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.reader.Read(p)
+	v.hash.Write(p[:n])
+	v.read += int64(n)
+	if err == io.EOF {
+		if v.read != v.size {
+			return n, errors.New("unable to read entire object")
+		}
+		if hex.EncodeToString(v.hash.Sum(nil)) != v.expected {
+			return n, errors.New("checksums do not match")
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.reader.Close()
 }
 
 func (b *Blobstore) removeObject(path string) error {
@@ -164,3 +231,58 @@ func (b *Blobstore) Put(key string, value []byte) error {
 	_, err := b.PutBytes(value, key)
 	return err
 }
+
+// GetReader opens the object stored at key for streaming, the caller has to close it.
+func (b *Blobstore) GetReader(key string) (io.ReadCloser, int64, bool) {
+	reader, size, err := b.getObjectReader(key)
+	if err != nil {
+		return nil, 0, false
+	}
+	return reader, size, true
+}
+
+// PutReader streams reader into key, size may be -1 if unknown.
+func (b *Blobstore) PutReader(key string, reader io.Reader, size int64) error {
+	_, err := b.putObjectReader(reader, size, key, "application/octet-stream")
+	return err
+}
+
+func (b *Blobstore) Stat(key string) (ObjectInfo, bool, error) {
+	objectInfo, err := b.MinioClient.StatObject(context.Background(), b.BucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ObjectInfo{}, false, nil
+		}
+		return ObjectInfo{}, false, err
+	}
+	return ObjectInfo{
+		Key:          objectInfo.Key,
+		Size:         objectInfo.Size,
+		LastModified: objectInfo.LastModified,
+	}, true, nil
+}
+
+func (b *Blobstore) Delete(key string) error {
+	return b.removeObject(key)
+}
+
+func (b *Blobstore) List(prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	for objectInfo := range b.MinioClient.ListObjects(context.Background(), b.BucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if objectInfo.Err != nil {
+			return nil, objectInfo.Err
+		}
+		if strings.HasPrefix(objectInfo.Key, uploadPrefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          objectInfo.Key,
+			Size:         objectInfo.Size,
+			LastModified: objectInfo.LastModified,
+		})
+	}
+	return objects, nil
+}
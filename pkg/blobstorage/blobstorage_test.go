@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/sha256-simd"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -56,12 +57,22 @@ func envFileToEnv() error {
 	return nil
 }
 
+// minioConfigFromEnv reads the connection settings loaded from .env.tests
+func minioConfigFromEnv() MinioConfig {
+	return MinioConfig{
+		AccessKey:  os.Getenv("MINIO_ACCESS_KEY"),
+		SecretKey:  os.Getenv("MINIO_SECRET_KEY"),
+		BucketName: os.Getenv("MINIO_BUCKET_NAME"),
+		Domain:     os.Getenv("MINIO_DOMAIN"),
+	}
+}
+
 func TestBlobstore_PutObject(t *testing.T) {
 	err := envFileToEnv()
 	if err != nil {
 		t.Fatalf("Error loading .env file: %v", err)
 	}
-	blobstore, err := NewBlobstore()
+	blobstore, err := NewBlobstore(minioConfigFromEnv())
 	if err != nil {
 		t.Fatalf("Error creating blobstore: %v", err)
 	}
@@ -105,7 +116,7 @@ func TestBlobstore_NewBlobstore(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error loading .env file: %v", err)
 	}
-	_, err = NewBlobstore()
+	_, err = NewBlobstore(minioConfigFromEnv())
 	if err != nil {
 		t.Fatalf("Error creating blobstore: %v", err)
 	}
@@ -116,7 +127,7 @@ func TestBlobstore_GetObject(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error loading .env file: %v", err)
 	}
-	blobstore, err := NewBlobstore()
+	blobstore, err := NewBlobstore(minioConfigFromEnv())
 	if err != nil {
 		t.Fatalf("Error creating blobstore: %v", err)
 	}
@@ -137,7 +148,7 @@ func TestBlobstore_RemoveObject(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error loading .env file: %v", err)
 	}
-	blobstore, err := NewBlobstore()
+	blobstore, err := NewBlobstore(minioConfigFromEnv())
 	if err != nil {
 		t.Fatalf("Error creating blobstore: %v", err)
 	}
@@ -163,7 +174,7 @@ func TestBlobstore_NoChecksum(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error loading .env file: %v", err)
 	}
-	blobstore, err := NewBlobstore()
+	blobstore, err := NewBlobstore(minioConfigFromEnv())
 	if err != nil {
 		t.Fatalf("Error creating blobstore: %v", err)
 	}
@@ -197,7 +208,7 @@ func TestBlobstore_WrongChecksum(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error loading .env file: %v", err)
 	}
-	blobstore, err := NewBlobstore()
+	blobstore, err := NewBlobstore(minioConfigFromEnv())
 	if err != nil {
 		t.Fatalf("Error creating blobstore: %v", err)
 	}
@@ -232,3 +243,45 @@ func TestBlobstore_WrongChecksum(t *testing.T) {
 	}
 	fmt.Printf("Error: %v\n", err)
 }
+
+func TestBlobstore_PutGetReader(t *testing.T) {
+	err := envFileToEnv()
+	if err != nil {
+		t.Fatalf("Error loading .env file: %v", err)
+	}
+	blobstore, err := NewBlobstore(minioConfigFromEnv())
+	if err != nil {
+		t.Fatalf("Error creating blobstore: %v", err)
+	}
+	// Larger than a single part, with unknown size
+	testData := strings.Repeat("test data for streams", streamPartSize/10)
+	path := "testreader/a/b/c/d/reader.zip"
+	err = blobstore.PutReader(path, strings.NewReader(testData), -1)
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+
+	reader, size, found := blobstore.GetReader(path)
+	if !found {
+		t.Fatalf("Object not found")
+	}
+	defer reader.Close()
+	if size != int64(len(testData)) {
+		t.Fatalf("Expected size %d, got %d", len(testData), size)
+	}
+	object, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Error reading object: %v", err)
+	}
+	if string(object) != testData {
+		t.Fatalf("Object does not match")
+	}
+
+	// The staged upload must be gone
+	for objectInfo := range blobstore.MinioClient.ListObjects(context.Background(), blobstore.BucketName, minio.ListObjectsOptions{
+		Prefix:    uploadPrefix + path,
+		Recursive: true,
+	}) {
+		t.Fatalf("Staged object %s was not removed: %v", objectInfo.Key, objectInfo.Err)
+	}
+}
@@ -0,0 +1,218 @@
+package blobstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"github.com/minio/sha256-simd"
+	"go.uber.org/zap"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// headerSize is the size of the header every object file starts with: the hex encoded sha256 of the object and a newline.
+// Keeping the checksum in the same file lets a single rename replace an object together with its checksum.
+const headerSize = 2*sha256.Size + 1
+
+// tempPrefix marks files that are still being written
+const tempPrefix = ".upload-"
+
+// Filesystem stores objects as files below Root, using the key as relative path.
+// Every file starts with the checksum of its object.
+type Filesystem struct {
+	Root string
+}
+
+func NewFilesystem(root string) (*Filesystem, error) {
+	zap.S().Infof("Using filesystem storage at %s", root)
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &Filesystem{
+		Root: root,
+	}, nil
+}
+
+func (f *Filesystem) objectPath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned[1:] != key || strings.HasPrefix(path.Base(key), tempPrefix) {
+		return "", errors.New("invalid key " + key)
+	}
+	return filepath.Join(f.Root, filepath.FromSlash(key)), nil
+}
+
+func (f *Filesystem) Get(key string) ([]byte, bool) {
+	reader, _, found := f.GetReader(key)
+	if !found {
+		return nil, false
+	}
+	defer reader.Close()
+	object, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, false
+	}
+	return object, true
+}
+
+func (f *Filesystem) Put(key string, value []byte) error {
+	return f.PutReader(key, bytes.NewReader(value), int64(len(value)))
+}
+
+func (f *Filesystem) GetReader(key string) (io.ReadCloser, int64, bool) {
+	objectPath, err := f.objectPath(key)
+	if err != nil {
+		return nil, 0, false
+	}
+	file, err := os.Open(objectPath)
+	if err != nil {
+		return nil, 0, false
+	}
+	header := make([]byte, headerSize)
+	_, err = io.ReadFull(file, header)
+	if err != nil || header[headerSize-1] != '\n' {
+		_ = file.Close()
+		return nil, 0, false
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, false
+	}
+	size := stat.Size() - headerSize
+	return &verifyingReader{
+		reader:   file,
+		hash:     sha256.New(),
+		expected: string(header[:headerSize-1]),
+		size:     size,
+	}, size, true
+}
+
+// PutReader writes the object behind its checksum header to a temporary file first, and renames it once complete,
+// so readers never see partial objects or checksums of other objects.
+func (f *Filesystem) PutReader(key string, reader io.Reader, size int64) error {
+	objectPath, err := f.objectPath(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(objectPath)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	// The header is filled in once the checksum is known
+	_, err = file.Seek(headerSize, io.SeekStart)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	sha256Hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, sha256Hash), reader)
+	if err == nil {
+		_, err = file.WriteAt([]byte(hex.EncodeToString(sha256Hash.Sum(nil))+"\n"), 0)
+	}
+	if errX := file.Close(); err == nil {
+		err = errX
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return errors.New("unable to write entire object")
+	}
+	return os.Rename(file.Name(), objectPath)
+}
+
+func (f *Filesystem) Stat(key string) (ObjectInfo, bool, error) {
+	objectPath, err := f.objectPath(key)
+	if err != nil {
+		return ObjectInfo{}, false, err
+	}
+	stat, err := os.Stat(objectPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, false, nil
+		}
+		return ObjectInfo{}, false, err
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size() - headerSize,
+		LastModified: stat.ModTime(),
+	}, true, nil
+}
+
+func (f *Filesystem) Delete(key string) error {
+	objectPath, err := f.objectPath(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(objectPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *Filesystem) Ping(ctx context.Context) error {
+	info, err := os.Stat(f.Root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(f.Root + " is not a directory")
+	}
+	return nil
+}
+
+func (f *Filesystem) List(prefix string) ([]ObjectInfo, error) {
+	// Only walk the directory the prefix points into
+	walkRoot := f.Root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		walkRoot = filepath.Join(f.Root, filepath.FromSlash(prefix[:i]))
+	}
+
+	objects := make([]ObjectInfo, 0)
+	err := filepath.WalkDir(walkRoot, func(objectPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		relative, err := filepath.Rel(f.Root, objectPath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size() - headerSize,
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	return objects, err
+}
@@ -0,0 +1,208 @@
+package blobstorage
+
+import (
+	"goFastCache/pkg/hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilesystem_PutGet(t *testing.T) {
+	filesystem, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+	key := hash.GetZipPath("github.com/jinzhu/inflection", "v1.0.0")
+	testData := "test data"
+	err = filesystem.Put(key, []byte(testData))
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+
+	// Objects use the sharded layout of their key
+	_, err = os.Stat(filepath.Join(filesystem.Root, filepath.FromSlash(key)))
+	if err != nil {
+		t.Fatalf("Object not stored at its key: %v", err)
+	}
+
+	object, found := filesystem.Get(key)
+	if !found {
+		t.Fatalf("Object not found")
+	}
+	if string(object) != testData {
+		t.Fatalf("Expected %s, got %s", testData, object)
+	}
+
+	info, found, err := filesystem.Stat(key)
+	if err != nil || !found {
+		t.Fatalf("Error getting stat: %v", err)
+	}
+	if info.Size != int64(len(testData)) || info.Key != key {
+		t.Fatalf("Unexpected stat %+v", info)
+	}
+}
+
+func TestFilesystem_Reader(t *testing.T) {
+	filesystem, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+	testData := strings.Repeat("test data for streams", 1024)
+	err = filesystem.PutReader("a/b/reader.zip", strings.NewReader(testData), -1)
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+	reader, size, found := filesystem.GetReader("a/b/reader.zip")
+	if !found {
+		t.Fatalf("Object not found")
+	}
+	defer reader.Close()
+	if size != int64(len(testData)) {
+		t.Fatalf("Expected size %d, got %d", len(testData), size)
+	}
+	object, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Error reading object: %v", err)
+	}
+	if string(object) != testData {
+		t.Fatalf("Object does not match")
+	}
+
+	// A short stream must not be stored
+	err = filesystem.PutReader("a/b/short.zip", strings.NewReader(testData), int64(len(testData)+1))
+	if err == nil {
+		t.Fatalf("Expected error for short stream")
+	}
+	_, found = filesystem.Get("a/b/short.zip")
+	if found {
+		t.Fatalf("Short object was stored")
+	}
+}
+
+func TestFilesystem_WrongChecksum(t *testing.T) {
+	filesystem, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+	err = filesystem.Put("a/b/c.txt", []byte("test data"))
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(filesystem.Root, "a", "b", "c.txt"), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Error opening object: %v", err)
+	}
+	_, err = file.WriteAt([]byte("T"), headerSize)
+	if errX := file.Close(); err == nil {
+		err = errX
+	}
+	if err != nil {
+		t.Fatalf("Error modifying object: %v", err)
+	}
+	reader, _, found := filesystem.GetReader("a/b/c.txt")
+	if !found {
+		t.Fatalf("Object not found")
+	}
+	defer reader.Close()
+	_, err = io.ReadAll(reader)
+	if err == nil || err.Error() != "checksums do not match" {
+		t.Fatalf("Expected SHA256 mismatch error, got %v", err)
+	}
+}
+
+func TestFilesystem_Replace(t *testing.T) {
+	filesystem, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+	err = filesystem.Put("a/b/c.txt", []byte("test data"))
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+
+	// A reader of the old object keeps its checksum while the object is replaced
+	reader, _, found := filesystem.GetReader("a/b/c.txt")
+	if !found {
+		t.Fatalf("Object not found")
+	}
+	defer reader.Close()
+	err = filesystem.Put("a/b/c.txt", []byte("replaced test data"))
+	if err != nil {
+		t.Fatalf("Error replacing object: %v", err)
+	}
+	object, err := io.ReadAll(reader)
+	if err != nil || string(object) != "test data" {
+		t.Fatalf("Expected old object, got %q (%v)", object, err)
+	}
+
+	object, found = filesystem.Get("a/b/c.txt")
+	if !found || string(object) != "replaced test data" {
+		t.Fatalf("Expected replaced object, got %q", object)
+	}
+
+	// Files without a checksum are misses
+	err = os.WriteFile(filepath.Join(filesystem.Root, "a", "b", "c.txt"), []byte("test data"), 0o644)
+	if err != nil {
+		t.Fatalf("Error writing object: %v", err)
+	}
+	_, found = filesystem.Get("a/b/c.txt")
+	if found {
+		t.Fatalf("Object without checksum was found")
+	}
+}
+
+func TestFilesystem_ListDelete(t *testing.T) {
+	filesystem, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+	for _, key := range []string{"a/b/v1.0.0.info", "a/b/v1.0.0.mod", "a/bc/v1.0.0.info", "c/v1.0.0.info"} {
+		err = filesystem.Put(key, []byte(key))
+		if err != nil {
+			t.Fatalf("Error putting object: %v", err)
+		}
+	}
+
+	objects, err := filesystem.List("a/b")
+	if err != nil {
+		t.Fatalf("Error listing objects: %v", err)
+	}
+	if len(objects) != 3 {
+		t.Fatalf("Expected 3 objects, got %+v", objects)
+	}
+	objects, err = filesystem.List("a/b/")
+	if err != nil {
+		t.Fatalf("Error listing objects: %v", err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("Expected 2 objects, got %+v", objects)
+	}
+	objects, err = filesystem.List("does/not/exist/")
+	if err != nil || len(objects) != 0 {
+		t.Fatalf("Expected no objects, got %+v (%v)", objects, err)
+	}
+
+	err = filesystem.Delete("a/b/v1.0.0.mod")
+	if err != nil {
+		t.Fatalf("Error deleting object: %v", err)
+	}
+	_, found, err := filesystem.Stat("a/b/v1.0.0.mod")
+	if err != nil || found {
+		t.Fatalf("Object was not deleted")
+	}
+}
+
+func TestFilesystem_InvalidKey(t *testing.T) {
+	filesystem, err := NewFilesystem(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+	for _, key := range []string{"../escape", "a/../../escape", "/absolute", "a/.upload-b", ""} {
+		err = filesystem.Put(key, []byte("test data"))
+		if err == nil {
+			t.Fatalf("Expected error for key %q", key)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package blobstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps all objects inside the process, they are lost on restart
+type Memory struct {
+	lock    sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data         []byte
+	lastModified time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	object, found := m.objects[key]
+	if !found {
+		return nil, false
+	}
+	data := make([]byte, len(object.data))
+	copy(data, object.data)
+	return data, true
+}
+
+func (m *Memory) Put(key string, value []byte) error {
+	data := make([]byte, len(value))
+	copy(data, value)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.objects[key] = memoryObject{
+		data:         data,
+		lastModified: time.Now(),
+	}
+	return nil
+}
+
+func (m *Memory) GetReader(key string) (io.ReadCloser, int64, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	object, found := m.objects[key]
+	if !found {
+		return nil, 0, false
+	}
+	// Stored slices are never modified, so they can be read without copying
+	return io.NopCloser(bytes.NewReader(object.data)), int64(len(object.data)), true
+}
+
+func (m *Memory) PutReader(key string, reader io.Reader, size int64) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return errors.New("unable to write entire object")
+	}
+	return m.Put(key, data)
+}
+
+func (m *Memory) Stat(key string) (ObjectInfo, bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	object, found := m.objects[key]
+	if !found {
+		return ObjectInfo{}, false, nil
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(object.data)),
+		LastModified: object.lastModified,
+	}, true, nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) List(prefix string) ([]ObjectInfo, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	objects := make([]ObjectInfo, 0)
+	for key, object := range m.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         int64(len(object.data)),
+			LastModified: object.lastModified,
+		})
+	}
+	return objects, nil
+}
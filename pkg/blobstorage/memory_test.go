@@ -0,0 +1,46 @@
+package blobstorage
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemory_PutGet(t *testing.T) {
+	memory := NewMemory()
+	testData := "test data"
+	err := memory.Put("a/b/c.txt", []byte(testData))
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+	object, found := memory.Get("a/b/c.txt")
+	if !found || string(object) != testData {
+		t.Fatalf("Expected %s, got %s", testData, object)
+	}
+
+	err = memory.PutReader("a/b/reader.zip", strings.NewReader(testData), -1)
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+	reader, size, found := memory.GetReader("a/b/reader.zip")
+	if !found || size != int64(len(testData)) {
+		t.Fatalf("Object not found")
+	}
+	object, err = io.ReadAll(reader)
+	if err != nil || string(object) != testData {
+		t.Fatalf("Expected %s, got %s (%v)", testData, object, err)
+	}
+
+	objects, err := memory.List("a/b/")
+	if err != nil || len(objects) != 2 {
+		t.Fatalf("Expected 2 objects, got %+v (%v)", objects, err)
+	}
+	err = memory.Delete("a/b/c.txt")
+	if err != nil {
+		t.Fatalf("Error deleting object: %v", err)
+	}
+	_, found, _ = memory.Stat("a/b/c.txt")
+	if found {
+		t.Fatalf("Object was not deleted")
+	}
+}
@@ -0,0 +1,91 @@
+package blobstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// Storage is implemented by every blob storage backend.
+// Keys are slash separated paths, as returned by the hash package.
+type Storage interface {
+	Get(key string) ([]byte, bool)
+	Put(key string, value []byte) error
+	// GetReader opens the object stored at key for streaming, the caller has to close it.
+	// The checksum is verified while reading, the final Read fails if it does not match.
+	GetReader(key string) (io.ReadCloser, int64, bool)
+	// PutReader streams reader into key, size may be -1 if unknown.
+	PutReader(key string, reader io.Reader, size int64) error
+	Stat(key string) (ObjectInfo, bool, error)
+	Delete(key string) error
+	// List returns every object whose key starts with prefix.
+	List(prefix string) ([]ObjectInfo, error)
+	// Ping checks that the backend is reachable and usable.
+	Ping(ctx context.Context) error
+}
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Config selects and configures the blob storage backend
+type Config struct {
+	// Backend is either "minio" (default), "filesystem" or "memory"
+	Backend string      `yaml:"backend" env:"STORAGE_BACKEND"`
+	Path    string      `yaml:"path" env:"STORAGE_PATH"`
+	Minio   MinioConfig `yaml:"minio"`
+}
+
+type MinioConfig struct {
+	AccessKey  string `yaml:"access_key" env:"MINIO_ACCESS_KEY"`
+	SecretKey  string `yaml:"secret_key" env:"MINIO_SECRET_KEY"`
+	BucketName string `yaml:"bucket_name" env:"MINIO_BUCKET_NAME"`
+	Domain     string `yaml:"domain" env:"MINIO_DOMAIN"`
+	Secure     bool   `yaml:"secure" env:"MINIO_SECURE"`
+}
+
+func (cfg Config) Validate() error {
+	switch cfg.Backend {
+	case "minio":
+		return cfg.Minio.Validate()
+	case "filesystem":
+		if cfg.Path == "" {
+			return errors.New("storage path is required by the filesystem backend")
+		}
+		return nil
+	case "memory":
+		return nil
+	}
+	return errors.New("unknown storage backend " + cfg.Backend)
+}
+
+func (cfg MinioConfig) Validate() error {
+	var errs []error
+	for _, field := range [][2]string{
+		{"access key", cfg.AccessKey},
+		{"secret key", cfg.SecretKey},
+		{"bucket name", cfg.BucketName},
+		{"domain", cfg.Domain},
+	} {
+		if field[1] == "" {
+			errs = append(errs, errors.New("minio "+field[0]+" is required"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewStorage creates the backend selected by cfg.Backend
+func NewStorage(cfg Config) (Storage, error) {
+	switch cfg.Backend {
+	case "minio":
+		return NewBlobstore(cfg.Minio)
+	case "filesystem":
+		return NewFilesystem(cfg.Path)
+	case "memory":
+		return NewMemory(), nil
+	}
+	return nil, errors.New("unknown storage backend " + cfg.Backend)
+}
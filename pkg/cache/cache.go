@@ -2,26 +2,90 @@ package cache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"github.com/redis/go-redis/v9"
-	"os"
-	"strings"
 	"time"
 )
 
-type Cache struct {
+// Cache is a shared key value store with expiring entries
+type Cache interface {
+	Set(key string, value []byte, expiresIn time.Duration) error
+	Get(key string) ([]byte, bool, error)
+	// Lock tries to acquire the lock called key, it is released by Unlock or after expiresIn.
+	// It returns false if the lock is held by someone else.
+	Lock(key string, expiresIn time.Duration) (bool, error)
+	Unlock(key string) error
+	// Ping checks that the backend is reachable.
+	Ping(ctx context.Context) error
+	// Close releases the connection to the backend.
+	Close() error
+}
+
+// lockPrefix separates locks from cached values
+const lockPrefix = "lock:"
+
+// Config selects and configures the cache backend
+type Config struct {
+	// Backend is either "redis" (default) or "memory"
+	Backend string      `yaml:"backend" env:"CACHE_BACKEND"`
+	Redis   RedisConfig `yaml:"redis"`
+}
+
+type RedisConfig struct {
+	Address  string `yaml:"address" env:"REDIS_ADDRESS"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" env:"REDIS_DB"`
+}
+
+func (cfg Config) Validate() error {
+	switch cfg.Backend {
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return errors.New("redis address is required")
+		}
+		return nil
+	case "memory":
+		return nil
+	}
+	return errors.New("unknown cache backend " + cfg.Backend)
+}
+
+// NewCache creates the backend selected by cfg.Backend
+func NewCache(cfg Config) (Cache, error) {
+	switch cfg.Backend {
+	case "redis":
+		return NewRedis(cfg.Redis)
+	case "memory":
+		return NewMemory(), nil
+	}
+	return nil, errors.New("unknown cache backend " + cfg.Backend)
+}
+
+type Redis struct {
 	redis *redis.Client
+	// instanceID is stored in every lock taken by this instance, so it never releases locks of other instances
+	instanceID string
 }
 
-func NewCache() (*Cache, error) {
-	redisAddress, found := os.LookupEnv("REDIS_ADDRESS")
-	if !found {
-		return nil, errors.New("REDIS_ADDRESS not found")
+// unlockScript deletes a lock only if it is still held by the instance passed as argument
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+func NewRedis(cfg RedisConfig) (*Redis, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("redis address is required")
 	}
-	redisAddress = strings.Trim(redisAddress, "\n\r")
 
 	rdb := redis.NewClient(&redis.Options{
-		Addr: redisAddress,
+		Addr:     cfg.Address,
+		Password: cfg.Password,
+		DB:       cfg.DB,
 	})
 
 	ping := rdb.Ping(context.Background())
@@ -29,12 +93,19 @@ func NewCache() (*Cache, error) {
 		return nil, ping.Err()
 	}
 
-	return &Cache{
-		redis: rdb,
+	instanceID := make([]byte, 16)
+	_, err := rand.Read(instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redis{
+		redis:      rdb,
+		instanceID: hex.EncodeToString(instanceID),
 	}, nil
 }
 
-func (c *Cache) Set(key string, value []byte, expiresIn time.Duration) (err error) {
+func (c *Redis) Set(key string, value []byte, expiresIn time.Duration) (err error) {
 	status := c.redis.SetArgs(context.Background(), key, value, redis.SetArgs{
 		ExpireAt: time.Now().Add(expiresIn),
 	})
@@ -44,7 +115,7 @@ func (c *Cache) Set(key string, value []byte, expiresIn time.Duration) (err erro
 	return nil
 }
 
-func (c *Cache) Get(key string) ([]byte, bool, error) {
+func (c *Redis) Get(key string) ([]byte, bool, error) {
 	status := c.redis.Get(context.Background(), key)
 	if status.Err() != nil {
 		return nil, false, status.Err()
@@ -55,3 +126,19 @@ func (c *Cache) Get(key string) ([]byte, bool, error) {
 	}
 	return bytes, true, nil
 }
+
+func (c *Redis) Lock(key string, expiresIn time.Duration) (bool, error) {
+	return c.redis.SetNX(context.Background(), lockPrefix+key, c.instanceID, expiresIn).Result()
+}
+
+func (c *Redis) Ping(ctx context.Context) error {
+	return c.redis.Ping(ctx).Err()
+}
+
+func (c *Redis) Close() error {
+	return c.redis.Close()
+}
+
+func (c *Redis) Unlock(key string) error {
+	return unlockScript.Run(context.Background(), c.redis, []string{lockPrefix + key}, c.instanceID).Err()
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"github.com/united-manufacturing-hub/expiremap/pkg/expiremap"
+	"sync"
+	"time"
+)
+
+// Memory keeps the cache inside the process, it is not shared between replicas
+type Memory struct {
+	entries *expiremap.ExpireMap[string, []byte]
+
+	locksLock sync.Mutex
+	// locks maps held locks to their expiry
+	locks map[string]time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		entries: expiremap.NewEx[string, []byte](time.Minute, time.Minute),
+		locks:   make(map[string]time.Time),
+	}
+}
+
+func (c *Memory) Set(key string, value []byte, expiresIn time.Duration) error {
+	valueX := make([]byte, len(value))
+	copy(valueX, value)
+	c.entries.SetEx(key, valueX, expiresIn)
+	return nil
+}
+
+func (c *Memory) Get(key string) ([]byte, bool, error) {
+	value, found := c.entries.Get(key)
+	if !found {
+		return nil, false, nil
+	}
+	return *value, true, nil
+}
+
+func (c *Memory) Lock(key string, expiresIn time.Duration) (bool, error) {
+	c.locksLock.Lock()
+	defer c.locksLock.Unlock()
+
+	now := time.Now()
+	if expiresAt, found := c.locks[key]; found && now.Before(expiresAt) {
+		return false, nil
+	}
+	c.locks[key] = now.Add(expiresIn)
+	return true, nil
+}
+
+func (c *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *Memory) Close() error {
+	return nil
+}
+
+func (c *Memory) Unlock(key string) error {
+	c.locksLock.Lock()
+	defer c.locksLock.Unlock()
+	delete(c.locks, key)
+	return nil
+}
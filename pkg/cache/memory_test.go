@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"github.com/zeebo/assert"
+	"testing"
+	"time"
+)
+
+func TestMemory_SetGet(t *testing.T) {
+	c := NewMemory()
+	err := c.Set("key", []byte("value"), time.Minute)
+	assert.NoError(t, err)
+
+	value, found, err := c.Get("key")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, string(value), "value")
+
+	_, found, err = c.Get("missing")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestMemory_Expires(t *testing.T) {
+	c := NewMemory()
+	err := c.Set("key", []byte("value"), time.Millisecond)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	_, found, err := c.Get("key")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestMemory_Lock(t *testing.T) {
+	c := NewMemory()
+	acquired, err := c.Lock("key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = c.Lock("key", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	assert.NoError(t, c.Unlock("key"))
+	acquired, err = c.Lock("key", time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Expired locks can be taken over
+	time.Sleep(5 * time.Millisecond)
+	acquired, err = c.Lock("key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// negativePrefix separates negative entries from cached values
+const negativePrefix = "negative:"
+
+// SetNegative remembers that upstream answered key with status and message, e.g. 404 for a missing version
+func SetNegative(c Cache, key string, status int, message string, expiresIn time.Duration) error {
+	return c.Set(negativePrefix+key, []byte(strconv.Itoa(status)+" "+message), expiresIn)
+}
+
+// GetNegative returns the status and message stored by SetNegative
+func GetNegative(c Cache, key string) (status int, message string, found bool, err error) {
+	value, found, err := c.Get(negativePrefix + key)
+	if !found || err != nil {
+		return 0, "", false, err
+	}
+	statusX, message, _ := strings.Cut(string(value), " ")
+	status, err = strconv.Atoi(statusX)
+	if err != nil {
+		return 0, "", false, errors.New("invalid negative cache entry " + key)
+	}
+	return status, message, true, nil
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"github.com/zeebo/assert"
+	"testing"
+	"time"
+)
+
+func TestNegative(t *testing.T) {
+	c := NewMemory()
+	_, _, found, err := GetNegative(c, "key")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	err = SetNegative(c, "key", 410, "not found: unknown revision v1.0.0", time.Minute)
+	assert.NoError(t, err)
+	status, message, found, err := GetNegative(c, "key")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, status, 410)
+	assert.Equal(t, message, "not found: unknown revision v1.0.0")
+
+	// Negative entries never shadow cached values
+	_, found, err = c.Get("key")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
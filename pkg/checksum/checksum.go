@@ -0,0 +1,160 @@
+package checksum
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+	"io"
+	"strings"
+	"sync"
+)
+
+// DefaultKey is the verifier key of sum.golang.org, as used by the go command
+const DefaultKey = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ru18vKjTRJ46ZMIt"
+
+// ErrMismatch is returned if an artifact does not match the hash recorded by the checksum database
+var ErrMismatch = errors.New("checksum mismatch")
+
+// Verifier checks module zips and go.mod files against a checksum database.
+// Every answer of the database is checked against its signed tree head, and every tree head against the previous one.
+type Verifier struct {
+	name   string
+	client *sumdb.Client
+}
+
+// NewVerifier returns a Verifier for the checksum database with the given verifier key,
+// readRemote reads paths like /lookup/<module>@<version>, /latest and /tile/... from the database
+func NewVerifier(key string, readRemote func(path string) ([]byte, error)) (*Verifier, error) {
+	verifier, err := note.NewVerifier(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum database key %q: %w", key, err)
+	}
+	ops := &clientOps{
+		key:        key,
+		readRemote: readRemote,
+		config:     make(map[string][]byte),
+	}
+	return &Verifier{
+		name:   verifier.Name(),
+		client: sumdb.NewClient(ops),
+	}, nil
+}
+
+// Name returns the name of the checksum database, e.g. sum.golang.org
+func (v *Verifier) Name() string {
+	return v.name
+}
+
+// SetSkip excludes modules from verification, patterns use the GONOSUMDB syntax.
+// It has to be called before the first verification.
+func (v *Verifier) SetSkip(patterns string) {
+	v.client.SetGONOSUMDB(patterns)
+}
+
+// VerifyZip checks the h1: hash of the zip of path@version
+func (v *Verifier) VerifyZip(path, version, zipHash string) error {
+	return v.verify(path, version, zipHash)
+}
+
+// VerifyMod checks the go.mod of path@version
+func (v *Verifier) VerifyMod(path, version string, mod []byte) error {
+	modHash, err := HashMod(mod)
+	if err != nil {
+		return err
+	}
+	return v.verify(path, version+"/go.mod", modHash)
+}
+
+func (v *Verifier) verify(path, version, hash string) error {
+	lines, err := v.client.Lookup(path, version)
+	if errors.Is(err, sumdb.ErrGONOSUMDB) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("verifying %s@%s: %w", path, version, err)
+	}
+	want := path + " " + version + " " + hash
+	for _, line := range lines {
+		if line == want {
+			return nil
+		}
+	}
+	zap.S().Errorf("SECURITY ERROR: %s@%s has hash %s, but %s recorded %s", path, version, hash, v.name, strings.Join(lines, ", "))
+	return fmt.Errorf("verifying %s@%s: %w with %s", path, version, ErrMismatch, v.name)
+}
+
+// HashZip returns the h1: hash of a module zip, as recorded in go.sum
+func HashZip(r io.ReaderAt, size int64) (string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+	files := make([]string, 0, len(z.File))
+	zipFiles := make(map[string]*zip.File, len(z.File))
+	for _, file := range z.File {
+		files = append(files, file.Name)
+		zipFiles[file.Name] = file
+	}
+	return dirhash.Hash1(files, func(name string) (io.ReadCloser, error) {
+		return zipFiles[name].Open()
+	})
+}
+
+// HashMod returns the h1: hash of a go.mod file, as recorded in go.sum
+func HashMod(mod []byte) (string, error) {
+	return dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(mod)), nil
+	})
+}
+
+// clientOps keeps the state of the sumdb client in memory, the tiles themselves are cached by readRemote
+type clientOps struct {
+	key        string
+	readRemote func(path string) ([]byte, error)
+
+	configLock sync.Mutex
+	config     map[string][]byte
+}
+
+func (ops *clientOps) ReadRemote(path string) ([]byte, error) {
+	return ops.readRemote(path)
+}
+
+func (ops *clientOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(ops.key), nil
+	}
+	ops.configLock.Lock()
+	defer ops.configLock.Unlock()
+	// An unknown file is an empty tree head, which the client starts from
+	return ops.config[file], nil
+}
+
+func (ops *clientOps) WriteConfig(file string, old, new []byte) error {
+	ops.configLock.Lock()
+	defer ops.configLock.Unlock()
+	if !bytes.Equal(ops.config[file], old) {
+		return sumdb.ErrWriteConflict
+	}
+	ops.config[file] = new
+	return nil
+}
+
+func (ops *clientOps) ReadCache(string) ([]byte, error) {
+	return nil, errors.New("not cached")
+}
+
+func (ops *clientOps) WriteCache(string, []byte) {}
+
+func (ops *clientOps) Log(msg string) {
+	zap.S().Info(msg)
+}
+
+func (ops *clientOps) SecurityError(msg string) {
+	zap.S().Errorf("SECURITY ERROR: %s", msg)
+}
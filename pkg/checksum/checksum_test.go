@@ -0,0 +1,138 @@
+package checksum
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/zeebo/assert"
+	"golang.org/x/mod/sumdb/dirhash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newZip(t *testing.T, files map[string]string) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		assert.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestHashZip(t *testing.T) {
+	zipFile := newZip(t, map[string]string{
+		"example.com/a@v1.0.0/go.mod": "module example.com/a\n",
+		"example.com/a@v1.0.0/a.go":   "package a\n",
+	})
+	zipHash, err := HashZip(bytes.NewReader(zipFile), int64(len(zipFile)))
+	assert.NoError(t, err)
+
+	// Same as the go command computes it
+	zipPath := filepath.Join(t.TempDir(), "a.zip")
+	assert.NoError(t, os.WriteFile(zipPath, zipFile, 0o600))
+	want, err := dirhash.HashZip(zipPath, dirhash.Hash1)
+	assert.NoError(t, err)
+	assert.Equal(t, zipHash, want)
+
+	_, err = HashZip(bytes.NewReader([]byte("no zip")), 6)
+	assert.Error(t, err)
+}
+
+// newTestVerifier returns a Verifier for a local checksum database that knows the given go.sum lines by module@version
+func newTestVerifier(t *testing.T, gosum map[string]string) *Verifier {
+	key, handler, err := NewTestDatabase("sum.example.com", func(path, version string) ([]byte, error) {
+		lines, found := gosum[path+"@"+version]
+		if !found {
+			return nil, errors.New("not found")
+		}
+		return []byte(lines), nil
+	})
+	assert.NoError(t, err)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	verifier, err := NewVerifier(key, func(path string) ([]byte, error) {
+		get, err := http.Get(server.URL + path)
+		if err != nil {
+			return nil, err
+		}
+		defer get.Body.Close()
+		body, err := io.ReadAll(get.Body)
+		if err != nil {
+			return nil, err
+		}
+		if get.StatusCode != 200 {
+			return nil, fmt.Errorf("status %d: %s", get.StatusCode, body)
+		}
+		return body, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, verifier.Name(), "sum.example.com")
+	return verifier
+}
+
+func TestVerifier(t *testing.T) {
+	mod := []byte("module example.com/a\n")
+	modHash, err := HashMod(mod)
+	assert.NoError(t, err)
+	verifier := newTestVerifier(t, map[string]string{
+		"example.com/a@v1.0.0": "example.com/a v1.0.0 h1:zip=\nexample.com/a v1.0.0/go.mod " + modHash + "\n",
+	})
+
+	assert.NoError(t, verifier.VerifyZip("example.com/a", "v1.0.0", "h1:zip="))
+	assert.NoError(t, verifier.VerifyMod("example.com/a", "v1.0.0", mod))
+
+	err = verifier.VerifyZip("example.com/a", "v1.0.0", "h1:other=")
+	assert.True(t, errors.Is(err, ErrMismatch))
+	err = verifier.VerifyMod("example.com/a", "v1.0.0", []byte("module example.com/b\n"))
+	assert.True(t, errors.Is(err, ErrMismatch))
+
+	// Modules unknown to the database are refused as well
+	err = verifier.VerifyZip("example.com/unknown", "v1.0.0", "h1:zip=")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrMismatch))
+}
+
+func TestVerifier_Skip(t *testing.T) {
+	verifier := newTestVerifier(t, map[string]string{})
+	verifier.SetSkip("example.com/private")
+	assert.NoError(t, verifier.VerifyZip("example.com/private/a", "v1.0.0", "h1:zip="))
+	assert.Error(t, verifier.VerifyZip("example.com/public", "v1.0.0", "h1:zip="))
+}
+
+func TestVerifier_WrongKey(t *testing.T) {
+	// A database signing with another key than the configured one is never trusted
+	_, handler, err := NewTestDatabase("sum.example.com", func(path, version string) ([]byte, error) {
+		return []byte(path + " " + version + " h1:zip=\n"), nil
+	})
+	assert.NoError(t, err)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	otherKey, _, err := NewTestDatabase("sum.example.com", nil)
+	assert.NoError(t, err)
+
+	verifier, err := NewVerifier(otherKey, func(path string) ([]byte, error) {
+		get, err := http.Get(server.URL + path)
+		if err != nil {
+			return nil, err
+		}
+		defer get.Body.Close()
+		return io.ReadAll(get.Body)
+	})
+	assert.NoError(t, err)
+	assert.Error(t, verifier.VerifyZip("example.com/a", "v1.0.0", "h1:zip="))
+}
+
+func TestNewVerifier_InvalidKey(t *testing.T) {
+	_, err := NewVerifier("sum.example.com", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,18 @@
+package checksum
+
+import (
+	"crypto/rand"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/note"
+	"net/http"
+)
+
+// NewTestDatabase returns a local checksum database named name, which signs whatever go.sum lines gosum returns.
+// It is meant as a test double for sum.golang.org, key is the verifier key to pass to NewVerifier.
+func NewTestDatabase(name string, gosum func(path, version string) ([]byte, error)) (key string, handler http.Handler, err error) {
+	signer, key, err := note.GenerateKey(rand.Reader, name)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, sumdb.NewServer(sumdb.NewTestServer(signer, gosum)), nil
+}
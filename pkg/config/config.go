@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"goFastCache/pkg/auth"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/cache"
+	"goFastCache/pkg/checksum"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/gc"
+	"goFastCache/pkg/index"
+	"goFastCache/pkg/license"
+	"goFastCache/pkg/policy"
+	"goFastCache/pkg/routes"
+	"goFastCache/pkg/upstream"
+	"goFastCache/pkg/vulndb"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every setting of the proxy.
+// Settings are read from Default, then the YAML file passed as -config or CONFIG_FILE, then the environment and finally the flags,
+// each one overriding the previous ones.
+type Config struct {
+	// Listen is the address the http server listens on
+	Listen string `yaml:"listen" env:"LISTEN_ADDRESS"`
+	// ReadinessTimeout bounds every dependency check of /readyz
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout" env:"READINESS_TIMEOUT"`
+	// ShutdownTimeout is how long active requests and background jobs are waited for on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// ReadHeaderTimeout is how long clients may take to send the request headers.
+	// There is no write timeout, as zips are streamed to clients for as long as they need.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"READ_HEADER_TIMEOUT"`
+	// IdleTimeout is how long keep-alive connections are kept open between requests
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"IDLE_TIMEOUT"`
+	// TLSCert and TLSKey are PEM files, if set the server only accepts TLS connections
+	TLSCert  string             `yaml:"tls_cert" env:"TLS_CERT_FILE"`
+	TLSKey   string             `yaml:"tls_key" env:"TLS_KEY_FILE"`
+	Auth     auth.Config        `yaml:"auth"`
+	Storage  blobstorage.Config `yaml:"storage"`
+	Cache    cache.Config       `yaml:"cache"`
+	Database database.Config    `yaml:"database"`
+	Upstream upstream.Config    `yaml:"upstream"`
+	Routes   routes.Config      `yaml:"routes"`
+	Policy   policy.Config      `yaml:"policy"`
+	License  license.Config     `yaml:"license"`
+	Index    index.Config       `yaml:"index"`
+	GC       gc.Config          `yaml:"gc"`
+	VulnDB   vulndb.Config      `yaml:"vulndb"`
+}
+
+// Default returns the settings used for everything that is not configured
+func Default() Config {
+	return Config{
+		Listen:            ":8080",
+		ReadinessTimeout:  2 * time.Second,
+		ShutdownTimeout:   30 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		Storage:           blobstorage.Config{Backend: "minio"},
+		Cache:             cache.Config{Backend: "redis"},
+		Database:          database.Config{Backend: "postgres", Postgres: database.PostgresConfig{SSLMode: "disable"}},
+		Upstream:          upstream.Config{GOPROXY: upstream.DefaultGOPROXY, SumDB: upstream.DefaultSumDB},
+		Routes: routes.Config{
+			NegativeCacheTTL: 5 * time.Minute,
+			ListTTL:          time.Minute,
+			LatestTTL:        30 * time.Second,
+			ChecksumDB:       checksum.DefaultKey,
+		},
+		Policy:  policy.Config{ReloadInterval: 10 * time.Second},
+		License: license.Config{Classifiers: 2},
+		Index:   index.Config{Workers: 10, Retries: 5, RetryBackoff: time.Second, RefreshInterval: time.Hour},
+		GC:      gc.Config{Policy: gc.LRU, Interval: time.Hour},
+		VulnDB:  vulndb.Config{RefreshInterval: time.Hour},
+	}
+}
+
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.Listen == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	if cfg.ReadinessTimeout <= 0 {
+		errs = append(errs, errors.New("readiness timeout has to be a positive duration"))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown timeout has to be a positive duration"))
+	}
+	if cfg.ReadHeaderTimeout <= 0 {
+		errs = append(errs, errors.New("read header timeout has to be a positive duration"))
+	}
+	if cfg.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("idle timeout has to be a positive duration"))
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("tls cert and tls key have to be set together"))
+	}
+	if cfg.Auth.ClientCA != "" && cfg.TLSCert == "" {
+		errs = append(errs, errors.New("client certificates require tls cert and tls key"))
+	}
+	for _, validator := range []interface{ Validate() error }{cfg.Auth, cfg.Storage, cfg.Cache, cfg.Database, cfg.Upstream, cfg.Routes, cfg.Policy, cfg.License, cfg.Index, cfg.GC, cfg.VulnDB} {
+		errs = append(errs, validator.Validate())
+	}
+	return errors.Join(errs...)
+}
+
+// setting is a single configurable value of Config
+type setting struct {
+	// key is the dotted path of the setting in the YAML file, which is also the name of its flag
+	key   string
+	env   string
+	value reflect.Value
+}
+
+// Load reads the configuration from the YAML file, the environment and args, which are the command line flags without the program name.
+// It returns the arguments remaining after the flags.
+func Load(name string, args []string) (Config, []string, error) {
+	cfg := Default()
+	settings := collectSettings(reflect.ValueOf(&cfg).Elem(), "")
+
+	// Flags are applied last, but have to be parsed first to find the config file
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML configuration file, also CONFIG_FILE")
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flagValues []flagValue
+	for _, s := range settings {
+		s := s
+		usage := "sets " + s.key
+		if s.env != "" {
+			usage += ", also " + s.env
+		}
+		flags.Func(s.key, usage, func(value string) error {
+			flagValues = append(flagValues, flagValue{setting: s, value: value})
+			return nil
+		})
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	if *configFile == "" {
+		if file, found := os.LookupEnv("CONFIG_FILE"); found {
+			*configFile = strings.Trim(file, "\n\r")
+		}
+	}
+	if *configFile != "" {
+		err = loadFile(&cfg, *configFile)
+		if err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if value, found := os.LookupEnv(s.env); found {
+			err = set(s.value, strings.Trim(value, "\n\r"))
+			if err != nil {
+				return cfg, nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, f := range flagValues {
+		err = set(f.setting.value, f.value)
+		if err != nil {
+			return cfg, nil, fmt.Errorf("invalid -%s: %w", f.setting.key, err)
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return cfg, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// loadFile decodes the YAML file at path over cfg, unknown keys are rejected
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// collectSettings returns every setting within v, a struct whose fields have yaml tags
+func collectSettings(v reflect.Value, prefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		key = prefix + key
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct && !reflect.PointerTo(value.Type()).Implements(textUnmarshalerType) {
+			settings = append(settings, collectSettings(value, key+".")...)
+			continue
+		}
+		settings = append(settings, setting{key: key, env: field.Tag.Get("env"), value: value})
+	}
+	return settings
+}
+
+// set parses s into v
+func set(v reflect.Value, s string) error {
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(s))
+	}
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(duration))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
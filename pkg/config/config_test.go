@@ -0,0 +1,113 @@
+package config
+
+import (
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/gc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// inMemory selects backends that need no further settings
+func inMemory(t *testing.T) {
+	t.Setenv("STORAGE_BACKEND", "memory")
+	t.Setenv("CACHE_BACKEND", "memory")
+	t.Setenv("DATABASE_BACKEND", "memory")
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	inMemory(t)
+	cfg, args, err := Load("test", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, len(args), 0)
+
+	expected := Default()
+	expected.Storage.Backend, expected.Cache.Backend, expected.Database.Backend = "memory", "memory", "memory"
+	assert.DeepEqual(t, cfg, expected)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	inMemory(t)
+	path := writeFile(t, `
+listen: ":9000"
+index:
+  workers: 3
+  retries: 1
+gc:
+  quota: 1GiB
+  policy: lfu
+routes:
+  negative_cache_ttl: 1m
+`)
+	t.Setenv("INDEX_WORKERS", "4\n")
+	t.Setenv("INDEX_RETRIES", "2")
+
+	cfg, args, err := Load("test", []string{"-config", path, "-index.workers", "5", "gc"})
+	assert.NoError(t, err)
+	assert.DeepEqual(t, args, []string{"gc"})
+	// The file overrides the defaults
+	assert.Equal(t, cfg.Listen, ":9000")
+	assert.Equal(t, cfg.GC.Quota, gc.Size(1<<30))
+	assert.Equal(t, cfg.GC.Policy, gc.LFU)
+	assert.Equal(t, cfg.Routes.NegativeCacheTTL, time.Minute)
+	// The environment overrides the file
+	assert.Equal(t, cfg.Index.Retries, 2)
+	// Flags override the environment
+	assert.Equal(t, cfg.Index.Workers, 5)
+	// Everything else keeps its default
+	assert.Equal(t, cfg.Index.RetryBackoff, time.Second)
+}
+
+func TestLoad_ConfigFileFromEnv(t *testing.T) {
+	inMemory(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "gc:\n  keep_versions: 3\n"))
+	cfg, _, err := Load("test", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.GC.KeepVersions, 3)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	for name, test := range map[string]struct {
+		file     string
+		env      map[string]string
+		args     []string
+		expected string
+	}{
+		"unknown key":     {file: "index:\n  worker: 3\n", expected: "field worker not found"},
+		"invalid yaml":    {file: "index: [", expected: "invalid config file"},
+		"invalid env":     {env: map[string]string{"INDEX_RETRY_BACKOFF": "soon"}, expected: "invalid INDEX_RETRY_BACKOFF"},
+		"invalid flag":    {args: []string{"-upstream.offline", "maybe"}, expected: "invalid -upstream.offline"},
+		"unknown flag":    {args: []string{"-unknown", "1"}, expected: "flag provided but not defined"},
+		"invalid size":    {env: map[string]string{"GC_QUOTA": "lots"}, expected: "invalid GC_QUOTA"},
+		"invalid workers": {args: []string{"-index.workers", "0"}, expected: "index workers has to be a positive number"},
+		"header timeout":  {env: map[string]string{"READ_HEADER_TIMEOUT": "0s"}, expected: "read header timeout has to be a positive duration"},
+		"invalid goproxy": {env: map[string]string{"UPSTREAM_GOPROXY": "ftp://example.com"}, expected: "invalid GOPROXY entry"},
+		"invalid private": {env: map[string]string{"UPSTREAM_PRIVATE_REPOS": "example.com/a"}, expected: "invalid private repository"},
+		"client ca":       {env: map[string]string{"AUTH_CLIENT_CA": "/etc/ca.pem"}, expected: "client certificates require tls"},
+		"invalid rules":   {env: map[string]string{"AUTH_RULES": "example.com/a"}, expected: "invalid access rule"},
+		"missing minio":   {env: map[string]string{"STORAGE_BACKEND": "minio", "MINIO_SECRET_KEY": "secret"}, expected: "minio access key is required"},
+		"unknown backend": {env: map[string]string{"CACHE_BACKEND": "memcached"}, expected: "unknown cache backend memcached"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			inMemory(t)
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			args := test.args
+			if test.file != "" {
+				args = append([]string{"-config", writeFile(t, test.file)}, args...)
+			}
+			_, _, err := Load("test", args)
+			assert.Error(t, err)
+			assert.True(t, strings.Contains(err.Error(), test.expected))
+		})
+	}
+}
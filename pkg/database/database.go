@@ -1,19 +1,47 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"goFastCache/pkg/modpath"
+	"golang.org/x/mod/semver"
 	"gorm.io/gorm/clause"
-	"os"
+	"sort"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-type Database struct {
-	postgres *gorm.DB
+// Database records which modules are hosted by the proxy
+type Database interface {
+	UpsertGoModule(gomodule Gomodule) error
+	GetGoModuleByPath(path string) (Gomodule, bool, error)
+	// UpdateGoModuleVersion applies update to the catalogue entry of path@version, creating the module and version if needed.
+	// The version of the module is raised to version if it is newer.
+	UpdateGoModuleVersion(path, version string, update func(gomoduleVersion *GomoduleVersion)) error
+	// ListGoModules returns every module hosted by the proxy
+	ListGoModules() ([]Gomodule, error)
+	// RecordGoModuleVersionAccess counts a download of path@version, without locking the catalogue entry.
+	// It reports false if path@version is not catalogued, in which case nothing is recorded.
+	RecordGoModuleVersionAccess(path, version string) (bool, error)
+	// GetGoModuleVersion returns the catalogue entry of path@version
+	GetGoModuleVersion(path, version string) (GomoduleVersion, bool, error)
+	// ListGoModuleVersions returns every catalogued version of path, in semver order
+	ListGoModuleVersions(path string) ([]GomoduleVersion, error)
+	// DeleteGoModuleVersion removes path@version from the catalogue, once it is no longer stored
+	DeleteGoModuleVersion(path, version string) error
+	// GetIndexCursor returns the timestamp up to which index.golang.org has been processed
+	GetIndexCursor() (time.Time, bool, error)
+	SetIndexCursor(since time.Time) error
+	// Ping checks that the database is reachable
+	Ping(ctx context.Context) error
+	// Close releases the connection to the database
+	Close() error
 }
 
+// Gomodule is a module hosted by the proxy, Path is always stored in its unescaped form
 type Gomodule struct {
 	gorm.Model
 	Index   int    `gorm:"primaryKey"`
@@ -21,45 +49,120 @@ type Gomodule struct {
 	Version string
 }
 
-func NewDatabase() (*Database, error) {
-	postgresUser, found := os.LookupEnv("POSTGRES_USER")
-	if !found {
-		return nil, errors.New("POSTGRES_USER not found")
+// GomoduleVersion is one version of a Gomodule that is hosted by the proxy
+type GomoduleVersion struct {
+	ID         uint   `gorm:"primaryKey"`
+	GomoduleID uint   `gorm:"uniqueIndex:idx_gomodule_version"`
+	Version    string `gorm:"uniqueIndex:idx_gomodule_version"`
+	// OriginTime is the commit time reported by the .info
+	OriginTime *time.Time
+	InfoSize   int64
+	InfoSha256 string
+	ModSize    int64
+	ModSha256  string
+	ZipSize    int64
+	ZipSha256  string
+	// ZipHash is the h1: hash of the zip, as recorded in go.sum
+	ZipHash string
+	// Licenses are the SPDX identifiers of the licenses of the zip, separated by commas.
+	// It is nil if the licenses were not detected, and empty if the zip has no license.
+	Licenses     *string
+	FirstFetched time.Time
+	LastAccessed time.Time
+	FetchCount   int64
+}
+
+// IndexCursor is the timestamp up to which index.golang.org has been processed, there is only a single row
+type IndexCursor struct {
+	ID    uint `gorm:"primaryKey"`
+	Since time.Time
+}
+
+const indexCursorID = 1
+
+// Config selects and configures the database backend
+type Config struct {
+	// Backend is either "postgres" (default) or "memory"
+	Backend  string         `yaml:"backend" env:"DATABASE_BACKEND"`
+	Postgres PostgresConfig `yaml:"postgres"`
+}
+
+type PostgresConfig struct {
+	Host     string `yaml:"host" env:"POSTGRES_HOST"`
+	User     string `yaml:"user" env:"POSTGRES_USER"`
+	Password string `yaml:"password" env:"POSTGRES_PASSWORD"`
+	DB       string `yaml:"db" env:"POSTGRES_DB"`
+	SSLMode  string `yaml:"sslmode" env:"POSTGRES_SSLMODE"`
+}
+
+func (cfg Config) Validate() error {
+	switch cfg.Backend {
+	case "postgres":
+		return cfg.Postgres.Validate()
+	case "memory":
+		return nil
 	}
+	return errors.New("unknown database backend " + cfg.Backend)
+}
 
-	postgresPassword, found := os.LookupEnv("POSTGRES_PASSWORD")
-	if !found {
-		return nil, errors.New("POSTGRES_PASSWORD not found")
+func (cfg PostgresConfig) Validate() error {
+	var errs []error
+	for _, field := range [][2]string{
+		{"host", cfg.Host},
+		{"user", cfg.User},
+		{"password", cfg.Password},
+		{"db", cfg.DB},
+	} {
+		if field[1] == "" {
+			errs = append(errs, errors.New("postgres "+field[0]+" is required"))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	postgresHost, found := os.LookupEnv("POSTGRES_HOST")
-	if !found {
-		return nil, errors.New("POSTGRES_HOST not found")
+// NewDatabase creates the backend selected by cfg.Backend
+func NewDatabase(cfg Config) (Database, error) {
+	switch cfg.Backend {
+	case "postgres":
+		return NewPostgres(cfg.Postgres)
+	case "memory":
+		return NewMemory(), nil
 	}
+	return nil, errors.New("unknown database backend " + cfg.Backend)
+}
 
-	postgresDB, found := os.LookupEnv("POSTGRES_DB")
-	if !found {
-		return nil, errors.New("POSTGRES_DB not found")
+type Postgres struct {
+	postgres *gorm.DB
+}
+
+func NewPostgres(cfg PostgresConfig) (*Postgres, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
 	}
 
-	dsn := fmt.Sprintf("user=%s password=%s host=%s dbname=%s sslmode=disable", postgresUser, postgresPassword, postgresHost, postgresDB)
+	dsn := fmt.Sprintf("user=%s password=%s host=%s dbname=%s sslmode=%s", cfg.User, cfg.Password, cfg.Host, cfg.DB, sslMode)
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
-	// Create the table if it doesn't exist
-	err = db.AutoMigrate(&Gomodule{})
+	err = migrate(db)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Database{
+	return &Postgres{
 		postgres: db,
 	}, nil
 }
 
-func (db *Database) UpsertGoModule(gomodule Gomodule) error {
+func (db *Postgres) UpsertGoModule(gomodule Gomodule) error {
+	gomodule.Path = modpath.Canonical(gomodule.Path)
 	result := db.postgres.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "path"}},
 		UpdateAll: true,
@@ -67,9 +170,9 @@ func (db *Database) UpsertGoModule(gomodule Gomodule) error {
 	return result.Error
 }
 
-func (db *Database) GetGoModuleByPath(path string) (Gomodule, bool, error) {
+func (db *Postgres) GetGoModuleByPath(path string) (Gomodule, bool, error) {
 	var gomodule Gomodule
-	result := db.postgres.First(&gomodule, "path = ?", path)
+	result := db.postgres.First(&gomodule, "path = ?", modpath.Canonical(path))
 
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
@@ -80,3 +183,147 @@ func (db *Database) GetGoModuleByPath(path string) (Gomodule, bool, error) {
 
 	return gomodule, true, nil
 }
+
+func (db *Postgres) UpdateGoModuleVersion(path, version string, update func(gomoduleVersion *GomoduleVersion)) error {
+	path = modpath.Canonical(path)
+	return db.postgres.Transaction(func(tx *gorm.DB) error {
+		// Inserting first and ignoring conflicts lets replicas race on new rows, which FirstOrCreate does not.
+		// The rows are then selected again under a lock.
+		gomodule := Gomodule{Path: path, Version: version}
+		result := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "path"}}, DoNothing: true}).
+			Create(&gomodule)
+		if result.Error != nil {
+			return result.Error
+		}
+		gomodule = Gomodule{}
+		result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(Gomodule{Path: path}).
+			First(&gomodule)
+		if result.Error != nil {
+			return result.Error
+		}
+		if semver.Compare(version, gomodule.Version) > 0 {
+			result = tx.Model(&gomodule).Update("version", version)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		gomoduleVersion := GomoduleVersion{GomoduleID: gomodule.ID, Version: version}
+		result = tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "gomodule_id"}, {Name: "version"}}, DoNothing: true}).
+			Create(&gomoduleVersion)
+		if result.Error != nil {
+			return result.Error
+		}
+		gomoduleVersion = GomoduleVersion{}
+		result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(GomoduleVersion{GomoduleID: gomodule.ID, Version: version}).
+			First(&gomoduleVersion)
+		if result.Error != nil {
+			return result.Error
+		}
+		update(&gomoduleVersion)
+		return tx.Save(&gomoduleVersion).Error
+	})
+}
+
+func (db *Postgres) RecordGoModuleVersionAccess(path, version string) (bool, error) {
+	// A single statement, as every cache hit records an access
+	result := db.postgres.Model(&GomoduleVersion{}).
+		Where("gomodule_id = (SELECT id FROM gomodules WHERE path = ?) AND version = ?", modpath.Canonical(path), version).
+		UpdateColumns(map[string]interface{}{
+			"last_accessed": gorm.Expr("now()"),
+			"fetch_count":   gorm.Expr("fetch_count + 1"),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (db *Postgres) GetGoModuleVersion(path, version string) (GomoduleVersion, bool, error) {
+	var gomoduleVersion GomoduleVersion
+	result := db.postgres.
+		Joins("JOIN gomodules ON gomodules.id = gomodule_versions.gomodule_id").
+		Where("gomodules.path = ? AND gomodule_versions.version = ?", modpath.Canonical(path), version).
+		First(&gomoduleVersion)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return GomoduleVersion{}, false, nil
+		}
+		return GomoduleVersion{}, false, result.Error
+	}
+
+	return gomoduleVersion, true, nil
+}
+
+func (db *Postgres) ListGoModuleVersions(path string) ([]GomoduleVersion, error) {
+	gomodule, found, err := db.GetGoModuleByPath(path)
+	if err != nil || !found {
+		return nil, err
+	}
+	var gomoduleVersions []GomoduleVersion
+	result := db.postgres.Where(GomoduleVersion{GomoduleID: gomodule.ID}).Find(&gomoduleVersions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	sortGoModuleVersions(gomoduleVersions)
+	return gomoduleVersions, nil
+}
+
+func (db *Postgres) Ping(ctx context.Context) error {
+	sqlDB, err := db.postgres.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (db *Postgres) Close() error {
+	sqlDB, err := db.postgres.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func (db *Postgres) ListGoModules() ([]Gomodule, error) {
+	var gomodules []Gomodule
+	result := db.postgres.Find(&gomodules)
+	return gomodules, result.Error
+}
+
+func (db *Postgres) DeleteGoModuleVersion(path, version string) error {
+	gomodule, found, err := db.GetGoModuleByPath(path)
+	if err != nil || !found {
+		return err
+	}
+	result := db.postgres.Where(GomoduleVersion{GomoduleID: gomodule.ID, Version: version}).Delete(&GomoduleVersion{})
+	return result.Error
+}
+
+func sortGoModuleVersions(gomoduleVersions []GomoduleVersion) {
+	sort.Slice(gomoduleVersions, func(i, j int) bool {
+		return semver.Compare(gomoduleVersions[i].Version, gomoduleVersions[j].Version) < 0
+	})
+}
+
+func (db *Postgres) GetIndexCursor() (time.Time, bool, error) {
+	var cursor IndexCursor
+	result := db.postgres.First(&cursor, indexCursorID)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, result.Error
+	}
+
+	return cursor.Since, true, nil
+}
+
+func (db *Postgres) SetIndexCursor(since time.Time) error {
+	result := db.postgres.Save(&IndexCursor{
+		ID:    indexCursorID,
+		Since: since.UTC(),
+	})
+	return result.Error
+}
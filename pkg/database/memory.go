@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"goFastCache/pkg/modpath"
+	"golang.org/x/mod/semver"
+	"sync"
+	"time"
+)
+
+// Memory keeps all records inside the process, they are lost on restart
+type Memory struct {
+	lock      sync.RWMutex
+	gomodules map[string]Gomodule
+	nextID    uint
+	// versions holds the catalogue entries by path and version
+	versions      map[string]map[string]GomoduleVersion
+	nextVersionID uint
+	cursor        *time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		gomodules: make(map[string]Gomodule),
+		versions:  make(map[string]map[string]GomoduleVersion),
+	}
+}
+
+func (db *Memory) UpsertGoModule(gomodule Gomodule) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.upsertGoModule(gomodule)
+	return nil
+}
+
+func (db *Memory) upsertGoModule(gomodule Gomodule) Gomodule {
+	gomodule.Path = modpath.Canonical(gomodule.Path)
+	now := time.Now()
+	if existing, found := db.gomodules[gomodule.Path]; found {
+		gomodule.ID = existing.ID
+		gomodule.CreatedAt = existing.CreatedAt
+	} else {
+		db.nextID++
+		gomodule.ID = db.nextID
+		gomodule.CreatedAt = now
+	}
+	gomodule.UpdatedAt = now
+	db.gomodules[gomodule.Path] = gomodule
+	return gomodule
+}
+
+func (db *Memory) GetGoModuleByPath(path string) (Gomodule, bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	gomodule, found := db.gomodules[modpath.Canonical(path)]
+	return gomodule, found, nil
+}
+
+func (db *Memory) UpdateGoModuleVersion(path, version string, update func(gomoduleVersion *GomoduleVersion)) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	path = modpath.Canonical(path)
+	gomodule, found := db.gomodules[path]
+	if !found || semver.Compare(version, gomodule.Version) > 0 {
+		gomodule.Path = path
+		gomodule.Version = version
+		gomodule = db.upsertGoModule(gomodule)
+	}
+
+	versions, found := db.versions[path]
+	if !found {
+		versions = make(map[string]GomoduleVersion)
+		db.versions[path] = versions
+	}
+	gomoduleVersion, found := versions[version]
+	if !found {
+		db.nextVersionID++
+		gomoduleVersion = GomoduleVersion{
+			ID:         db.nextVersionID,
+			GomoduleID: gomodule.ID,
+			Version:    version,
+		}
+	}
+	update(&gomoduleVersion)
+	versions[version] = gomoduleVersion
+	return nil
+}
+
+func (db *Memory) RecordGoModuleVersionAccess(path, version string) (bool, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	versions := db.versions[modpath.Canonical(path)]
+	gomoduleVersion, found := versions[version]
+	if !found {
+		return false, nil
+	}
+	gomoduleVersion.LastAccessed = time.Now().UTC()
+	gomoduleVersion.FetchCount++
+	versions[version] = gomoduleVersion
+	return true, nil
+}
+
+func (db *Memory) GetGoModuleVersion(path, version string) (GomoduleVersion, bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	gomoduleVersion, found := db.versions[modpath.Canonical(path)][version]
+	return gomoduleVersion, found, nil
+}
+
+func (db *Memory) ListGoModuleVersions(path string) ([]GomoduleVersion, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	gomoduleVersions := make([]GomoduleVersion, 0)
+	for _, gomoduleVersion := range db.versions[modpath.Canonical(path)] {
+		gomoduleVersions = append(gomoduleVersions, gomoduleVersion)
+	}
+	sortGoModuleVersions(gomoduleVersions)
+	return gomoduleVersions, nil
+}
+
+func (db *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (db *Memory) Close() error {
+	return nil
+}
+
+func (db *Memory) ListGoModules() ([]Gomodule, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	gomodules := make([]Gomodule, 0, len(db.gomodules))
+	for _, gomodule := range db.gomodules {
+		gomodules = append(gomodules, gomodule)
+	}
+	return gomodules, nil
+}
+
+func (db *Memory) DeleteGoModuleVersion(path, version string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	delete(db.versions[modpath.Canonical(path)], version)
+	return nil
+}
+
+func (db *Memory) GetIndexCursor() (time.Time, bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.cursor == nil {
+		return time.Time{}, false, nil
+	}
+	return *db.cursor, true, nil
+}
+
+func (db *Memory) SetIndexCursor(since time.Time) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	since = since.UTC()
+	db.cursor = &since
+	return nil
+}
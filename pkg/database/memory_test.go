@@ -0,0 +1,129 @@
+package database
+
+import (
+	"github.com/zeebo/assert"
+	"testing"
+	"time"
+)
+
+func TestMemory_UpsertGoModule(t *testing.T) {
+	db := NewMemory()
+	_, found, err := db.GetGoModuleByPath("github.com/jinzhu/inflection")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	err = db.UpsertGoModule(Gomodule{Path: "github.com/jinzhu/inflection", Version: "v1.0.0"})
+	assert.NoError(t, err)
+	err = db.UpsertGoModule(Gomodule{Path: "github.com/jinzhu/inflection", Version: "v1.0.1"})
+	assert.NoError(t, err)
+
+	gomodule, found, err := db.GetGoModuleByPath("github.com/jinzhu/inflection")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, gomodule.Version, "v1.0.1")
+	assert.Equal(t, gomodule.ID, uint(1))
+}
+
+func TestMemory_CanonicalPath(t *testing.T) {
+	db := NewMemory()
+	err := db.UpsertGoModule(Gomodule{Path: "github.com/!azure/go-autorest/", Version: "v1.0.0"})
+	assert.NoError(t, err)
+	err = db.UpsertGoModule(Gomodule{Path: "github.com/Azure/go-autorest", Version: "v1.0.1"})
+	assert.NoError(t, err)
+
+	gomodule, found, err := db.GetGoModuleByPath("github.com/!azure/go-autorest")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, gomodule.Path, "github.com/Azure/go-autorest")
+	assert.Equal(t, gomodule.Version, "v1.0.1")
+	assert.Equal(t, len(db.gomodules), 1)
+}
+
+func TestMemory_IndexCursor(t *testing.T) {
+	db := NewMemory()
+	_, found, err := db.GetIndexCursor()
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	since := time.Date(2023, 6, 20, 12, 0, 0, 123456000, time.UTC)
+	assert.NoError(t, db.SetIndexCursor(since))
+	cursor, found, err := db.GetIndexCursor()
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, cursor.Equal(since))
+}
+
+func TestMemory_GoModuleVersions(t *testing.T) {
+	db := NewMemory()
+	versions, err := db.ListGoModuleVersions("github.com/jinzhu/inflection")
+	assert.NoError(t, err)
+	assert.Equal(t, len(versions), 0)
+
+	for _, version := range []string{"v1.10.0", "v1.2.0", "v1.9.0"} {
+		err = db.UpdateGoModuleVersion("github.com/jinzhu/inflection", version, func(gomoduleVersion *GomoduleVersion) {
+			gomoduleVersion.FetchCount++
+		})
+		assert.NoError(t, err)
+	}
+	err = db.UpdateGoModuleVersion("github.com/jinzhu/inflection/", "v1.2.0", func(gomoduleVersion *GomoduleVersion) {
+		gomoduleVersion.FetchCount++
+		gomoduleVersion.ZipHash = "h1:x"
+	})
+	assert.NoError(t, err)
+
+	versions, err = db.ListGoModuleVersions("github.com/jinzhu/inflection")
+	assert.NoError(t, err)
+	assert.Equal(t, len(versions), 3)
+	assert.Equal(t, versions[0].Version, "v1.2.0")
+	assert.Equal(t, versions[0].FetchCount, int64(2))
+	assert.Equal(t, versions[0].ZipHash, "h1:x")
+	assert.Equal(t, versions[1].Version, "v1.9.0")
+	assert.Equal(t, versions[2].Version, "v1.10.0")
+
+	// The module keeps the newest version, not the last one recorded
+	gomodule, found, err := db.GetGoModuleByPath("github.com/jinzhu/inflection")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, gomodule.Version, "v1.10.0")
+	assert.Equal(t, versions[0].GomoduleID, gomodule.ID)
+
+	gomoduleVersion, found, err := db.GetGoModuleVersion("github.com/jinzhu/inflection/", "v1.2.0")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, gomoduleVersion.ZipHash, "h1:x")
+	_, found, err = db.GetGoModuleVersion("github.com/jinzhu/inflection", "v1.3.0")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	found, err = db.RecordGoModuleVersionAccess("github.com/jinzhu/inflection/", "v1.2.0")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	gomoduleVersion, _, _ = db.GetGoModuleVersion("github.com/jinzhu/inflection", "v1.2.0")
+	assert.Equal(t, gomoduleVersion.FetchCount, int64(3))
+	assert.False(t, gomoduleVersion.LastAccessed.IsZero())
+	// Accesses of versions that are not catalogued are not recorded
+	found, err = db.RecordGoModuleVersionAccess("github.com/jinzhu/inflection", "v1.3.0")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	_, found, _ = db.GetGoModuleVersion("github.com/jinzhu/inflection", "v1.3.0")
+	assert.False(t, found)
+}
+
+func TestMemory_DeleteGoModuleVersion(t *testing.T) {
+	db := NewMemory()
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		err := db.UpdateGoModuleVersion("github.com/jinzhu/inflection", version, func(*GomoduleVersion) {})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.DeleteGoModuleVersion("github.com/jinzhu/inflection", "v1.0.0"))
+
+	versions, err := db.ListGoModuleVersions("github.com/jinzhu/inflection")
+	assert.NoError(t, err)
+	assert.Equal(t, len(versions), 1)
+	assert.Equal(t, versions[0].Version, "v1.1.0")
+
+	gomodules, err := db.ListGoModules()
+	assert.NoError(t, err)
+	assert.Equal(t, len(gomodules), 1)
+	assert.Equal(t, gomodules[0].Path, "github.com/jinzhu/inflection")
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// SchemaMigration records a migration that has been applied to the database
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// migration upgrades the schema by one version.
+// Released migrations must never change, new schema changes always get a new migration.
+type migration struct {
+	version int
+	name    string
+	migrate func(tx *gorm.DB) error
+}
+
+// The models below are frozen copies of the structs as they were when their migration was written,
+// so changing a struct later does not change what an old migration does.
+
+type gomoduleV1 struct {
+	gorm.Model
+	Index   int    `gorm:"primaryKey"`
+	Path    string `gorm:"unique"`
+	Version string
+}
+
+func (gomoduleV1) TableName() string {
+	return "gomodules"
+}
+
+type indexCursorV2 struct {
+	ID    uint `gorm:"primaryKey"`
+	Since time.Time
+}
+
+func (indexCursorV2) TableName() string {
+	return "index_cursors"
+}
+
+type gomoduleVersionV3 struct {
+	ID           uint   `gorm:"primaryKey"`
+	GomoduleID   uint   `gorm:"uniqueIndex:idx_gomodule_version"`
+	Version      string `gorm:"uniqueIndex:idx_gomodule_version"`
+	OriginTime   *time.Time
+	InfoSize     int64
+	InfoSha256   string
+	ModSize      int64
+	ModSha256    string
+	ZipSize      int64
+	ZipSha256    string
+	ZipHash      string
+	FirstFetched time.Time
+	LastAccessed time.Time
+	FetchCount   int64
+}
+
+func (gomoduleVersionV3) TableName() string {
+	return "gomodule_versions"
+}
+
+type gomoduleVersionV5 struct {
+	Licenses *string
+}
+
+func (gomoduleVersionV5) TableName() string {
+	return "gomodule_versions"
+}
+
+var migrations = []migration{
+	{1, "create gomodules", func(tx *gorm.DB) error {
+		// Databases created before versioned migrations already have this table
+		return tx.AutoMigrate(&gomoduleV1{})
+	}},
+	{2, "create index_cursors", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&indexCursorV2{})
+	}},
+	{3, "create gomodule_versions", func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&gomoduleVersionV3{})
+	}},
+	{4, "catalogue the versions recorded in gomodules", func(tx *gorm.DB) error {
+		return tx.Exec(`INSERT INTO gomodule_versions (gomodule_id, version)
+			SELECT id, version FROM gomodules WHERE deleted_at IS NULL AND version <> ''
+			ON CONFLICT DO NOTHING`).Error
+	}},
+	{5, "add licenses to gomodule_versions", func(tx *gorm.DB) error {
+		return tx.Migrator().AddColumn(&gomoduleVersionV5{}, "Licenses")
+	}},
+}
+
+// migrationLockID identifies the advisory lock that serializes migrations of replicas starting at the same time
+const migrationLockID = 0x676f46617374
+
+// migrate applies every pending migration in a single transaction
+func migrate(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.AutoMigrate(&SchemaMigration{})
+		if err != nil {
+			return err
+		}
+		var applied []SchemaMigration
+		err = tx.Find(&applied).Error
+		if err != nil {
+			return err
+		}
+
+		for _, m := range pendingMigrations(migrations, applied) {
+			zap.S().Infof("Applying database migration %d: %s", m.version, m.name)
+			err = m.migrate(tx)
+			if err != nil {
+				return err
+			}
+			err = tx.Create(&SchemaMigration{
+				Version:   m.version,
+				Name:      m.name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pendingMigrations returns the migrations that have not been applied yet, in the order they have to run
+func pendingMigrations(all []migration, applied []SchemaMigration) []migration {
+	done := make(map[int]bool, len(applied))
+	for _, schemaMigration := range applied {
+		done[schemaMigration.Version] = true
+	}
+	pending := make([]migration, 0)
+	for _, m := range all {
+		if !done[m.version] {
+			pending = append(pending, m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].version < pending[j].version
+	})
+	return pending
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"github.com/zeebo/assert"
+	"testing"
+)
+
+func TestMigrations_Ordered(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, m.version, i+1)
+		assert.True(t, m.name != "")
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	all := []migration{{version: 1}, {version: 3}, {version: 2}}
+	pending := pendingMigrations(all, nil)
+	assert.Equal(t, len(pending), 3)
+	assert.Equal(t, pending[0].version, 1)
+	assert.Equal(t, pending[1].version, 2)
+	assert.Equal(t, pending[2].version, 3)
+
+	pending = pendingMigrations(all, []SchemaMigration{{Version: 1}, {Version: 2}})
+	assert.Equal(t, len(pending), 1)
+	assert.Equal(t, pending[0].version, 3)
+
+	pending = pendingMigrations(all, []SchemaMigration{{Version: 1}, {Version: 2}, {Version: 3}})
+	assert.Equal(t, len(pending), 0)
+}
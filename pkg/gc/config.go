@@ -0,0 +1,87 @@
+package gc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy selects which module versions are evicted first once the quota is exceeded
+type Policy string
+
+const (
+	// LRU evicts the versions that were not requested for the longest time
+	LRU Policy = "lru"
+	// LFU evicts the versions that were requested the least
+	LFU Policy = "lfu"
+)
+
+// Config controls which module versions are evicted from the blob store
+type Config struct {
+	// Quota is the maximum size of the module artifacts in the blob store, 0 disables it
+	Quota Size `yaml:"quota" env:"GC_QUOTA"`
+	// Policy is used to enforce Quota
+	Policy Policy `yaml:"policy" env:"GC_POLICY"`
+	// Pinned lists the modules that are never evicted, as comma separated GOPRIVATE style glob patterns
+	Pinned string `yaml:"pinned" env:"GC_PINNED"`
+	// KeepVersions is the number of newest versions kept of every module, 0 keeps all of them
+	KeepVersions int `yaml:"keep_versions" env:"GC_KEEP_VERSIONS"`
+	// Interval is the delay between two background collections, 0 disables them
+	Interval time.Duration `yaml:"interval" env:"GC_INTERVAL"`
+}
+
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.Policy != LRU && cfg.Policy != LFU {
+		errs = append(errs, errors.New("gc policy has to be lru or lfu"))
+	}
+	if cfg.KeepVersions < 0 {
+		errs = append(errs, errors.New("gc keep versions has to be zero or a positive number"))
+	}
+	if cfg.Interval < 0 {
+		errs = append(errs, errors.New("gc interval has to be zero or a positive duration"))
+	}
+	return errors.Join(errs...)
+}
+
+// Size is a byte count, which is written with an optional unit like 500MB or 10GiB
+type Size int64
+
+func (s *Size) UnmarshalText(text []byte) error {
+	size, err := ParseSize(string(text))
+	if err != nil {
+		return err
+	}
+	*s = Size(size)
+	return nil
+}
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseSize parses a byte count with an optional unit, like 1024, 500MB or 10GiB
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if number, found := strings.CutSuffix(s, unit.suffix); found {
+			s, factor = strings.TrimSpace(number), unit.factor
+			break
+		}
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, errors.New("size must not be negative")
+	}
+	return size * factor, nil
+}
@@ -0,0 +1,184 @@
+package gc
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/routes"
+	"goFastCache/pkg/vulndb"
+	"golang.org/x/mod/module"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Result summarizes a single collection
+type Result struct {
+	// UsedBytes is the size of the module artifacts in the blob store after the collection
+	UsedBytes  int64
+	FreedBytes int64
+	Evicted    int
+	Failed     int
+}
+
+// candidate is a stored module version that may be evicted
+type candidate struct {
+	path    string
+	version database.GomoduleVersion
+	size    int64
+}
+
+// lastUsed is the last time the version was requested, or stored if it never was
+func (c candidate) lastUsed() time.Time {
+	if c.version.LastAccessed.After(c.version.FirstFetched) {
+		return c.version.LastAccessed
+	}
+	return c.version.FirstFetched
+}
+
+var versionArtifacts = []routes.Artifact{routes.ArtifactInfo, routes.ArtifactMod, routes.ArtifactZip}
+
+// nonModulePrefixes hold objects that belong to no module, like quarantined zips and the vulnerability database
+var nonModulePrefixes = []string{routes.QuarantinePrefix, vulndb.Prefix}
+
+// moduleSuffixes end the keys of module artifacts, checksum database tiles end in .sum instead
+var moduleSuffixes = []string{".info", ".mod", ".zip", ".list", ".latest"}
+
+// isModuleObject reports whether key holds an artifact of a module
+func isModuleObject(key string) bool {
+	for _, prefix := range nonModulePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	for _, suffix := range moduleSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Collect evicts every version beyond the cfg.KeepVersions newest of a module, then evicts versions by cfg.Policy until the
+// module artifacts fit into cfg.Quota. Pinned modules are never evicted. Objects of no module, like checksum database tiles,
+// quarantined zips and the vulnerability database, are kept and do not count towards the quota.
+func Collect(cfg Config, db database.Database, blob blobstorage.Storage) (Result, error) {
+	quota := int64(cfg.Quota)
+	var result Result
+	objects, err := blob.List("")
+	if err != nil {
+		return result, err
+	}
+	sizes := make(map[string]int64, len(objects))
+	for _, object := range objects {
+		if !isModuleObject(object.Key) {
+			continue
+		}
+		sizes[object.Key] = object.Size
+		result.UsedBytes += object.Size
+	}
+
+	gomodules, err := db.ListGoModules()
+	if err != nil {
+		return result, err
+	}
+	var candidates []candidate
+	for _, gomodule := range gomodules {
+		if module.MatchPrefixPatterns(cfg.Pinned, gomodule.Path) {
+			continue
+		}
+		versions, err := db.ListGoModuleVersions(gomodule.Path)
+		if err != nil {
+			return result, err
+		}
+		for i, version := range versions {
+			c := candidate{path: gomodule.Path, version: version}
+			for _, artifact := range versionArtifacts {
+				c.size += sizes[routes.GetCacheKey(artifact, gomodule.Path, version.Version)]
+			}
+			// versions are sorted oldest first
+			if cfg.KeepVersions > 0 && i < len(versions)-cfg.KeepVersions {
+				evict(db, blob, c, &result)
+				continue
+			}
+			candidates = append(candidates, c)
+		}
+	}
+
+	if quota > 0 && result.UsedBytes > quota {
+		sortCandidates(candidates, cfg.Policy)
+		for _, c := range candidates {
+			if result.UsedBytes <= quota {
+				break
+			}
+			evict(db, blob, c, &result)
+		}
+		if result.UsedBytes > quota {
+			zap.S().Warnf("Blob store uses %d bytes after eviction, which exceeds the quota of %d bytes", result.UsedBytes, quota)
+		}
+	}
+	return result, nil
+}
+
+// sortCandidates orders candidates so that the first one is evicted first
+func sortCandidates(candidates []candidate, policy Policy) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if policy == LFU && a.version.FetchCount != b.version.FetchCount {
+			return a.version.FetchCount < b.version.FetchCount
+		}
+		return a.lastUsed().Before(b.lastUsed())
+	})
+}
+
+// evict removes every artifact of the version, then drops it from the catalogue
+func evict(db database.Database, blob blobstorage.Storage, c candidate, result *Result) {
+	for _, artifact := range versionArtifacts {
+		err := blob.Delete(routes.GetCacheKey(artifact, c.path, c.version.Version))
+		if err != nil {
+			zap.S().Errorf("Failed to evict %s@%s: %v", c.path, c.version.Version, err)
+			result.Failed++
+			return
+		}
+	}
+	err := db.DeleteGoModuleVersion(c.path, c.version.Version)
+	if err != nil {
+		zap.S().Errorf("Failed to remove %s@%s from the catalogue: %v", c.path, c.version.Version, err)
+		result.Failed++
+		return
+	}
+	zap.S().Debugf("Evicted %s@%s (%d bytes)", c.path, c.version.Version, c.size)
+	result.Evicted++
+	result.FreedBytes += c.size
+	result.UsedBytes -= c.size
+}
+
+// CollectInBackground runs Collect every cfg.Interval until ctx is cancelled, it does nothing if the interval is 0.
+// The returned channel is closed once the background job stopped, which lets a running collection finish.
+func CollectInBackground(ctx context.Context, cfg Config, db database.Database, blob blobstorage.Storage) <-chan struct{} {
+	done := make(chan struct{})
+	if cfg.Interval <= 0 {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			result, err := Collect(cfg, db, blob)
+			if err != nil {
+				zap.S().Errorf("Garbage collection failed: %v", err)
+				continue
+			}
+			zap.S().Infof("Garbage collection evicted %d versions (%d bytes), %d bytes in use", result.Evicted, result.FreedBytes, result.UsedBytes)
+		}
+	}()
+	return done
+}
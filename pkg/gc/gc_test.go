@@ -0,0 +1,136 @@
+package gc
+
+import (
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/hash"
+	"goFastCache/pkg/logger"
+	"goFastCache/pkg/routes"
+	"goFastCache/pkg/vulndb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"0":      0,
+		"1024":   1024,
+		"500MB":  500 * 1000 * 1000,
+		"10 GiB": 10 << 30,
+		"7B":     7,
+	} {
+		size, err := ParseSize(input)
+		assert.NoError(t, err)
+		assert.Equal(t, size, expected)
+	}
+	for _, input := range []string{"", "MB", "-1", "1.5GB", "10PB"} {
+		_, err := ParseSize(input)
+		assert.Error(t, err)
+	}
+}
+
+// store stores a 100 byte version, last accessed at the given minute and fetched count times
+func store(t *testing.T, db database.Database, blob blobstorage.Storage, path, version string, minute int, count int64) {
+	for artifact, size := range map[routes.Artifact]int{routes.ArtifactInfo: 20, routes.ArtifactMod: 30, routes.ArtifactZip: 50} {
+		assert.NoError(t, blob.Put(routes.GetCacheKey(artifact, path, version), []byte(strings.Repeat("x", size))))
+	}
+	err := db.UpdateGoModuleVersion(path, version, func(v *database.GomoduleVersion) {
+		v.FirstFetched = time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)
+		v.LastAccessed = v.FirstFetched.Add(time.Duration(minute) * time.Minute)
+		v.FetchCount = count
+	})
+	assert.NoError(t, err)
+}
+
+func stored(t *testing.T, db database.Database, blob blobstorage.Storage, path, version string) bool {
+	_, found := blob.Get(routes.GetCacheKey(routes.ArtifactZip, path, version))
+	versions, err := db.ListGoModuleVersions(path)
+	assert.NoError(t, err)
+	catalogued := false
+	for _, v := range versions {
+		catalogued = catalogued || v.Version == version
+	}
+	assert.Equal(t, found, catalogued)
+	return found
+}
+
+func TestCollect_Quota(t *testing.T) {
+	logger.InitLogger()
+	for _, policy := range []Policy{LRU, LFU} {
+		t.Run(string(policy), func(t *testing.T) {
+			cfg := Config{Quota: 250, Policy: policy}
+			db := database.NewMemory()
+			blob := blobstorage.NewMemory()
+			// a is the least recently used, b the least frequently used
+			store(t, db, blob, "example.com/a", "v1.0.0", 1, 5)
+			store(t, db, blob, "example.com/b", "v1.0.0", 2, 1)
+			store(t, db, blob, "example.com/c", "v1.0.0", 3, 9)
+
+			result, err := Collect(cfg, db, blob)
+			assert.NoError(t, err)
+			assert.Equal(t, result, Result{UsedBytes: 200, FreedBytes: 100, Evicted: 1})
+			assert.Equal(t, stored(t, db, blob, "example.com/a", "v1.0.0"), policy != LRU)
+			assert.Equal(t, stored(t, db, blob, "example.com/b", "v1.0.0"), policy != LFU)
+			assert.True(t, stored(t, db, blob, "example.com/c", "v1.0.0"))
+
+			// Within the quota nothing is evicted
+			result, err = Collect(cfg, db, blob)
+			assert.NoError(t, err)
+			assert.Equal(t, result, Result{UsedBytes: 200})
+		})
+	}
+}
+
+func TestCollect_Pinned(t *testing.T) {
+	logger.InitLogger()
+	cfg := Config{Quota: 1, Policy: LRU, Pinned: "example.com/pinned,*.corp.example"}
+	db := database.NewMemory()
+	blob := blobstorage.NewMemory()
+	store(t, db, blob, "example.com/pinned/sub", "v1.0.0", 1, 1)
+	store(t, db, blob, "git.corp.example/tool", "v1.0.0", 2, 1)
+	store(t, db, blob, "example.com/other", "v1.0.0", 3, 1)
+	// Objects of no module neither count towards the quota nor are evicted
+	others := []string{
+		hash.GetSumPath("sum.golang.org", "tile/8/0/001"),
+		routes.QuarantinePrefix + routes.GetCacheKey(routes.ArtifactZip, "example.com/other", "v0.9.0"),
+		vulndb.Prefix + "index/db.json",
+	}
+	for _, key := range others {
+		assert.NoError(t, blob.Put(key, []byte("other")))
+	}
+
+	result, err := Collect(cfg, db, blob)
+	assert.NoError(t, err)
+	assert.Equal(t, result, Result{UsedBytes: 200, FreedBytes: 100, Evicted: 1})
+	assert.True(t, stored(t, db, blob, "example.com/pinned/sub", "v1.0.0"))
+	assert.True(t, stored(t, db, blob, "git.corp.example/tool", "v1.0.0"))
+	assert.False(t, stored(t, db, blob, "example.com/other", "v1.0.0"))
+	for _, key := range others {
+		_, found := blob.Get(key)
+		assert.True(t, found)
+	}
+}
+
+func TestCollect_KeepVersions(t *testing.T) {
+	logger.InitLogger()
+	cfg := Config{Policy: LRU, Pinned: "example.com/pinned", KeepVersions: 2}
+	db := database.NewMemory()
+	blob := blobstorage.NewMemory()
+	for i, version := range []string{"v1.10.0", "v1.2.0", "v1.9.0", "v0.1.0"} {
+		// Old versions are still requested, which must not keep them
+		store(t, db, blob, "example.com/a", version, 10-i, 100)
+		store(t, db, blob, "example.com/pinned", version, 10-i, 100)
+	}
+
+	result, err := Collect(cfg, db, blob)
+	assert.NoError(t, err)
+	assert.Equal(t, result.Evicted, 2)
+	assert.Equal(t, result.FreedBytes, int64(200))
+	assert.False(t, stored(t, db, blob, "example.com/a", "v0.1.0"))
+	assert.False(t, stored(t, db, blob, "example.com/a", "v1.2.0"))
+	assert.True(t, stored(t, db, blob, "example.com/a", "v1.9.0"))
+	assert.True(t, stored(t, db, blob, "example.com/a", "v1.10.0"))
+	assert.True(t, stored(t, db, blob, "example.com/pinned", "v0.1.0"))
+}
@@ -5,7 +5,7 @@ import (
 	"github.com/minio/sha256-simd"
 )
 
-func GetBytes(stringsX []string) []byte {
+func GetBytes(stringsX ...string) []byte {
 	var bytesX = make([]byte, 0)
 	for _, s := range stringsX {
 		bytesX = append(bytesX, []byte(s)...)
@@ -14,7 +14,7 @@ func GetBytes(stringsX []string) []byte {
 }
 
 func GetHash(strings []string) []byte {
-	key := sha256.Sum256(GetBytes(strings))
+	key := sha256.Sum256(GetBytes(strings...))
 	return key[:]
 }
 
@@ -24,6 +24,11 @@ func getMinioPath(uri, version string) string {
 	return bytes[:4] + "/" + bytes[4:8] + "/" + bytes[8:12] + "/" + bytes[12:16] + "/" + bytes[16:] + "/" + version
 }
 
+// GetModulePrefix returns the prefix shared by the artifacts of every version of uri
+func GetModulePrefix(uri string) string {
+	return getMinioPath(uri, "")
+}
+
 func GetModPath(uri, version string) string {
 	return getMinioPath(uri, version) + ".mod"
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/cache"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/upstream"
+	"sync"
+	"time"
+)
+
+// Check is the result of a single dependency check
+type Check struct {
+	Status   string
+	Error    string `json:",omitempty"`
+	Duration string
+}
+
+// Readiness is the response of /readyz, Status is "ok" only if every check is
+type Readiness struct {
+	Status string
+	Checks map[string]Check
+}
+
+// healthz reports that the process is alive, it checks no dependency
+func healthz(c *gin.Context) {
+	c.JSON(200, gin.H{"Status": "ok"})
+}
+
+// readyz checks every dependency concurrently, each within timeout, it answers 503 if any of them fails
+func readyz(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		blob := c.MustGet("blob").(blobstorage.Storage)
+		cacheX := c.MustGet("cache").(cache.Cache)
+		db := c.MustGet("db").(database.Database)
+
+		readiness := checkReadiness(c.Request.Context(), timeout, map[string]func(ctx context.Context) error{
+			"blob":     blob.Ping,
+			"cache":    cacheX.Ping,
+			"database": db.Ping,
+			"upstream": upstream.Ping,
+		})
+		status := 200
+		if readiness.Status != "ok" {
+			status = 503
+		}
+		c.JSON(status, readiness)
+	}
+}
+
+func checkReadiness(ctx context.Context, timeout time.Duration, checks map[string]func(ctx context.Context) error) Readiness {
+	readiness := Readiness{Status: "ok", Checks: make(map[string]Check, len(checks))}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for name, ping := range checks {
+		wg.Add(1)
+		go func(name string, ping func(ctx context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := ping(ctx)
+			check := Check{Status: "ok", Duration: time.Since(start).String()}
+			if err != nil {
+				check.Status = "failed"
+				check.Error = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			readiness.Checks[name] = check
+			if err != nil {
+				readiness.Status = "unavailable"
+			}
+		}(name, ping)
+	}
+	wg.Wait()
+	return readiness
+}
@@ -2,6 +2,7 @@ package index
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"github.com/Masterminds/semver/v3"
 	"github.com/goccy/go-json"
@@ -9,28 +10,54 @@ import (
 	"goFastCache/pkg/blobstorage"
 	"goFastCache/pkg/database"
 	"goFastCache/pkg/routes"
+	"io"
 	"net/http"
-	"regexp"
 	"sort"
+	"sync"
 	"time"
 )
 
+// IndexURL is the module index that is polled for new versions
+var IndexURL = "https://index.golang.org/index"
+
+// indexPageSize is the number of entries index.golang.org returns per request
+const indexPageSize = 2000
+
+// indexPrecision is the precision of the since parameter of the index
+const indexPrecision = time.Microsecond
+
 func getIndexSince(since time.Time) ([]Index, *time.Time) {
 	// https://index.golang.org/index?since=2023-06-20T00:00:00.000000Z
 	// We can only download 2000 packages at a time, so we need to do this in a loop, changing the since param
 
 	var nextSince *time.Time
-	nextSince = &since
 	var err error
 	var indices []Index
 	for {
-		var indicesTemp []Index
-		nextSince, indices, err = downloadIndex(&since)
-		indicesTemp = append(indicesTemp, indices...)
-		if nextSince != nil || err != nil || *nextSince == since {
+		var page []Index
+		var pageSince *time.Time
+		pageSince, page, err = downloadIndex(&since)
+		if err != nil {
 			break
 		}
-		since = *nextSince
+		indices = append(indices, page...)
+		if pageSince == nil {
+			break
+		}
+		nextSince = pageSince
+		// A page that is not full is the last one
+		if len(page) < indexPageSize {
+			break
+		}
+		next := pageSince.Truncate(indexPrecision)
+		if !next.After(since.Truncate(indexPrecision)) {
+			// A full page with a single timestamp would be returned again and again, so the next page starts after it.
+			// The index can not list the versions of that timestamp which did not fit on the page.
+			zap.S().Warnf("Index page since %s has a single timestamp, skipping past it", since.UTC().Format(time.RFC3339Nano))
+			next = next.Add(indexPrecision)
+			nextSince = &next
+		}
+		since = next
 	}
 	if err != nil {
 		zap.S().Errorf("Error downloading index: %v", err)
@@ -78,8 +105,8 @@ type Index struct {
 func downloadIndex(since *time.Time) (nextSince *time.Time, indices []Index, err error) {
 	// convert since to string YYYY-MM-DDTHH:MM:SS.MSZ
 
-	timeString := since.Format("2006-01-02T15:04:05.000000Z")
-	url := fmt.Sprintf("https://index.golang.org/index?since=%s", timeString)
+	timeString := since.UTC().Format("2006-01-02T15:04:05.000000Z")
+	url := fmt.Sprintf("%s?since=%s", IndexURL, timeString)
 	zap.S().Infof("Downloading index since %s", timeString)
 
 	// download index
@@ -122,30 +149,81 @@ func downloadIndex(since *time.Time) (nextSince *time.Time, indices []Index, err
 	return nextSince, indices, nil
 }
 
-func RefreshIndexInBackground(db *database.Database, blob *blobstorage.Blobstore) {
-	for i := 0; i < 10; i++ {
-		go worker(blob)
+// RefreshIndexInBackground reads the index every cfg.RefreshInterval and prefetches new versions until ctx is cancelled.
+// The returned channel is closed once every worker finished its current prefetch, and the cursor is persisted at the oldest
+// version that is still queued or was not prefetched, so the next start reads them from the index and queues them again.
+func RefreshIndexInBackground(ctx context.Context, cfg Config, db database.Database, blob blobstorage.Storage) <-chan struct{} {
+	var wg sync.WaitGroup
+	setWorkers(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(ctx, cfg, db, blob)
+		}()
 	}
+	// since is where the next refresh starts, it is only read once the refreshing goroutine is done
+	var since time.Time
+	wg.Add(1)
 	go func() {
-		now := time.Now().Add(-1 * time.Hour)
+		defer wg.Done()
+		// Resume where the previous run stopped, so no version published in between is skipped
+		var found bool
+		var err error
+		since, found, err = db.GetIndexCursor()
+		if err != nil {
+			zap.S().Errorf("Error getting index cursor: %v", err)
+		}
+		if !found {
+			since = time.Now().Add(-cfg.RefreshInterval)
+		}
 		for {
-			RefreshIndex(db, now)
-			time.Sleep(1 * time.Hour)
+			since = RefreshIndex(ctx, db, since)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cfg.RefreshInterval):
+			}
 		}
 	}()
-}
 
-var PathRegex = regexp.MustCompile(`(.+?/)(.+)`)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		setWorkers(0)
+		// Queued versions stay pending, so the cursor does not move past them
+		unqueued := 0
+		for len(workerChan) > 0 {
+			pending.unqueue(<-workerChan)
+			queued.Add(-1)
+			unqueued++
+		}
+		next := pending.cursor(since)
+		err := db.SetIndexCursor(next)
+		if err != nil {
+			zap.S().Errorf("Error setting index cursor: %v", err)
+		}
+		setCursor(next)
+		if unqueued > 0 {
+			zap.S().Infof("Kept %d queued prefetches pending, the next start queues them again from index cursor %s", unqueued, next.UTC().Format(time.RFC3339Nano))
+		}
+		close(done)
+	}()
+	return done
+}
 
 type workload struct {
-	Domain     string
-	ModuleName string
-	Version    string
+	Path    string
+	Version string
 }
 
 var workerChan = make(chan workload, 10)
 
-func RefreshIndex(db *database.Database, refreshStart time.Time) {
+// RefreshIndex queues every known module with a newer version in the index since refreshStart,
+// and returns and persists where the next refresh has to start. That is the oldest timestamp of a version that
+// has not been prefetched yet, so versions are read from the index again until they are prefetched.
+// If ctx is cancelled while queueing, the cursor stays at refreshStart.
+func RefreshIndex(ctx context.Context, db database.Database, refreshStart time.Time) time.Time {
 	var indices []Index
 	var nextStart *time.Time
 	indices, nextStart = getIndexSince(refreshStart)
@@ -162,47 +240,160 @@ func RefreshIndex(db *database.Database, refreshStart time.Time) {
 			continue
 		}
 		if !found {
+			pending.supersede(index.Path, index.Version)
 			continue
 		}
-		// Check if version is newer
-		x, err := semver.NewVersion(index.Version)
-		if err != nil {
-			zap.S().Errorf("Error parsing version: %v", err)
-			continue
-		}
-		y, err := semver.NewVersion(m.Version)
-		if err != nil {
-			zap.S().Errorf("Error parsing version: %v", err)
-			continue
-		}
-		if x.GreaterThan(y) {
-			// Update version
-			m.Version = index.Version
-			err = db.UpsertGoModule(m)
+		w := workload{Path: m.Path, Version: index.Version}
+		// A pending version is queued again until it is prefetched, even if requests of clients catalogued it meanwhile
+		if !pending.has(w) {
+			// Check if version is newer
+			x, err := semver.NewVersion(index.Version)
 			if err != nil {
-				zap.S().Errorf("Error updating m: %v", err)
+				zap.S().Errorf("Error parsing version: %v", err)
 				continue
 			}
-			// Trigger update of m into blob storage
-			matches := PathRegex.FindStringSubmatch(m.Path)
-			if len(matches) != 3 {
-				zap.S().Errorf("Error parsing m path: %s", m.Path)
+			y, err := semver.NewVersion(m.Version)
+			if err != nil {
+				zap.S().Errorf("Error parsing version: %v", err)
 				continue
 			}
-			uri := matches[1]
-			moduleName := matches[2]
-			workerChan <- workload{
-				Domain:     uri,
-				ModuleName: moduleName,
-				Version:    m.Version,
+			if !x.GreaterThan(y) {
+				pending.supersede(m.Path, index.Version)
+				continue
 			}
 		}
+		// Trigger update of m into blob storage, the version is recorded by process once it is prefetched
+		if !pending.queue(w, index.Timestamp) {
+			continue
+		}
+		queued.Add(1)
+		select {
+		case workerChan <- w:
+		case <-ctx.Done():
+			queued.Add(-1)
+			pending.unqueue(w)
+			return refreshStart
+		}
+	}
+
+	next := pending.cursor(*nextStart)
+	err := db.SetIndexCursor(next)
+	if err != nil {
+		zap.S().Errorf("Error setting index cursor: %v", err)
 	}
+	setCursor(next)
+	return next
 }
 
-func worker(blob *blobstorage.Blobstore) {
+func worker(ctx context.Context, cfg Config, db database.Database, blob blobstorage.Storage) {
 	for {
-		w := <-workerChan
-		routes.
+		select {
+		case <-ctx.Done():
+			return
+		case w := <-workerChan:
+			queued.Add(-1)
+			process(ctx, cfg, db, blob, w)
+		}
+	}
+}
+
+// process prefetches w, retrying with exponential backoff until it succeeds, fails permanently, runs out of retries or ctx is cancelled.
+// Only a prefetched version is recorded, versions that run out of retries or are cancelled stay pending for the next refresh.
+func process(ctx context.Context, cfg Config, db database.Database, blob blobstorage.Storage, w workload) {
+	inProgress.Add(1)
+	defer inProgress.Add(-1)
+
+	backoff := cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err, status := prefetch(db, blob, w)
+		if err == nil {
+			succeeded.Add(1)
+			setLastSuccess()
+			err = record(db, w)
+			if err != nil {
+				zap.S().Errorf("Error recording prefetched %s@%s: %v", w.Path, w.Version, err)
+				pending.unqueue(w)
+				return
+			}
+			pending.supersede(w.Path, w.Version)
+			return
+		}
+		// 403 is returned for modules the policy denies, which retrying does not change
+		if status == 403 || status == 404 || status == 410 {
+			zap.S().Warnf("Failed to prefetch %s@%s: %v", w.Path, w.Version, err)
+			failed.Add(1)
+			setLastError(w, err)
+			pending.forget(w)
+			return
+		}
+		if attempt >= cfg.Retries {
+			zap.S().Warnf("Failed to prefetch %s@%s, retrying after the next refresh: %v", w.Path, w.Version, err)
+			failed.Add(1)
+			setLastError(w, err)
+			pending.unqueue(w)
+			return
+		}
+		zap.S().Debugf("Retrying prefetch of %s@%s in %s: %v", w.Path, w.Version, backoff, err)
+		retried.Add(1)
+		select {
+		case <-ctx.Done():
+			failed.Add(1)
+			setLastError(w, ctx.Err())
+			pending.unqueue(w)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// recordLock makes recording a version and checking that it is newer one step, so a slower worker never records an older version
+var recordLock sync.Mutex
+
+// record stores w.Version as the version of its module, unless a newer one is recorded already
+func record(db database.Database, w workload) error {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	m, found, err := db.GetGoModuleByPath(w.Path)
+	if err != nil || !found {
+		return err
+	}
+	if !isNewer(w.Version, m.Version) {
+		return nil
+	}
+	m.Version = w.Version
+	return db.UpsertGoModule(m)
+}
+
+// prefetch stores the .info, .mod and .zip of w in blob storage, going through the same caches as a client would
+func prefetch(db database.Database, blob blobstorage.Storage, w workload) (error, int) {
+	_, err, status := routes.GetInfo(w.Path, w.Version, db, blob)
+	if err != nil {
+		return fmt.Errorf("info: %w", err), status
+	}
+	_, err, status = routes.GetMod(w.Path, w.Version, db, blob)
+	if err != nil {
+		return fmt.Errorf("mod: %w", err), status
+	}
+
+	if _, found, _ := blob.Stat(routes.GetCacheKey(routes.ArtifactZip, w.Path, w.Version)); found {
+		return nil, 200
+	}
+	zip, _, err, status := routes.GetZipStream(w.Path, w.Version, db, blob)
+	if err != nil {
+		return fmt.Errorf("zip: %w", err), status
+	}
+	// The zip is stored once it has been read completely and closed
+	_, err = io.Copy(io.Discard, zip)
+	errX := zip.Close()
+	if err == nil {
+		err = errX
+	}
+	if err != nil {
+		return fmt.Errorf("zip: %w", err), 502
 	}
+	return nil, 200
 }
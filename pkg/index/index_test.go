@@ -1,9 +1,20 @@
 package index
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/zeebo/assert"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/database"
 	"goFastCache/pkg/logger"
+	"goFastCache/pkg/routes"
+	"goFastCache/pkg/upstream"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -24,3 +35,422 @@ func Test_GetIndexSinceNoData(t *testing.T) {
 	indices, _ := getIndexSince(time.Date(3333, 06, 20, 0, 0, 0, 0, time.UTC))
 	assert.Equal(t, len(indices), 0)
 }
+
+// newFlakyUpstream serves files, but answers the first failures requests of every file with 500
+func newFlakyUpstream(t *testing.T, files map[string]string, failures int) {
+	var lock sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		lock.Unlock()
+		file, found := files[r.URL.Path]
+		switch {
+		case !found:
+			w.WriteHeader(404)
+		case n <= failures:
+			w.WriteHeader(500)
+		default:
+			_, _ = w.Write([]byte(file))
+		}
+	}))
+	t.Cleanup(server.Close)
+	previous := upstream.GetGOPROXY()
+	assert.NoError(t, upstream.SetGOPROXY(server.URL))
+	t.Cleanup(func() {
+		_ = upstream.SetGOPROXY(previous)
+	})
+}
+
+// moduleZip returns a valid zip of module@v1.0.0
+func moduleZip(t *testing.T, module string) string {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	file, err := writer.Create(module + "@v1.0.0/a.go")
+	assert.NoError(t, err)
+	_, err = file.Write([]byte("package a\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buffer.String()
+}
+
+// testConfig retries failed prefetches without any real delay
+func testConfig(retries int) Config {
+	return Config{Workers: 1, Retries: retries, RetryBackoff: time.Millisecond, RefreshInterval: time.Hour}
+}
+
+func Test_Process(t *testing.T) {
+	logger.InitLogger()
+	module := fmt.Sprintf("example.com/prefetch%d", time.Now().UnixNano())
+	newFlakyUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+		"/" + module + "/@v/v1.0.0.mod":  "module " + module + "\n",
+		"/" + module + "/@v/v1.0.0.zip":  moduleZip(t, module),
+	}, 0)
+	blob := blobstorage.NewMemory()
+	db := database.NewMemory()
+	before := GetStatus()
+
+	process(context.Background(), testConfig(2), db, blob, workload{Path: module, Version: "v1.0.0"})
+
+	for artifact, content := range map[routes.Artifact]string{
+		routes.ArtifactInfo: `{"Version":"v1.0.0"}`,
+		routes.ArtifactMod:  "module " + module + "\n",
+		routes.ArtifactZip:  moduleZip(t, module),
+	} {
+		stored, found := blob.Get(routes.GetCacheKey(artifact, module, "v1.0.0"))
+		assert.True(t, found)
+		assert.Equal(t, string(stored), content)
+	}
+	after := GetStatus()
+	assert.Equal(t, after.Succeeded, before.Succeeded+1)
+	assert.NotNil(t, after.LastSuccessAt)
+}
+
+func Test_Process_Retries(t *testing.T) {
+	logger.InitLogger()
+	module := fmt.Sprintf("example.com/retry%d", time.Now().UnixNano())
+	// Zips bypass the upstream response cache, so every retry reaches upstream
+	newFlakyUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.zip": moduleZip(t, module),
+	}, 2)
+	blob := blobstorage.NewMemory()
+	// info and mod are already stored
+	assert.NoError(t, blob.Put(routes.GetCacheKey(routes.ArtifactInfo, module, "v1.0.0"), []byte("{}")))
+	assert.NoError(t, blob.Put(routes.GetCacheKey(routes.ArtifactMod, module, "v1.0.0"), []byte("module")))
+	before := GetStatus()
+
+	process(context.Background(), testConfig(2), database.NewMemory(), blob, workload{Path: module, Version: "v1.0.0"})
+
+	_, found := blob.Get(routes.GetCacheKey(routes.ArtifactZip, module, "v1.0.0"))
+	assert.True(t, found)
+	after := GetStatus()
+	assert.Equal(t, after.Retried, before.Retried+2)
+	assert.Equal(t, after.Succeeded, before.Succeeded+1)
+	assert.Equal(t, after.Failed, before.Failed)
+}
+
+func Test_Process_GivesUp(t *testing.T) {
+	logger.InitLogger()
+	module := fmt.Sprintf("example.com/giveup%d", time.Now().UnixNano())
+	newFlakyUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.zip": moduleZip(t, module),
+	}, 10)
+	blob := blobstorage.NewMemory()
+	assert.NoError(t, blob.Put(routes.GetCacheKey(routes.ArtifactInfo, module, "v1.0.0"), []byte("{}")))
+	assert.NoError(t, blob.Put(routes.GetCacheKey(routes.ArtifactMod, module, "v1.0.0"), []byte("module")))
+	before := GetStatus()
+
+	process(context.Background(), testConfig(1), database.NewMemory(), blob, workload{Path: module, Version: "v1.0.0"})
+	// Missing versions are not retried
+	process(context.Background(), testConfig(1), database.NewMemory(), blob, workload{Path: module, Version: "v2.0.0"})
+
+	after := GetStatus()
+	assert.Equal(t, after.Retried, before.Retried+1)
+	assert.Equal(t, after.Failed, before.Failed+2)
+	assert.True(t, strings.Contains(after.LastError, module+"@v2.0.0"))
+
+	// Clients that may not access the module do not see it
+	assert.Equal(t, after.Redact(func(string) bool { return true }).LastError, after.LastError)
+	redacted := after.Redact(func(modulePath string) bool { return modulePath != module })
+	assert.False(t, strings.Contains(redacted.LastError, module))
+	assert.Equal(t, redacted.LastErrorAt, after.LastErrorAt)
+}
+
+// newFakeIndex serves entries like index.golang.org, entries have to be sorted by timestamp
+func newFakeIndex(t *testing.T, entries []Index) *int {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		since, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("since"))
+		assert.NoError(t, err)
+		n := 0
+		for _, entry := range entries {
+			if entry.Timestamp.Before(since) || n == indexPageSize {
+				continue
+			}
+			n++
+			_, _ = fmt.Fprintf(w, `{"Path":%q,"Version":%q,"Timestamp":%q}`+"\n", entry.Path, entry.Version, entry.Timestamp.Format(time.RFC3339Nano))
+		}
+	}))
+	t.Cleanup(server.Close)
+	previous := IndexURL
+	IndexURL = server.URL
+	t.Cleanup(func() {
+		IndexURL = previous
+	})
+	return &requests
+}
+
+func Test_GetIndexSince_Paging(t *testing.T) {
+	logger.InitLogger()
+	start := time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)
+	entries := make([]Index, 0, 4500)
+	for i := 0; i < 4500; i++ {
+		entries = append(entries, Index{
+			Path:      fmt.Sprintf("example.com/page%d", i),
+			Version:   "v1.0.0",
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+	requests := newFakeIndex(t, entries)
+
+	indices, nextSince := getIndexSince(start)
+	assert.Equal(t, len(indices), 4500)
+	assert.NotNil(t, nextSince)
+	assert.True(t, nextSince.Equal(entries[4499].Timestamp))
+	assert.Equal(t, *requests, 3)
+}
+
+func Test_GetIndexSince_SingleTimestampPage(t *testing.T) {
+	logger.InitLogger()
+	start := time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)
+	stuck := start.Add(time.Millisecond)
+	entries := make([]Index, 0, indexPageSize+10)
+	for i := 0; i < indexPageSize; i++ {
+		entries = append(entries, Index{Path: fmt.Sprintf("example.com/stuck%d", i), Version: "v1.0.0", Timestamp: stuck})
+	}
+	for i := 0; i < 10; i++ {
+		entries = append(entries, Index{
+			Path:      fmt.Sprintf("example.com/after%d", i),
+			Version:   "v1.0.0",
+			Timestamp: stuck.Add(time.Duration(i+1) * time.Microsecond),
+		})
+	}
+	requests := newFakeIndex(t, entries)
+
+	// Once the page since the single timestamp is the same full page, the next one starts after it
+	indices, nextSince := getIndexSince(start)
+	assert.Equal(t, len(indices), indexPageSize+10)
+	assert.NotNil(t, nextSince)
+	assert.True(t, nextSince.Equal(entries[len(entries)-1].Timestamp))
+	assert.Equal(t, *requests, 3)
+
+	// Starting at the single timestamp moves past it too
+	indices, nextSince = getIndexSince(stuck)
+	assert.Equal(t, len(indices), indexPageSize+10)
+	assert.True(t, nextSince.After(stuck))
+}
+
+// restart forgets the queued and pending versions of other tests, like a restarted process
+func restart(t *testing.T) {
+	for len(workerChan) > 0 {
+		<-workerChan
+		queued.Add(-1)
+	}
+	pending = newPendingSet()
+	t.Cleanup(func() {
+		for len(workerChan) > 0 {
+			<-workerChan
+			queued.Add(-1)
+		}
+		pending = newPendingSet()
+	})
+}
+
+func Test_RefreshIndex_PersistsCursor(t *testing.T) {
+	logger.InitLogger()
+	restart(t)
+	start := time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)
+	module := fmt.Sprintf("example.com/cursor%d", time.Now().UnixNano())
+	newFakeIndex(t, []Index{
+		{Path: "example.com/unknown", Version: "v1.0.0", Timestamp: start.Add(time.Second)},
+		{Path: module, Version: "v1.1.0", Timestamp: start.Add(2 * time.Second)},
+	})
+	db := database.NewMemory()
+	assert.NoError(t, db.UpsertGoModule(database.Gomodule{Path: module, Version: "v1.0.0"}))
+
+	next := RefreshIndex(context.Background(), db, start)
+	assert.True(t, next.Equal(start.Add(2*time.Second)))
+	cursor, found, err := db.GetIndexCursor()
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, cursor.Equal(next))
+
+	w := <-workerChan
+	queued.Add(-1)
+	assert.Equal(t, w, workload{Path: module, Version: "v1.1.0"})
+
+	// Nothing new keeps the cursor where it is
+	next = RefreshIndex(context.Background(), db, next)
+	assert.True(t, next.Equal(start.Add(2*time.Second)))
+	assert.Equal(t, len(workerChan), 0)
+}
+
+func Test_RefreshIndex_CancelKeepsVersion(t *testing.T) {
+	logger.InitLogger()
+	restart(t)
+	start := time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)
+	module := fmt.Sprintf("example.com/cancelled%d", time.Now().UnixNano())
+	newFakeIndex(t, []Index{{Path: module, Version: "v1.1.0", Timestamp: start.Add(time.Second)}})
+	db := database.NewMemory()
+	assert.NoError(t, db.UpsertGoModule(database.Gomodule{Path: module, Version: "v1.0.0"}))
+	// A full queue makes queueing wait until ctx is cancelled
+	for len(workerChan) < cap(workerChan) {
+		workerChan <- workload{}
+	}
+	t.Cleanup(func() {
+		for len(workerChan) > 0 {
+			<-workerChan
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The version that was not queued is offered again by the next refresh
+	next := RefreshIndex(ctx, db, start)
+	assert.True(t, next.Equal(start))
+	gomodule, found, err := db.GetGoModuleByPath(module)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, gomodule.Version, "v1.0.0")
+}
+
+func Test_RefreshIndex_RequeuesAfterRestart(t *testing.T) {
+	logger.InitLogger()
+	restart(t)
+	start := time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)
+	first := fmt.Sprintf("example.com/restart%d", time.Now().UnixNano())
+	second := first + "/second"
+	newFakeIndex(t, []Index{
+		{Path: first, Version: "v1.0.0", Timestamp: start.Add(time.Second)},
+		{Path: second, Version: "v1.0.0", Timestamp: start.Add(2 * time.Second)},
+	})
+	// Every file fails once
+	newFlakyUpstream(t, map[string]string{
+		"/" + first + "/@v/v1.0.0.info":  `{"Version":"v1.0.0"}`,
+		"/" + first + "/@v/v1.0.0.mod":   "module " + first + "\n",
+		"/" + first + "/@v/v1.0.0.zip":   moduleZip(t, first),
+		"/" + second + "/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+	}, 1)
+	db := database.NewMemory()
+	for _, path := range []string{first, second} {
+		assert.NoError(t, db.UpsertGoModule(database.Gomodule{Path: path, Version: "v0.9.0"}))
+	}
+	queuedVersions := func() map[string]string {
+		versions := make(map[string]string)
+		for len(workerChan) > 0 {
+			w := <-workerChan
+			queued.Add(-1)
+			versions[w.Path] = w.Version
+		}
+		return versions
+	}
+
+	// Queued versions are neither recorded nor passed by the cursor before they are prefetched
+	next := RefreshIndex(context.Background(), db, start)
+	assert.True(t, next.Equal(start.Add(time.Second)))
+	assert.DeepEqual(t, queuedVersions(), map[string]string{first: "v1.0.0", second: "v1.0.0"})
+	gomodule, _, err := db.GetGoModuleByPath(first)
+	assert.NoError(t, err)
+	assert.Equal(t, gomodule.Version, "v0.9.0")
+
+	// The process stops with both versions still queued, the restarted one queues them again
+	pending = newPendingSet()
+	cursor, found, err := db.GetIndexCursor()
+	assert.NoError(t, err)
+	assert.True(t, found)
+	next = RefreshIndex(context.Background(), db, cursor)
+	assert.True(t, next.Equal(start.Add(time.Second)))
+	assert.DeepEqual(t, queuedVersions(), map[string]string{first: "v1.0.0", second: "v1.0.0"})
+
+	// Once the first one is prefetched, it is recorded and the cursor moves on to the second one
+	process(context.Background(), testConfig(6), db, blobstorage.NewMemory(), workload{Path: first, Version: "v1.0.0"})
+	gomodule, _, err = db.GetGoModuleByPath(first)
+	assert.NoError(t, err)
+	assert.Equal(t, gomodule.Version, "v1.0.0")
+	// The second one runs out of retries, so the next refresh queues it again
+	process(context.Background(), testConfig(0), db, blobstorage.NewMemory(), workload{Path: second, Version: "v1.0.0"})
+	next = RefreshIndex(context.Background(), db, next)
+	assert.True(t, next.Equal(start.Add(2*time.Second)))
+	assert.DeepEqual(t, queuedVersions(), map[string]string{second: "v1.0.0"})
+}
+
+func Test_Process_StopsOnCancel(t *testing.T) {
+	logger.InitLogger()
+	module := fmt.Sprintf("example.com/cancel%d", time.Now().UnixNano())
+	newFlakyUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.zip": moduleZip(t, module),
+	}, 10)
+	blob := blobstorage.NewMemory()
+	assert.NoError(t, blob.Put(routes.GetCacheKey(routes.ArtifactInfo, module, "v1.0.0"), []byte("{}")))
+	assert.NoError(t, blob.Put(routes.GetCacheKey(routes.ArtifactMod, module, "v1.0.0"), []byte("module")))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg := testConfig(5)
+	cfg.RetryBackoff = time.Hour
+
+	// The backoff is not waited for once ctx is cancelled
+	start := time.Now()
+	process(ctx, cfg, database.NewMemory(), blob, workload{Path: module, Version: "v1.0.0"})
+	assert.True(t, time.Since(start) < time.Minute)
+	assert.True(t, strings.Contains(GetStatus().LastError, context.Canceled.Error()))
+}
+
+func Test_RefreshIndexInBackground_Stops(t *testing.T) {
+	logger.InitLogger()
+	restart(t)
+	newFakeIndex(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := RefreshIndexInBackground(ctx, testConfig(0), database.NewMemory(), blobstorage.NewMemory())
+	assert.Equal(t, GetStatus().Workers, 1)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("background refresh did not stop")
+	}
+	assert.Equal(t, GetStatus().Workers, 0)
+}
+
+func Test_RefreshIndexInBackground_KeepsQueued(t *testing.T) {
+	logger.InitLogger()
+	restart(t)
+	start := time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)
+	first := fmt.Sprintf("example.com/shutdown%d", time.Now().UnixNano())
+	second := first + "/second"
+	newFakeIndex(t, []Index{
+		{Path: first, Version: "v1.0.0", Timestamp: start.Add(time.Second)},
+		{Path: second, Version: "v1.0.0", Timestamp: start.Add(2 * time.Second)},
+	})
+	// The worker waits for a retry of the first version, while the second one is queued
+	newFlakyUpstream(t, map[string]string{
+		"/" + first + "/@v/v1.0.0.info":  `{"Version":"v1.0.0"}`,
+		"/" + second + "/@v/v1.0.0.info": `{"Version":"v1.0.0"}`,
+	}, 100)
+	db := database.NewMemory()
+	for _, path := range []string{first, second} {
+		assert.NoError(t, db.UpsertGoModule(database.Gomodule{Path: path, Version: "v0.9.0"}))
+	}
+	assert.NoError(t, db.SetIndexCursor(start))
+	cfg := testConfig(5)
+	cfg.RetryBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := RefreshIndexInBackground(ctx, cfg, db, blobstorage.NewMemory())
+	deadline := time.Now().Add(10 * time.Second)
+	for (GetStatus().InProgress != 1 || GetStatus().Queued != 1) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, GetStatus().Queued, int64(1))
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("background refresh did not stop")
+	}
+
+	// Neither version is lost, the cursor is persisted before both of them
+	assert.Equal(t, len(workerChan), 0)
+	assert.Equal(t, GetStatus().Queued, int64(0))
+	cursor, found, err := db.GetIndexCursor()
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, cursor.Equal(start.Add(time.Second)))
+
+	pending = newPendingSet()
+	RefreshIndex(context.Background(), db, cursor)
+	assert.Equal(t, len(workerChan), 2)
+}
@@ -0,0 +1,38 @@
+package index
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+func init() {
+	gauge := func(name, help string, value func(Status) float64) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{Namespace: "gofastcache", Subsystem: "index", Name: name, Help: help}, func() float64 {
+			return value(GetStatus())
+		})
+	}
+	counter := func(name, help string, value func(Status) float64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{Namespace: "gofastcache", Subsystem: "index", Name: name, Help: help}, func() float64 {
+			return value(GetStatus())
+		})
+	}
+
+	gauge("workers", "Number of running prefetch workers.", func(s Status) float64 { return float64(s.Workers) })
+	gauge("queue_depth", "Versions waiting for a prefetch worker.", func(s Status) float64 { return float64(s.Queued) })
+	gauge("in_progress", "Versions being prefetched right now.", func(s Status) float64 { return float64(s.InProgress) })
+	counter("prefetch_succeeded_total", "Versions prefetched successfully.", func(s Status) float64 { return float64(s.Succeeded) })
+	counter("prefetch_failed_total", "Versions whose prefetch failed after every retry.", func(s Status) float64 { return float64(s.Failed) })
+	counter("prefetch_retries_total", "Retried prefetch attempts.", func(s Status) float64 { return float64(s.Retried) })
+	gauge("cursor_timestamp_seconds", "Index timestamp the next refresh starts at, versions before it have been prefetched.", func(s Status) float64 {
+		if s.Cursor == nil {
+			return 0
+		}
+		return float64(s.Cursor.UnixNano()) / 1e9
+	})
+	gauge("last_success_timestamp_seconds", "Time of the last successful prefetch.", func(s Status) float64 {
+		if s.LastSuccessAt == nil {
+			return 0
+		}
+		return float64(s.LastSuccessAt.UnixNano()) / 1e9
+	})
+}
@@ -0,0 +1,107 @@
+package index
+
+import (
+	"github.com/Masterminds/semver/v3"
+	"sync"
+	"time"
+)
+
+// pendingWork is a version from the index that has not been prefetched yet
+type pendingWork struct {
+	timestamp time.Time
+	// queued is set while the version waits in workerChan or is being prefetched
+	queued bool
+}
+
+// pendingSet holds the versions from the index that were queued, but not prefetched yet.
+// The cursor never moves past the oldest of them, so refreshes read them again until they are prefetched.
+type pendingSet struct {
+	lock  sync.Mutex
+	works map[workload]*pendingWork
+}
+
+func newPendingSet() *pendingSet {
+	return &pendingSet{
+		works: make(map[workload]*pendingWork),
+	}
+}
+
+// pending holds the versions of this process that have not been prefetched yet
+var pending = newPendingSet()
+
+// queue marks w as queued and drops the older versions of its module, it returns false if w is queued already
+func (p *pendingSet) queue(w workload, timestamp time.Time) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if work, found := p.works[w]; found && work.queued {
+		return false
+	}
+	for other := range p.works {
+		if other.Path == w.Path && isNewer(w.Version, other.Version) {
+			delete(p.works, other)
+		}
+	}
+	p.works[w] = &pendingWork{timestamp: timestamp, queued: true}
+	return true
+}
+
+// has reports whether w is pending
+func (p *pendingSet) has(w workload) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, found := p.works[w]
+	return found
+}
+
+// unqueue keeps w pending, but lets the next refresh queue it again
+func (p *pendingSet) unqueue(w workload) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if work, found := p.works[w]; found {
+		work.queued = false
+	}
+}
+
+// forget drops w, the next refresh only queues it again if it is still newer than the recorded version
+func (p *pendingSet) forget(w workload) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.works, w)
+}
+
+// supersede drops every version of path that is not newer than version
+func (p *pendingSet) supersede(path, version string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for w := range p.works {
+		if w.Path == path && !isNewer(w.Version, version) {
+			delete(p.works, w)
+		}
+	}
+}
+
+// cursor returns where the next refresh has to start, which is next unless an older version is still pending
+func (p *pendingSet) cursor(next time.Time) time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, work := range p.works {
+		if work.timestamp.Before(next) {
+			next = work.timestamp
+		}
+	}
+	return next
+}
+
+// isNewer reports whether version a is newer than b. A version that can not be parsed is never newer,
+// and every version is newer than one that can not be parsed.
+func isNewer(a, b string) bool {
+	x, err := semver.NewVersion(a)
+	if err != nil {
+		return false
+	}
+	y, err := semver.NewVersion(b)
+	if err != nil {
+		return true
+	}
+	return x.GreaterThan(y)
+}
@@ -0,0 +1,119 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config controls how new versions from the index are prefetched
+type Config struct {
+	// Workers is the number of concurrent prefetch workers
+	Workers int `yaml:"workers" env:"INDEX_WORKERS"`
+	// Retries is how often a failed prefetch is retried, not counting the first attempt
+	Retries int `yaml:"retries" env:"INDEX_RETRIES"`
+	// RetryBackoff is the delay before the first retry, it doubles with every further retry
+	RetryBackoff time.Duration `yaml:"retry_backoff" env:"INDEX_RETRY_BACKOFF"`
+	// RefreshInterval is the delay between two reads of the index
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"INDEX_REFRESH_INTERVAL"`
+}
+
+const maxRetryBackoff = time.Minute
+
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.Workers < 1 {
+		errs = append(errs, errors.New("index workers has to be a positive number"))
+	}
+	if cfg.Retries < 0 {
+		errs = append(errs, errors.New("index retries has to be zero or a positive number"))
+	}
+	if cfg.RetryBackoff <= 0 {
+		errs = append(errs, errors.New("index retry backoff has to be a positive duration"))
+	}
+	if cfg.RefreshInterval <= 0 {
+		errs = append(errs, errors.New("index refresh interval has to be a positive duration"))
+	}
+	return errors.Join(errs...)
+}
+
+// Status reports the progress of the prefetch workers
+type Status struct {
+	Workers       int
+	Queued        int64
+	InProgress    int64
+	Succeeded     int64
+	Failed        int64
+	Retried       int64
+	LastSuccessAt *time.Time `json:",omitempty"`
+	LastErrorAt   *time.Time `json:",omitempty"`
+	LastError     string     `json:",omitempty"`
+	// LastErrorModule is the module path LastError is about
+	LastErrorModule string `json:"-"`
+	// Cursor is the index timestamp the next refresh starts at, versions before it have been prefetched
+	Cursor *time.Time `json:",omitempty"`
+}
+
+var runningWorkers atomic.Int64
+var queued, inProgress, succeeded, failed, retried atomic.Int64
+
+var lastLock sync.Mutex
+var lastSuccessAt, lastErrorAt *time.Time
+var lastError, lastErrorModule string
+var cursor *time.Time
+
+func setWorkers(n int) {
+	runningWorkers.Store(int64(n))
+}
+
+func setLastSuccess() {
+	now := time.Now().UTC()
+	lastLock.Lock()
+	defer lastLock.Unlock()
+	lastSuccessAt = &now
+}
+
+func setLastError(w workload, err error) {
+	now := time.Now().UTC()
+	lastLock.Lock()
+	defer lastLock.Unlock()
+	lastErrorAt = &now
+	lastError = fmt.Sprintf("%s@%s: %v", w.Path, w.Version, err)
+	lastErrorModule = w.Path
+}
+
+func setCursor(since time.Time) {
+	lastLock.Lock()
+	defer lastLock.Unlock()
+	cursor = &since
+}
+
+// GetStatus returns a snapshot of the prefetch progress
+func GetStatus() Status {
+	lastLock.Lock()
+	defer lastLock.Unlock()
+	return Status{
+		Workers:         int(runningWorkers.Load()),
+		Queued:          queued.Load(),
+		InProgress:      inProgress.Load(),
+		Succeeded:       succeeded.Load(),
+		Failed:          failed.Load(),
+		Retried:         retried.Load(),
+		LastSuccessAt:   lastSuccessAt,
+		LastErrorAt:     lastErrorAt,
+		LastError:       lastError,
+		LastErrorModule: lastErrorModule,
+		Cursor:          cursor,
+	}
+}
+
+// Redact leaves the module out of the last error, unless allows reports that the client may access it
+func (s Status) Redact(allows func(modulePath string) bool) Status {
+	if s.LastErrorModule != "" && !allows(s.LastErrorModule) {
+		s.LastError = "prefetching a module the client may not access failed"
+		s.LastErrorModule = ""
+	}
+	return s
+}
@@ -0,0 +1,35 @@
+package license
+
+import (
+	"errors"
+	"strings"
+)
+
+// Config controls the detection of the licenses of stored zips
+type Config struct {
+	// Detect classifies the license files of every zip that is stored
+	Detect bool `yaml:"detect" env:"LICENSE_DETECT"`
+	// Allow lists the SPDX identifiers of the licenses that are served, separated by commas.
+	// Empty serves every version, whatever its licenses are.
+	Allow string `yaml:"allow" env:"LICENSE_ALLOW"`
+	// Classifiers is how many license files are classified at once, every classifier takes about 70 MB of memory
+	Classifiers int `yaml:"classifiers" env:"LICENSE_CLASSIFIERS"`
+}
+
+func (cfg Config) Validate() error {
+	if cfg.Classifiers < 0 {
+		return errors.New("license classifiers must not be negative")
+	}
+	if cfg.Allow == "" {
+		return nil
+	}
+	if !cfg.Detect {
+		return errors.New("a license allow list requires license detection")
+	}
+	for _, id := range strings.Split(cfg.Allow, ",") {
+		if strings.TrimSpace(id) == "" {
+			return errors.New("license allow list contains an empty identifier")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package license
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	classifier "github.com/google/licenseclassifier/v2"
+	"github.com/google/licenseclassifier/v2/assets"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// maxLicenseFile is the most that is read of a license file, longer files are no plain license text
+const maxLicenseFile = 1 << 20
+
+// licenseNames are the base names of license files, they may have an extension or a suffix like LICENSE-MIT
+var licenseNames = []string{"LICENSE", "LICENCE", "COPYING", "UNLICENSE"}
+
+// Detector classifies the license files of module zips
+type Detector struct {
+	// classifiers holds the idle classifiers, every classification takes one,
+	// as a classifier is not documented to be safe for concurrent use
+	classifiers chan *classifier.Classifier
+	// loaded counts the classifiers, they are loaded on demand up to the capacity of classifiers
+	loaded atomic.Int32
+	allow  map[string]bool
+}
+
+// New returns a Detector for cfg, or nil if detection is disabled
+func New(cfg Config) (*Detector, error) {
+	if !cfg.Detect {
+		return nil, nil
+	}
+	size := cfg.Classifiers
+	if size < 1 {
+		size = 1
+	}
+	d := &Detector{classifiers: make(chan *classifier.Classifier, size), allow: make(map[string]bool)}
+	// The first classifier is loaded right away, so broken assets fail at startup
+	c, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	d.classifiers <- c
+	for _, id := range strings.Split(cfg.Allow, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			d.allow[id] = true
+		}
+	}
+	return d, nil
+}
+
+// isLicenseFile reports whether name is the name of a license file, like LICENSE, LICENSE.md or COPYING-GPL
+func isLicenseFile(name string) bool {
+	name = strings.ToUpper(name)
+	for _, licenseName := range licenseNames {
+		rest, found := strings.CutPrefix(name, licenseName)
+		if found && (rest == "" || strings.ContainsRune(".-_", rune(rest[0]))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect returns the sorted SPDX identifiers of the licenses in the license files at the root of the module zip z.
+// prefix is the directory of the module in the zip, path@version/.
+func (d *Detector) Detect(z *zip.Reader, prefix string) ([]string, error) {
+	found := make(map[string]bool)
+	for _, file := range z.File {
+		name, inModule := strings.CutPrefix(file.Name, prefix)
+		if !inModule || strings.Contains(name, "/") || !isLicenseFile(path.Base(name)) {
+			continue
+		}
+		ids, err := d.classify(file)
+		if err != nil {
+			return nil, fmt.Errorf("classifying %s: %w", file.Name, err)
+		}
+		for _, id := range ids {
+			found[id] = true
+		}
+	}
+	licenses := make([]string, 0, len(found))
+	for id := range found {
+		licenses = append(licenses, id)
+	}
+	sort.Strings(licenses)
+	return licenses, nil
+}
+
+// classify returns the licenses whose full text is in file, license headers and notices are ignored
+func (d *Detector) classify(file *zip.File) ([]string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, maxLicenseFile))
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	results := c.Match(content)
+	d.classifiers <- c
+
+	var ids []string
+	for _, match := range results.Matches {
+		if match.MatchType == "License" {
+			ids = append(ids, match.Name)
+		}
+	}
+	return ids, nil
+}
+
+// Enforced reports whether Check can refuse versions, which needs an allow list
+func (d *Detector) Enforced() bool {
+	return d != nil && len(d.allow) > 0
+}
+
+// acquire takes an idle classifier, loads another one if there are less than allowed, or waits for one to be idle.
+// The classifier has to be put back into d.classifiers.
+func (d *Detector) acquire() (*classifier.Classifier, error) {
+	select {
+	case c := <-d.classifiers:
+		return c, nil
+	default:
+		return d.load()
+	}
+}
+
+// load loads another classifier, unless there are already as many as allowed, in which case it waits for an idle one
+func (d *Detector) load() (*classifier.Classifier, error) {
+	if int(d.loaded.Add(1)) > cap(d.classifiers) {
+		d.loaded.Add(-1)
+		return <-d.classifiers, nil
+	}
+	c, err := assets.DefaultClassifier()
+	if err != nil {
+		d.loaded.Add(-1)
+		return nil, fmt.Errorf("loading the license classifier: %w", err)
+	}
+	return c, nil
+}
+
+// Check returns why a version with licenses is refused, or nil if it is served.
+// With an allow list, a version needs at least one license and every license has to be allowed.
+func (d *Detector) Check(licenses []string) error {
+	if !d.Enforced() {
+		return nil
+	}
+	if len(licenses) == 0 {
+		return errors.New("no license found")
+	}
+	var refused []string
+	for _, id := range licenses {
+		if !d.allow[id] {
+			refused = append(refused, id)
+		}
+	}
+	if len(refused) > 0 {
+		return fmt.Errorf("license %s is not allowed", strings.Join(refused, ", "))
+	}
+	return nil
+}
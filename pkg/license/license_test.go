@@ -0,0 +1,149 @@
+package license
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/zeebo/assert"
+	"sync"
+	"testing"
+)
+
+const mit = `MIT License
+
+Copyright (c) 2023 Example
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+`
+
+const isc = `Copyright (c) 2023 Example
+
+Permission to use, copy, modify, and/or distribute this software for any
+purpose with or without fee is hereby granted, provided that the above
+copyright notice and this permission notice appear in all copies.
+
+THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+`
+
+func newZip(t *testing.T, files map[string]string) *zip.Reader {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		assert.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	z, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	assert.NoError(t, err)
+	return z
+}
+
+func TestIsLicenseFile(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"LICENSE":        true,
+		"license.md":     true,
+		"LICENCE.txt":    true,
+		"COPYING":        true,
+		"LICENSE-MIT":    true,
+		"UNLICENSE":      true,
+		"LICENSES":       false,
+		"README.md":      false,
+		"COPYING_NOTICE": true,
+	} {
+		assert.Equal(t, isLicenseFile(name), expected)
+	}
+}
+
+func TestDetector_Detect(t *testing.T) {
+	d, err := New(Config{Detect: true})
+	assert.NoError(t, err)
+
+	z := newZip(t, map[string]string{
+		"example.com/a@v1.0.0/LICENSE-MIT": mit,
+		"example.com/a@v1.0.0/COPYING.isc": isc,
+		"example.com/a@v1.0.0/README.md":   mit,
+		// Only the license files of the module root count
+		"example.com/a@v1.0.0/vendor/LICENSE": "GNU GENERAL PUBLIC LICENSE",
+	})
+	licenses, err := d.Detect(z, "example.com/a@v1.0.0/")
+	assert.NoError(t, err)
+	assert.DeepEqual(t, licenses, []string{"ISC", "MIT"})
+
+	licenses, err = d.Detect(newZip(t, map[string]string{"example.com/a@v1.0.0/go.mod": "module example.com/a\n"}), "example.com/a@v1.0.0/")
+	assert.NoError(t, err)
+	assert.Equal(t, len(licenses), 0)
+}
+
+func TestDetector_DetectConcurrently(t *testing.T) {
+	d, err := New(Config{Detect: true, Classifiers: 2})
+	assert.NoError(t, err)
+	z := newZip(t, map[string]string{"example.com/a@v1.0.0/LICENSE": mit})
+
+	var wg sync.WaitGroup
+	results := make([][]string, 8)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = d.Detect(z, "example.com/a@v1.0.0/")
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		assert.NoError(t, errs[i])
+		assert.DeepEqual(t, results[i], []string{"MIT"})
+	}
+	// Classifiers are loaded on demand, but never more than configured
+	assert.True(t, d.loaded.Load() <= 2)
+	assert.Equal(t, int(d.loaded.Load()), len(d.classifiers))
+}
+
+func TestDetector_Check(t *testing.T) {
+	d, err := New(Config{Detect: true, Allow: "MIT, Apache-2.0"})
+	assert.NoError(t, err)
+	assert.NoError(t, d.Check([]string{"MIT"}))
+	assert.NoError(t, d.Check([]string{"Apache-2.0", "MIT"}))
+	assert.Equal(t, d.Check([]string{"GPL-3.0", "MIT"}).Error(), "license GPL-3.0 is not allowed")
+	assert.Equal(t, d.Check(nil).Error(), "no license found")
+
+	// Without an allow list every version is served
+	d, err = New(Config{Detect: true})
+	assert.NoError(t, err)
+	assert.NoError(t, d.Check(nil))
+	d, err = New(Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+	assert.NoError(t, d.Check(nil))
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.NoError(t, Config{Detect: true, Allow: "MIT,BSD-3-Clause"}.Validate())
+	assert.Error(t, Config{Allow: "MIT"}.Validate())
+	assert.Error(t, Config{Detect: true, Allow: "MIT,,BSD-3-Clause"}.Validate())
+	assert.Error(t, Config{Detect: true, Classifiers: -1}.Validate())
+}
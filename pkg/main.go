@@ -1,61 +1,200 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"goFastCache/pkg/auth"
 	"goFastCache/pkg/blobstorage"
 	"goFastCache/pkg/cache"
+	"goFastCache/pkg/config"
 	"goFastCache/pkg/database"
+	"goFastCache/pkg/gc"
 	"goFastCache/pkg/index"
+	"goFastCache/pkg/license"
 	"goFastCache/pkg/logger"
+	"goFastCache/pkg/policy"
+	"goFastCache/pkg/routes"
+	"goFastCache/pkg/upstream"
+	"goFastCache/pkg/vulndb"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	// Initialize logger
 	logger.InitLogger()
 
+	// Read the configuration from the config file, the environment and the flags
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		zap.S().Fatalf("Invalid configuration: %v", err)
+	}
+
+	// "goFastCache gc" collects once and exits
+	if len(args) > 0 && args[0] == "gc" {
+		collectOnce(cfg)
+		return
+	}
+
+	// Initialize upstream proxies
+	err = upstream.Configure(cfg.Upstream)
+	if err != nil {
+		zap.S().Fatalf("Invalid upstream configuration: %v", err)
+	}
+
 	// Initialize blob storage
-	blob, err := blobstorage.NewBlobstore()
+	blob, err := blobstorage.NewStorage(cfg.Storage)
 	if err != nil {
-		zap.S().Fatalf("Unable to connect to Minio: %v", err)
+		zap.S().Fatalf("Unable to connect to blob storage: %v", err)
 	}
 
 	// Initialize cache
-	var cacheX *cache.Cache
-	cacheX, err = cache.NewCache()
+	var cacheX cache.Cache
+	cacheX, err = cache.NewCache(cfg.Cache)
 	if err != nil {
 		zap.S().Fatalf("Unable to connect to Redis: %v", err)
 	}
 
+	// Configure coalescing, negative caching and checksum verification
+	err = routes.Configure(cfg.Routes, cacheX, blob)
+	if err != nil {
+		zap.S().Fatalf("Invalid routes configuration: %v", err)
+	}
+
+	// Load the policy deciding which modules are served
+	routes.Policy, err = policy.New(cfg.Policy)
+	if err != nil {
+		zap.S().Fatalf("Invalid policy: %v", err)
+	}
+
+	// Load the classifier detecting the licenses of stored zips
+	routes.Licenses, err = license.New(cfg.License)
+	if err != nil {
+		zap.S().Fatalf("Unable to detect licenses: %v", err)
+	}
+
+	// Mirror the vulnerability database, if it is enabled
+	routes.VulnDB = vulndb.New(cfg.VulnDB, blob)
+
 	// Initialize database
-	db, err := database.NewDatabase()
+	db, err := database.NewDatabase(cfg.Database)
 	if err != nil {
 		zap.S().Fatalf("Unable to connect to database: %v", err)
 	}
 
+	// Initialize client authentication
+	clientAuth, err := auth.New(cfg.Auth)
+	if err != nil {
+		zap.S().Fatalf("Invalid authentication configuration: %v", err)
+	}
+
+	// Background jobs and the server run until SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	indexDone := index.RefreshIndexInBackground(ctx, cfg.Index, db, blob)
+	gcDone := gc.CollectInBackground(ctx, cfg.GC, db, blob)
+	policyDone := routes.Policy.ReloadInBackground(ctx, cfg.Policy.ReloadInterval)
+	vulnDBDone := routes.VulnDB.RefreshInBackground(ctx, cfg.VulnDB.RefreshInterval)
+
 	// Initialize router
+	router := newRouter(blob, cacheX, db, routerConfig{
+		Auth:             clientAuth,
+		ProtectMetrics:   cfg.Auth.ProtectMetrics,
+		ReadinessTimeout: cfg.ReadinessTimeout,
+	})
+
+	// Start server
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           router,
+		TLSConfig:         clientAuth.TLSConfig(),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLSCert != "" {
+			serveErr <- server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+			return
+		}
+		serveErr <- server.ListenAndServe()
+	}()
+	zap.S().Infof("Listening on %s", cfg.Listen)
+
+	select {
+	case err = <-serveErr:
+		zap.S().Fatalf("Unable to start server: %v", err)
+	case <-ctx.Done():
+	}
+	// A second signal terminates immediately
+	stop()
+	shutdown(server, cfg.ShutdownTimeout, cacheX, db, indexDone, gcDone, policyDone, vulnDBDone)
+}
+
+func collectOnce(cfg config.Config) {
+	blob, err := blobstorage.NewStorage(cfg.Storage)
+	if err != nil {
+		zap.S().Fatalf("Unable to connect to blob storage: %v", err)
+	}
+	db, err := database.NewDatabase(cfg.Database)
+	if err != nil {
+		zap.S().Fatalf("Unable to connect to database: %v", err)
+	}
+	result, err := gc.Collect(cfg.GC, db, blob)
+	if err != nil {
+		zap.S().Fatalf("Garbage collection failed: %v", err)
+	}
+	zap.S().Infof("Garbage collection evicted %d versions (%d bytes), %d bytes in use, %d failed", result.Evicted, result.FreedBytes, result.UsedBytes, result.Failed)
+}
+
+// routerConfig holds the settings of the handlers of this package
+type routerConfig struct {
+	// Auth authenticates proxy clients, nil allows every client
+	Auth *auth.Authenticator
+	// ProtectMetrics requires Auth credentials for /metrics too
+	ProtectMetrics bool
+	// ReadinessTimeout bounds every dependency check of /readyz
+	ReadinessTimeout time.Duration
+}
+
+func newRouter(blob blobstorage.Storage, cacheX cache.Cache, db database.Database, cfg routerConfig) *gin.Engine {
 	router := gin.Default()
 
 	// Use middleware to store the db and minioClient in the context
 	router.Use(func(c *gin.Context) {
-		// c.Set("db", db)
 		c.Set("blob", blob)
 		c.Set("cache", cacheX)
 		c.Set("db", db)
 		c.Next()
 	})
 
+	// Authenticate clients, probes and unless ProtectMetrics scrapers have to work without credentials
+	if cfg.Auth != nil {
+		public := []string{"/healthz", "/readyz"}
+		if !cfg.ProtectMetrics {
+			public = append(public, "/metrics")
+		}
+		router.Use(cfg.Auth.Middleware(requestModulePath, public...))
+	}
+
 	// Register routes
-	registerRoutes(router)
+	registerRoutes(router, rawRoutes(cfg.ReadinessTimeout))
 
 	// Set error handler for missing routes
 	router.NoRoute(func(c *gin.Context) {
 		c.String(404, fmt.Sprintf("Route %s not found", c.Request.URL.Path))
 	})
 
-	index.RefreshIndexInBackground(db, blob)
-
-	// Start server
-	router.Run()
+	return router
 }
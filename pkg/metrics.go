@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"time"
+)
+
+var typeNames = map[Type]string{
+	RAW:    "raw",
+	LIST:   "list",
+	LATEST: "latest",
+	INFO:   "info",
+	MOD:    "mod",
+	ZIP:    "zip",
+	SUMDB:  "sumdb",
+	VULNDB: "vulndb",
+}
+
+func (t Type) String() string {
+	if name, found := typeNames[t]; found {
+		return name
+	}
+	return "unknown"
+}
+
+var requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gofastcache",
+	Name:      "request_duration_seconds",
+	Help:      "Latency of served requests by artifact type and response status.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+}, []string{"type", "status"})
+
+var bytesServed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gofastcache",
+	Name:      "served_bytes_total",
+	Help:      "Response body bytes served by artifact type.",
+}, []string{"type"})
+
+func observeRequest(t Type, start time.Time, status, size int) {
+	requestDuration.WithLabelValues(t.String(), strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	if size > 0 {
+		bytesServed.WithLabelValues(t.String()).Add(float64(size))
+	}
+}
@@ -0,0 +1,68 @@
+package modpath
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Escape encodes a module path or version for use in GOPROXY urls.
+// Every uppercase letter is replaced by an exclamation mark followed by its lowercase letter,
+// e.g. github.com/Azure becomes github.com/!azure.
+func Escape(s string) (string, error) {
+	var builder strings.Builder
+	for _, r := range s {
+		if r == '!' || r >= utf8.RuneSelf {
+			return "", fmt.Errorf("invalid character %q in %q", r, s)
+		}
+		if 'A' <= r && r <= 'Z' {
+			builder.WriteByte('!')
+			builder.WriteRune(r + 'a' - 'A')
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String(), nil
+}
+
+// Unescape reverses Escape.
+// Escaped strings never contain uppercase letters, so their presence is an error, as is a "!" not followed by a lowercase letter.
+func Unescape(s string) (string, error) {
+	var builder strings.Builder
+	bang := false
+	for _, r := range s {
+		if r >= utf8.RuneSelf || ('A' <= r && r <= 'Z') {
+			return "", fmt.Errorf("invalid character %q in escaped %q", r, s)
+		}
+		if bang {
+			if r < 'a' || r > 'z' {
+				return "", fmt.Errorf("invalid escape sequence in %q", s)
+			}
+			builder.WriteRune(r + 'A' - 'a')
+			bang = false
+			continue
+		}
+		if r == '!' {
+			bang = true
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	if bang {
+		return "", fmt.Errorf("invalid escape sequence in %q", s)
+	}
+	return builder.String(), nil
+}
+
+// Canonical returns the unescaped form of path without trailing slashes,
+// so a module is stored under the same name regardless of how it was requested.
+// Paths that are not validly escaped are assumed to be unescaped already.
+func Canonical(path string) string {
+	path = strings.TrimRight(path, "/")
+	if strings.Contains(path, "!") {
+		if unescaped, err := Unescape(path); err == nil {
+			return unescaped
+		}
+	}
+	return path
+}
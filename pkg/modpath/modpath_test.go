@@ -0,0 +1,48 @@
+package modpath
+
+import (
+	"github.com/zeebo/assert"
+	"testing"
+)
+
+var testCases = []struct {
+	unescaped string
+	escaped   string
+}{
+	{"github.com/Azure/azure-sdk-for-go", "github.com/!azure/azure-sdk-for-go"},
+	{"github.com/Masterminds/semver/v3", "github.com/!masterminds/semver/v3"},
+	{"github.com/OneOfOne/xxhash", "github.com/!one!of!one/xxhash"},
+	{"github.com/jinzhu/inflection", "github.com/jinzhu/inflection"},
+	{"v1.0.0-RC1", "v1.0.0-!r!c1"},
+	{"v9.31.0+incompatible", "v9.31.0+incompatible"},
+}
+
+func TestEscape(t *testing.T) {
+	for _, tc := range testCases {
+		escaped, err := Escape(tc.unescaped)
+		assert.NoError(t, err)
+		assert.Equal(t, escaped, tc.escaped)
+	}
+	_, err := Escape("github.com/!azure")
+	assert.Error(t, err)
+	_, err = Escape("github.com/ä")
+	assert.Error(t, err)
+}
+
+func TestUnescape(t *testing.T) {
+	for _, tc := range testCases {
+		unescaped, err := Unescape(tc.escaped)
+		assert.NoError(t, err)
+		assert.Equal(t, unescaped, tc.unescaped)
+	}
+	for _, invalid := range []string{"github.com/Azure", "github.com/!", "github.com/!!azure", "github.com/!1"} {
+		_, err := Unescape(invalid)
+		assert.Error(t, err)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	assert.Equal(t, Canonical("github.com/!azure/go-autorest/"), "github.com/Azure/go-autorest")
+	assert.Equal(t, Canonical("github.com/Azure/go-autorest"), "github.com/Azure/go-autorest")
+	assert.Equal(t, Canonical("github.com/jinzhu/inflection/"), "github.com/jinzhu/inflection")
+}
@@ -0,0 +1,21 @@
+package policy
+
+import (
+	"errors"
+	"time"
+)
+
+// Config points to the policy file, which is reloaded whenever it changes
+type Config struct {
+	// File is the YAML policy file, no file serves every module
+	File string `yaml:"file" env:"POLICY_FILE"`
+	// ReloadInterval is how often File is checked for changes, 0 disables reloading
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"POLICY_RELOAD_INTERVAL"`
+}
+
+func (cfg Config) Validate() error {
+	if cfg.ReloadInterval < 0 {
+		return errors.New("policy reload interval has to be zero or a positive duration")
+	}
+	return nil
+}
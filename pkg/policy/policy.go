@@ -0,0 +1,237 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"go.uber.org/zap"
+	"golang.org/x/mod/module"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Action is what happens to the requests a rule matches
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Artifact names, as used in the artifacts of a rule
+const (
+	ArtifactList   = "list"
+	ArtifactLatest = "latest"
+	ArtifactInfo   = "info"
+	ArtifactMod    = "mod"
+	ArtifactZip    = "zip"
+)
+
+var artifacts = map[string]bool{ArtifactList: true, ArtifactLatest: true, ArtifactInfo: true, ArtifactMod: true, ArtifactZip: true}
+
+// Rule matches requests by module path, version and artifact.
+// Empty fields match everything.
+type Rule struct {
+	// Path lists GOPRIVATE style glob patterns of module paths, separated by commas
+	Path string `yaml:"path"`
+	// Versions is a semver range like ">= 1.2.0, < 1.2.5".
+	// Pre-releases and pseudo-versions are only in ranges that contain a pre-release, like ">= 1.2.0-0".
+	Versions string `yaml:"versions"`
+	// Artifacts lists list, latest, info, mod and zip
+	Artifacts []string `yaml:"artifacts"`
+	Action    Action   `yaml:"action"`
+	// Reason is returned to clients whose request is denied by the rule
+	Reason string `yaml:"reason"`
+
+	versions *semver.Constraints
+}
+
+// Policy is the content of a policy file
+type Policy struct {
+	// Default applies to requests no rule matches, it defaults to allow
+	Default Action `yaml:"default"`
+	// Rules are evaluated in order, the first matching rule decides
+	Rules []Rule `yaml:"rules"`
+}
+
+// Parse reads a policy file
+func Parse(content []byte) (*Policy, error) {
+	p := &Policy{Default: Allow}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err := decoder.Decode(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if p.Default != Allow && p.Default != Deny {
+		return nil, fmt.Errorf("default has to be allow or deny, not %q", p.Default)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Action != Allow && r.Action != Deny {
+			return nil, fmt.Errorf("rule %d: action has to be allow or deny, not %q", i+1, r.Action)
+		}
+		if r.Versions != "" {
+			r.versions, err = semver.NewConstraint(r.Versions)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid versions %q: %w", i+1, r.Versions, err)
+			}
+		}
+		for _, artifact := range r.Artifacts {
+			if !artifacts[artifact] {
+				return nil, fmt.Errorf("rule %d: unknown artifact %q, expected list, latest, info, mod or zip", i+1, artifact)
+			}
+		}
+	}
+	return p, nil
+}
+
+// matches reports whether r applies to artifact of path@version, version is empty for lists and latest versions.
+// Allow rules with versions match requests without version, as some versions of the module are allowed,
+// while deny rules with versions do not, as only some versions of the module are denied.
+func (r *Rule) matches(path, version, artifact string) bool {
+	if r.Path != "" && !module.MatchPrefixPatterns(r.Path, path) {
+		return false
+	}
+	if len(r.Artifacts) > 0 {
+		found := false
+		for _, a := range r.Artifacts {
+			found = found || a == artifact
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.versions == nil {
+		return true
+	}
+	if version == "" {
+		return r.Action == Allow
+	}
+	v, err := semver.NewVersion(version)
+	return err == nil && r.versions.Check(v)
+}
+
+// Decision is the result of evaluating a request
+type Decision struct {
+	Allowed bool
+	// Reason explains why a request was denied
+	Reason string
+}
+
+// Evaluate decides whether artifact of path@version is served, version is empty for lists and latest versions
+func (p *Policy) Evaluate(path, version, artifact string) Decision {
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !r.matches(path, version, artifact) {
+			continue
+		}
+		if r.Action == Allow {
+			return Decision{Allowed: true}
+		}
+		reason := r.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("denied by rule %d", i+1)
+		}
+		return Decision{Reason: reason}
+	}
+	if p.Default == Deny {
+		return Decision{Reason: "not allowed by any rule"}
+	}
+	return Decision{Allowed: true}
+}
+
+// Engine evaluates requests against a policy file, which is reloaded when it changes
+type Engine struct {
+	file string
+
+	lock    sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+	size    int64
+}
+
+// New loads the policy file of cfg, it returns nil if no file is configured
+func New(cfg Config) (*Engine, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+	e := &Engine{file: cfg.File}
+	_, err := e.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload reads the policy file if it changed since it was last read.
+// An invalid file is reported and the previous policy stays in effect.
+func (e *Engine) Reload() (bool, error) {
+	stat, err := os.Stat(e.file)
+	if err != nil {
+		return false, err
+	}
+	e.lock.RLock()
+	unchanged := e.policy != nil && stat.ModTime().Equal(e.modTime) && stat.Size() == e.size
+	e.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(e.file)
+	if err != nil {
+		return false, err
+	}
+	p, err := Parse(content)
+	if err != nil {
+		return false, fmt.Errorf("invalid policy file %s: %w", e.file, err)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.policy, e.modTime, e.size = p, stat.ModTime(), stat.Size()
+	return true, nil
+}
+
+// Evaluate decides whether artifact of path@version is served by the current policy
+func (e *Engine) Evaluate(path, version, artifact string) Decision {
+	e.lock.RLock()
+	p := e.policy
+	e.lock.RUnlock()
+	return p.Evaluate(path, version, artifact)
+}
+
+// ReloadInBackground checks the policy file for changes every interval, until ctx is cancelled.
+// The returned channel is closed once it stopped.
+func (e *Engine) ReloadInBackground(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	if e == nil || interval <= 0 {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			reloaded, err := e.Reload()
+			if err != nil {
+				zap.S().Errorf("Keeping the previous policy: %v", err)
+				continue
+			}
+			if reloaded {
+				zap.S().Infof("Reloaded policy file %s", e.file)
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,161 @@
+package policy
+
+import (
+	"context"
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+rules:
+  - path: github.com/trusted
+    action: allow
+  - path: github.com/evil
+    action: deny
+    reason: known-malicious package
+  - path: github.com/lib/*
+    versions: ">= 1.2.0, < 1.2.5"
+    artifacts: [zip]
+    action: deny
+    reason: CVE-2023-0001
+  - path: example.com/pre
+    versions: ">= 1.0.0-0"
+    action: deny
+`
+
+func TestPolicy_Evaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	assert.NoError(t, err)
+	for _, test := range []struct {
+		path, version, artifact string
+		reason                  string
+	}{
+		{"github.com/evil", "v1.0.0", ArtifactZip, "known-malicious package"},
+		{"github.com/evil/sub", "", ArtifactList, "known-malicious package"},
+		{"github.com/evilcorp", "v1.0.0", ArtifactZip, ""},
+		{"github.com/trusted/evil", "v1.0.0", ArtifactZip, ""},
+		{"github.com/lib/a", "v1.2.3", ArtifactZip, "CVE-2023-0001"},
+		{"github.com/lib/a", "v1.2.3", ArtifactMod, ""},
+		{"github.com/lib/a", "v1.2.5", ArtifactZip, ""},
+		{"github.com/lib/a/v2", "v2.0.0", ArtifactZip, ""},
+		// Denied ranges do not deny the whole module
+		{"github.com/lib/a", "", ArtifactList, ""},
+		// Pre-releases are only in ranges containing a pre-release
+		{"github.com/lib/a", "v1.2.3-rc.1", ArtifactZip, ""},
+		{"example.com/pre", "v1.1.0-0.20230620100000-0123456789ab", ArtifactInfo, "denied by rule 4"},
+		{"example.com/pre", "v0.9.0", ArtifactInfo, ""},
+		{"example.com/other", "v1.0.0", ArtifactZip, ""},
+	} {
+		decision := p.Evaluate(test.path, test.version, test.artifact)
+		assert.Equal(t, decision.Allowed, test.reason == "")
+		assert.Equal(t, decision.Reason, test.reason)
+	}
+}
+
+func TestPolicy_DefaultDeny(t *testing.T) {
+	p, err := Parse([]byte(`
+default: deny
+rules:
+  - path: example.com/approved
+    versions: "^1.0.0"
+    action: allow
+`))
+	assert.NoError(t, err)
+	assert.True(t, p.Evaluate("example.com/approved", "v1.4.0", ArtifactZip).Allowed)
+	// Lists of partly allowed modules are served, so the allowed versions can be found
+	assert.True(t, p.Evaluate("example.com/approved", "", ArtifactList).Allowed)
+	assert.Equal(t, p.Evaluate("example.com/approved", "v2.0.0", ArtifactZip).Reason, "not allowed by any rule")
+	assert.False(t, p.Evaluate("example.com/other", "", ArtifactList).Allowed)
+}
+
+func TestParse_Errors(t *testing.T) {
+	for content, expected := range map[string]string{
+		"default: maybe\n":                                    "default has to be allow or deny",
+		"rules:\n  - path: a.com\n":                           "rule 1: action has to be allow or deny",
+		"rules:\n  - action: deny\n    versions: '>> 1'\n":    "rule 1: invalid versions",
+		"rules:\n  - action: deny\n    artifacts: [source]\n": "rule 1: unknown artifact",
+		"rules:\n  - action: deny\n    module: example.com\n": "field module not found",
+	} {
+		_, err := Parse([]byte(content))
+		assert.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), expected))
+	}
+}
+
+func writePolicy(t *testing.T, path, content string, modTime time.Time) {
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	// The modification time is set explicitly, as file systems may not tell writes within the same second apart
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestEngine_Reload(t *testing.T) {
+	logger.InitLogger()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	start := time.Now().Add(-time.Hour)
+	writePolicy(t, path, "rules:\n  - path: example.com/a\n    action: deny\n", start)
+
+	e, err := New(Config{File: path})
+	assert.NoError(t, err)
+	assert.False(t, e.Evaluate("example.com/a", "v1.0.0", ArtifactZip).Allowed)
+
+	reloaded, err := e.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writePolicy(t, path, "rules:\n  - path: example.com/b\n    action: deny\n", start.Add(time.Minute))
+	reloaded, err = e.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.True(t, e.Evaluate("example.com/a", "v1.0.0", ArtifactZip).Allowed)
+	assert.False(t, e.Evaluate("example.com/b", "v1.0.0", ArtifactZip).Allowed)
+
+	// An invalid file keeps the previous policy
+	writePolicy(t, path, "rules: [", start.Add(2*time.Minute))
+	_, err = e.Reload()
+	assert.Error(t, err)
+	assert.False(t, e.Evaluate("example.com/b", "v1.0.0", ArtifactZip).Allowed)
+}
+
+func TestEngine_ReloadInBackground(t *testing.T) {
+	logger.InitLogger()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	start := time.Now().Add(-time.Hour)
+	writePolicy(t, path, "", start)
+	e, err := New(Config{File: path})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := e.ReloadInBackground(ctx, 10*time.Millisecond)
+	writePolicy(t, path, "default: deny\n", start.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for e.Evaluate("example.com/a", "v1.0.0", ArtifactZip).Allowed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, e.Evaluate("example.com/a", "v1.0.0", ArtifactZip).Allowed)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reloading did not stop")
+	}
+
+	// Without a file nothing is loaded
+	e, err = New(Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, e)
+	<-e.ReloadInBackground(context.Background(), time.Second)
+}
+
+func TestParse_Sample(t *testing.T) {
+	content, err := os.ReadFile("../../policy.sample.yaml")
+	assert.NoError(t, err)
+	p, err := Parse(content)
+	assert.NoError(t, err)
+	assert.Equal(t, p.Evaluate("github.com/example/lib", "v1.2.3", ArtifactInfo).Reason, "retracted, use v1.2.5 or later")
+}
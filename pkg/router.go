@@ -3,17 +3,42 @@ package main
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"goFastCache/pkg/auth"
+	"goFastCache/pkg/index"
+	"goFastCache/pkg/modpath"
 	"goFastCache/pkg/routes"
 	"regexp"
+	"strings"
+	"time"
 )
 
-func registerRoutes(router *gin.Engine) {
-	router.GET("/*TRAIL", Router)
+func registerRoutes(router *gin.Engine, raw map[string]gin.HandlerFunc) {
+	router.GET("/*TRAIL", func(c *gin.Context) {
+		Router(c, raw)
+	})
 }
 
-var uriRegex = regexp.MustCompile(`^/?([A-Za-z0-9_.\-~/]+)`)
-var uriType = regexp.MustCompile(`(/@v/(.*).(zip|mod|info|list))$|(@latest)$`)
+// rawRoutes returns the handlers of paths that are no module paths, as gin does not allow other routes next to /*TRAIL.
+// Their first path element has no dot, so they never collide with a module path.
+func rawRoutes(readinessTimeout time.Duration) map[string]gin.HandlerFunc {
+	return map[string]gin.HandlerFunc{
+		"/healthz": healthz,
+		"/readyz":  readyz(readinessTimeout),
+		"/index/status": func(c *gin.Context) {
+			c.JSON(200, index.GetStatus().Redact(func(modulePath string) bool {
+				return auth.Allows(c, modulePath)
+			}))
+		},
+		"/licenses":        routes.HandleLicenses,
+		"/vulnerabilities": routes.HandleVulnerabilities,
+		"/metrics":         gin.WrapH(promhttp.Handler()),
+	}
+}
+
+var uriRegex = regexp.MustCompile(`^/?([A-Za-z0-9_.\-~/!]+)`)
+var uriType = regexp.MustCompile(`/@v/(list)$|/@v/(.+)\.(zip|mod|info)$|(@latest)$`)
 
 type Type int
 
@@ -25,15 +50,22 @@ const (
 	MOD
 	ZIP
 	SUMDB
+	VULNDB
 )
 
 func getURIParts(rawUrl string) (uri string, version string, t Type, err error) {
 	// Check if url starts with /sumdb/
-	if rawUrl[:7] == "/sumdb/" {
+	if strings.HasPrefix(rawUrl, "/sumdb/") {
 		t = SUMDB
 		uri = rawUrl[7:]
 		return
 	}
+	// The vulnerability database mirror is served below /vuln/
+	if strings.HasPrefix(rawUrl, "/vuln/") {
+		t = VULNDB
+		uri = rawUrl[6:]
+		return
+	}
 
 	// This is used to get the uri from the rawUrl
 	matchesUri := uriRegex.FindStringSubmatch(rawUrl)
@@ -44,7 +76,8 @@ func getURIParts(rawUrl string) (uri string, version string, t Type, err error)
 	uri = matchesUri[1]
 
 	// This is used to get the type from the rawUrl
-	// For zip, mod, info & list group 2 has the version and group 3 has the extension
+	// For list group 1 is set
+	// For zip, mod & info group 2 has the version and group 3 has the extension
 	// For latest group 4 is set
 
 	matchesType := uriType.FindStringSubmatch(rawUrl)
@@ -52,10 +85,20 @@ func getURIParts(rawUrl string) (uri string, version string, t Type, err error)
 		t = RAW
 		return
 	}
+
+	// Module paths and versions are case-encoded in urls, internally only the decoded form is used
+	uri, err = modpath.Unescape(strings.TrimSuffix(uri, "/"))
+	if err != nil {
+		return
+	}
 	if matchesType[4] != "" {
 		t = LATEST
 		return
 	}
+	if matchesType[1] != "" {
+		t = LIST
+		return
+	}
 	switch matchesType[3] {
 	case "zip":
 		t = ZIP
@@ -63,25 +106,42 @@ func getURIParts(rawUrl string) (uri string, version string, t Type, err error)
 		t = MOD
 	case "info":
 		t = INFO
-	case "list":
-		t = LIST
 	default:
 		err = errors.New("invalid path")
 		return
 	}
-	version = matchesType[2]
+	version, err = modpath.Unescape(matchesType[2])
 	return
 }
 
-func Router(c *gin.Context) {
+// requestModulePath returns the module path a request is for, or "" if it is for no module
+func requestModulePath(c *gin.Context) string {
+	uri, _, t, err := getURIParts(c.Param("TRAIL"))
+	if err != nil || t == RAW || t == SUMDB || t == VULNDB {
+		return ""
+	}
+	return uri
+}
+
+// Router serves module paths, and raw for the paths that are no module paths
+func Router(c *gin.Context, raw map[string]gin.HandlerFunc) {
+	start := time.Now()
 	trail := c.Param("TRAIL")
 	uri, version, t, err := getURIParts(trail)
 	if err != nil {
+		c.String(400, err.Error())
 		return
 	}
+	defer func() {
+		observeRequest(t, start, c.Writer.Status(), c.Writer.Size())
+	}()
 	zap.S().Debugf("URI: %s, Version: %s, Type: %d", uri, version, t)
 	switch t {
 	case RAW:
+		if handler, found := raw[trail]; found {
+			handler(c)
+			return
+		}
 		// TODO: Handle raw
 	case LIST:
 		routes.HandleList(c, uri)
@@ -95,6 +155,8 @@ func Router(c *gin.Context) {
 		routes.HandleZip(c, uri, version)
 	case SUMDB:
 		routes.HandleSumdb(c, uri)
+	case VULNDB:
+		routes.HandleVulnDB(c, uri)
 
 	}
 }
@@ -1,8 +1,41 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/united-manufacturing-hub/expiremap/pkg/expiremap"
 	"github.com/zeebo/assert"
+	"goFastCache/pkg/auth"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/cache"
+	"goFastCache/pkg/checksum"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/license"
+	"goFastCache/pkg/policy"
+	"goFastCache/pkg/routes"
+	"goFastCache/pkg/upstream"
+	"goFastCache/pkg/vcs"
+	"goFastCache/pkg/vulndb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var testCasesRaw = []string{
@@ -1682,30 +1715,78 @@ var testCasesCurated = []struct {
 		"github.com/ab180/grpc-cloudmap-resolver",
 		"",
 	},
+	{
+		"/github.com/jinzhu/inflection/@v/list",
+		LIST,
+		"github.com/jinzhu/inflection",
+		"",
+	},
+	{
+		"/github.com/jinzhu/inflection/@latest",
+		LATEST,
+		"github.com/jinzhu/inflection",
+		"",
+	},
+	{
+		"/github.com/jinzhu/inflection/@v/v1.0.0.info",
+		INFO,
+		"github.com/jinzhu/inflection",
+		"v1.0.0",
+	},
+	{
+		"/github.com/jinzhu/inflection/@v/v1.0.0.mod",
+		MOD,
+		"github.com/jinzhu/inflection",
+		"v1.0.0",
+	},
 	{
 		"/github.com/jinzhu/inflection/@v/v1.0.0.zip",
 		ZIP,
-		"github.com/jinzhu/inflection/",
+		"github.com/jinzhu/inflection",
 		"v1.0.0",
 	},
 	{
 		"/gopkg.in/check.v1/@v/v1.0.0-20180628173108-788fd7840127.mod",
 		MOD,
-		"gopkg.in/check.v1/",
+		"gopkg.in/check.v1",
 		"v1.0.0-20180628173108-788fd7840127",
 	},
 	{
 		"/gopkg.in/ini.v1/@v/v1.67.0.mod",
 		MOD,
-		"gopkg.in/ini.v1/",
+		"gopkg.in/ini.v1",
 		"v1.67.0",
 	},
+	{
+		"/github.com/!masterminds/semver/v3/@v/v3.2.1.info",
+		INFO,
+		"github.com/Masterminds/semver/v3",
+		"v3.2.1",
+	},
+	{
+		"/github.com/!one!of!one/xxhash/@v/list",
+		LIST,
+		"github.com/OneOfOne/xxhash",
+		"",
+	},
+	{
+		"/github.com/!azure/go-autorest/@v/v1.0.0-!r!c1.zip",
+		ZIP,
+		"github.com/Azure/go-autorest",
+		"v1.0.0-RC1",
+	},
 	{
 		"/sumdb/sum.golang.org/supported",
 		SUMDB,
 		"sum.golang.org/supported",
 		"",
 	},
+	{
+		"/vuln/ID/GO-2022-0001.json.gz",
+		VULNDB,
+		"ID/GO-2022-0001.json.gz",
+		"",
+	},
 }
 
 func Test_GetURIParts(t *testing.T) {
@@ -1721,6 +1802,19 @@ func Test_GetURIParts(t *testing.T) {
 	}
 }
 
+func Test_GetURIParts_InvalidEscaping(t *testing.T) {
+	for _, rawUrl := range []string{
+		"/github.com/Masterminds/semver/@v/list",
+		"/github.com/!/semver/@v/list",
+		"/github.com/masterminds/semver/@v/v1.0.0-RC1.info",
+	} {
+		t.Run(rawUrl, func(t *testing.T) {
+			_, _, _, err := getURIParts(rawUrl)
+			assert.Error(t, err)
+		})
+	}
+}
+
 func Test_GetURIParts_Raw(t *testing.T) {
 	for _, tc := range testCasesRaw {
 		t.Run(tc, func(t *testing.T) {
@@ -1732,3 +1826,1090 @@ func Test_GetURIParts_Raw(t *testing.T) {
 		})
 	}
 }
+
+// artifactByType maps every type returned by getURIParts to the artifact it is stored as.
+// RAW, SUMDB and VULNDB requests are not stored per module version.
+var artifactByType = map[Type]routes.Artifact{
+	LIST:   routes.ArtifactList,
+	LATEST: routes.ArtifactLatest,
+	INFO:   routes.ArtifactInfo,
+	MOD:    routes.ArtifactMod,
+	ZIP:    routes.ArtifactZip,
+}
+
+var extensionByType = map[Type]string{
+	LIST:   ".list",
+	LATEST: ".latest",
+	INFO:   ".info",
+	MOD:    ".mod",
+	ZIP:    ".zip",
+}
+
+func Test_GetCacheKey(t *testing.T) {
+	seen := make(map[string]string)
+	for _, tc := range testCasesCurated {
+		t.Run(tc.rawUrl, func(t *testing.T) {
+			uri, version, tX, err := getURIParts(tc.rawUrl)
+			assert.Nil(t, err)
+			artifact, cached := artifactByType[tX]
+			if !cached {
+				return
+			}
+			key := routes.GetCacheKey(artifact, uri, version)
+			assert.True(t, strings.HasSuffix(key, extensionByType[tX]))
+			if other, found := seen[key]; found {
+				t.Fatalf("%s and %s share the storage key %s", other, tc.rawUrl, key)
+			}
+			seen[key] = tc.rawUrl
+		})
+	}
+}
+
+func Test_GetCacheKey_VersionsDiffer(t *testing.T) {
+	uri := "github.com/jinzhu/inflection"
+	for _, artifact := range []routes.Artifact{routes.ArtifactInfo, routes.ArtifactMod, routes.ArtifactZip} {
+		assert.True(t, routes.GetCacheKey(artifact, uri, "v1.0.0") != routes.GetCacheKey(artifact, uri, "v1.0.1"))
+	}
+}
+
+func Test_GetX_ArtifactsDoNotCollide(t *testing.T) {
+	memcache := expiremap.NewEx[string, []byte](time.Minute, time.Minute)
+	uri := "github.com/jinzhu/inflection"
+	version := "v1.0.0"
+	// Every artifact has its cache key as content, zips have to be valid to be stored
+	contents := make(map[routes.Artifact]string)
+	for _, artifact := range []routes.Artifact{routes.ArtifactInfo, routes.ArtifactMod} {
+		contents[artifact] = routes.GetCacheKey(artifact, uri, version)
+	}
+	contents[routes.ArtifactZip] = string(newModuleZip(t, uri, version, map[string]string{
+		"key": routes.GetCacheKey(routes.ArtifactZip, uri, version),
+	}))
+	upstreamHandler := func(artifact routes.Artifact) func(uri, version string) ([]byte, error, int) {
+		return func(uri, version string) ([]byte, error, int) {
+			return []byte(contents[artifact]), nil, 200
+		}
+	}
+
+	for _, tX := range []Type{ZIP, MOD, INFO} {
+		artifact := artifactByType[tX]
+		body, err, status := routes.GetX(artifact, uri, version, upstreamHandler(artifact), memcache, nil, nil, nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, string(body), contents[artifact])
+	}
+
+	// Everything is cached now, a second lookup must return the matching artifact without calling upstream
+	for _, tX := range []Type{INFO, MOD, ZIP} {
+		artifact := artifactByType[tX]
+		body, err, status := routes.GetX(artifact, uri, version, func(uri, version string) ([]byte, error, int) {
+			t.Fatalf("upstream called for cached %s", extensionByType[tX])
+			return nil, nil, 0
+		}, memcache, nil, nil, nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, string(body), contents[artifact])
+	}
+}
+
+// fakeUpstream serves files by path and counts how often each one was requested
+type fakeUpstream struct {
+	lock     sync.Mutex
+	files    map[string]string
+	requests map[string]int
+	// delay is applied to every response, to let concurrent requests pile up
+	delay time.Duration
+}
+
+func newFakeUpstream(t *testing.T, files map[string]string) *fakeUpstream {
+	f := &fakeUpstream{
+		files:    files,
+		requests: make(map[string]int),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filePath := path.Clean(r.URL.Path)
+		f.lock.Lock()
+		f.requests[filePath]++
+		delay := f.delay
+		f.lock.Unlock()
+		time.Sleep(delay)
+		file, found := f.files[filePath]
+		if !found {
+			http.Error(w, "not found: "+filePath, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(file))
+	}))
+	t.Cleanup(server.Close)
+
+	previous := upstream.GetGOPROXY()
+	err := upstream.SetGOPROXY(server.URL)
+	if err != nil {
+		t.Fatalf("Error setting GOPROXY: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = upstream.SetGOPROXY(previous)
+	})
+	return f
+}
+
+func (f *fakeUpstream) count(filePath string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests[filePath]
+}
+
+// testRouterConfig is the router configuration of the tests, without client authentication
+var testRouterConfig = routerConfig{ReadinessTimeout: 2 * time.Second}
+
+func newTestRouter() (*gin.Engine, *blobstorage.Memory) {
+	return newTestRouterWith(testRouterConfig)
+}
+
+func newTestRouterWith(cfg routerConfig) (*gin.Engine, *blobstorage.Memory) {
+	gin.SetMode(gin.TestMode)
+	blob := blobstorage.NewMemory()
+	return newRouter(blob, cache.NewMemory(), database.NewMemory(), cfg), blob
+}
+
+func get(router *gin.Engine, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+	return recorder
+}
+
+func Test_Router_EndToEnd(t *testing.T) {
+	// The in-process caches of routes and upstream are global, so every run needs its own module
+	module := fmt.Sprintf("example.com/endtoend%d", time.Now().UnixNano())
+	files := map[string]string{
+		"/" + module + "/@v/list":        "v1.0.0\nv1.1.0\n",
+		"/" + module + "/@latest":        `{"Version":"v1.1.0","Time":"2023-06-20T00:00:00Z"}`,
+		"/" + module + "/@v/v1.1.0.info": `{"Version":"v1.1.0","Time":"2023-06-20T00:00:00Z"}`,
+		"/" + module + "/@v/v1.1.0.mod":  "module " + module + "\n",
+		"/" + module + "/@v/v1.1.0.zip": string(newModuleZip(t, module, "v1.1.0", map[string]string{
+			"go.mod":  "module " + module + "\n",
+			"main.go": strings.Repeat("zip", 1024),
+		})),
+	}
+	fake := newFakeUpstream(t, files)
+	router, blob := newTestRouter()
+
+	for i := 0; i < 2; i++ {
+		for filePath, file := range files {
+			recorder := get(router, filePath)
+			assert.Equal(t, recorder.Code, 200)
+			assert.Equal(t, recorder.Body.String(), file)
+		}
+	}
+
+	// Every artifact is fetched once, and served from the caches afterwards
+	for filePath := range files {
+		assert.Equal(t, fake.count(filePath), 1)
+	}
+
+	// Immutable artifacts are kept in blob storage
+	zip, found := blob.Get(routes.GetCacheKey(routes.ArtifactZip, module, "v1.1.0"))
+	assert.True(t, found)
+	assert.Equal(t, string(zip), files["/"+module+"/@v/v1.1.0.zip"])
+}
+
+func Test_Router_EndToEnd_NotFound(t *testing.T) {
+	newFakeUpstream(t, map[string]string{})
+	router, blob := newTestRouter()
+
+	recorder := get(router, "/example.com/missing/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 404)
+	assert.True(t, strings.Contains(recorder.Body.String(), "not found"))
+
+	objects, err := blob.List("")
+	assert.NoError(t, err)
+	assert.Equal(t, len(objects), 0)
+}
+
+func Test_Router_NegativeCache(t *testing.T) {
+	fake := newFakeUpstream(t, map[string]string{})
+	previous := routes.NegativeCache
+	routes.NegativeCache = cache.NewMemory()
+	t.Cleanup(func() {
+		routes.NegativeCache = previous
+	})
+	router, _ := newTestRouter()
+
+	module := fmt.Sprintf("example.com/negative%d", time.Now().UnixNano())
+	for _, artifact := range []string{"/@v/v1.0.0.info", "/@v/v1.0.0.zip"} {
+		recorder := get(router, "/"+module+artifact)
+		assert.Equal(t, recorder.Code, 404)
+		message := recorder.Body.String()
+		assert.True(t, strings.Contains(message, "not found"))
+
+		recorder = get(router, "/"+module+artifact)
+		assert.Equal(t, recorder.Code, 404)
+		assert.Equal(t, recorder.Body.String(), message)
+		assert.Equal(t, fake.count("/"+module+artifact), 1)
+	}
+
+	// Other versions of the same module are still asked for
+	recorder := get(router, "/"+module+"/@v/v1.0.1.info")
+	assert.Equal(t, recorder.Code, 404)
+	assert.Equal(t, fake.count("/"+module+"/@v/v1.0.1.info"), 1)
+}
+
+func Test_Router_Offline(t *testing.T) {
+	module := fmt.Sprintf("example.com/offline%d", time.Now().UnixNano())
+	files := map[string]string{
+		"/" + module + "/@v/list":        "v1.0.0\nv1.1.0\nv1.2.0\n",
+		"/" + module + "/@latest":        `{"Version":"v1.2.0","Time":"2023-06-22T00:00:00Z"}`,
+		"/" + module + "/@v/v1.0.0.info": `{"Version":"v1.0.0","Time":"2023-06-20T00:00:00Z"}`,
+		"/" + module + "/@v/v1.1.0.info": `{"Version":"v1.1.0","Time":"2023-06-21T00:00:00Z"}`,
+		"/" + module + "/@v/v1.0.0.zip":  string(newModuleZip(t, module, "v1.0.0", map[string]string{"a.go": "package a\n"})),
+	}
+	newFakeUpstream(t, files)
+	router, _ := newTestRouter()
+	for _, filePath := range []string{"/@v/v1.0.0.info", "/@v/v1.1.0.info", "/@v/v1.0.0.zip"} {
+		recorder := get(router, "/"+module+filePath)
+		assert.Equal(t, recorder.Code, 200)
+	}
+
+	upstream.SetOffline(true)
+	t.Cleanup(func() {
+		upstream.SetOffline(false)
+	})
+
+	recorder := get(router, "/"+module+"/@v/list")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "v1.0.0\nv1.1.0\n")
+	recorder = get(router, "/"+module+"/@latest")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), files["/"+module+"/@v/v1.1.0.info"])
+	recorder = get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), files["/"+module+"/@v/v1.0.0.zip"])
+
+	// Versions that were never stored cannot be served
+	recorder = get(router, "/"+module+"/@v/v1.2.0.info")
+	assert.Equal(t, recorder.Code, 503)
+	recorder = get(router, "/example.com/neverstored/@v/list")
+	assert.Equal(t, recorder.Code, 503)
+}
+
+func Test_Router_UpstreamDown(t *testing.T) {
+	module := fmt.Sprintf("example.com/down%d", time.Now().UnixNano())
+	newFakeUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.info": `{"Version":"v1.0.0","Time":"2023-06-20T00:00:00Z"}`,
+	})
+	router, _ := newTestRouter()
+	recorder := get(router, "/"+module+"/@v/v1.0.0.info")
+	assert.Equal(t, recorder.Code, 200)
+
+	previous := upstream.GetGOPROXY()
+	assert.NoError(t, upstream.SetGOPROXY("http://127.0.0.1:1"))
+	t.Cleanup(func() {
+		_ = upstream.SetGOPROXY(previous)
+	})
+
+	recorder = get(router, "/"+module+"/@v/list")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "v1.0.0\n")
+	recorder = get(router, "/"+module+"/@latest")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), `{"Version":"v1.0.0","Time":"2023-06-20T00:00:00Z"}`)
+}
+
+// newModuleZip returns a module zip of module@version with the given files
+func newModuleZip(t *testing.T, module, version string, files map[string]string) []byte {
+	entries := make([][2]string, 0, len(files))
+	for name, content := range files {
+		entries = append(entries, [2]string{module + "@" + version + "/" + name, content})
+	}
+	return newZip(t, entries...)
+}
+
+// newZip returns a zip with the given name and content pairs, names are used as they are
+func newZip(t *testing.T, entries ...[2]string) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, entry := range entries {
+		file, err := writer.Create(entry[0])
+		assert.NoError(t, err)
+		_, err = file.Write([]byte(entry[1]))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func Test_Router_Catalogue(t *testing.T) {
+	module := fmt.Sprintf("example.com/catalogue%d", time.Now().UnixNano())
+	zipFile := newModuleZip(t, module, "v1.1.0", map[string]string{
+		"go.mod":  "module " + module + "\n",
+		"main.go": "package main\n",
+	})
+	files := map[string]string{
+		"/" + module + "/@v/v1.0.0.info": `{"Version":"v1.0.0","Time":"2023-06-20T00:00:00Z"}`,
+		"/" + module + "/@v/v1.1.0.info": `{"Version":"v1.1.0","Time":"2023-06-21T00:00:00Z"}`,
+		"/" + module + "/@v/v1.1.0.mod":  "module " + module + "\n",
+		"/" + module + "/@v/v1.1.0.zip":  string(zipFile),
+	}
+	newFakeUpstream(t, files)
+	gin.SetMode(gin.TestMode)
+	db := database.NewMemory()
+	router := newRouter(blobstorage.NewMemory(), cache.NewMemory(), db, testRouterConfig)
+
+	for i := 0; i < 2; i++ {
+		for filePath := range files {
+			recorder := get(router, filePath)
+			assert.Equal(t, recorder.Code, 200)
+		}
+	}
+
+	versions, err := db.ListGoModuleVersions(module)
+	assert.NoError(t, err)
+	assert.Equal(t, len(versions), 2)
+	assert.Equal(t, versions[0].Version, "v1.0.0")
+	assert.Equal(t, versions[0].FetchCount, int64(2))
+	assert.True(t, versions[0].OriginTime.Equal(time.Date(2023, 6, 20, 0, 0, 0, 0, time.UTC)))
+
+	latest := versions[1]
+	assert.Equal(t, latest.Version, "v1.1.0")
+	assert.Equal(t, latest.FetchCount, int64(6))
+	assert.False(t, latest.FirstFetched.IsZero())
+	assert.False(t, latest.LastAccessed.IsZero())
+	assert.Equal(t, latest.ModSize, int64(len(files["/"+module+"/@v/v1.1.0.mod"])))
+	assert.Equal(t, latest.ZipSize, int64(len(zipFile)))
+	zipSha256 := sha256.Sum256(zipFile)
+	assert.Equal(t, latest.ZipSha256, hex.EncodeToString(zipSha256[:]))
+
+	zipPath := filepath.Join(t.TempDir(), "module.zip")
+	assert.NoError(t, os.WriteFile(zipPath, zipFile, 0o600))
+	zipHash, err := dirhash.HashZip(zipPath, dirhash.Hash1)
+	assert.NoError(t, err)
+	assert.Equal(t, latest.ZipHash, zipHash)
+
+	gomodule, found, err := db.GetGoModuleByPath(module)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, gomodule.Version, "v1.1.0")
+}
+
+func Test_Router_ChecksumDB(t *testing.T) {
+	module := fmt.Sprintf("example.com/verified%d", time.Now().UnixNano())
+	tampered := fmt.Sprintf("example.com/tampered%d", time.Now().UnixNano())
+	mod := "module " + module + "\n"
+	zipFile := newModuleZip(t, module, "v1.0.0", map[string]string{"go.mod": mod})
+	tamperedZip := newModuleZip(t, tampered, "v1.0.0", map[string]string{"go.mod": "module " + tampered + "\n"})
+	newFakeUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.mod":   mod,
+		"/" + module + "/@v/v1.0.0.zip":   string(zipFile),
+		"/" + tampered + "/@v/v1.0.0.mod": "module " + tampered + "\n// tampered\n",
+		"/" + tampered + "/@v/v1.0.0.zip": string(tamperedZip),
+	})
+
+	// The checksum database knows the original go.mod and a different zip of the tampered module
+	zipPath := filepath.Join(t.TempDir(), "module.zip")
+	assert.NoError(t, os.WriteFile(zipPath, zipFile, 0o600))
+	zipHash, err := dirhash.HashZip(zipPath, dirhash.Hash1)
+	assert.NoError(t, err)
+	modHash, err := checksum.HashMod([]byte(mod))
+	assert.NoError(t, err)
+	tamperedModHash, err := checksum.HashMod([]byte("module " + tampered + "\n"))
+	assert.NoError(t, err)
+	name := fmt.Sprintf("sum%d.example.com", time.Now().UnixNano())
+	key, handler, err := checksum.NewTestDatabase(name, func(path, version string) ([]byte, error) {
+		switch path {
+		case module:
+			return []byte(fmt.Sprintf("%s %s %s\n%s %s/go.mod %s\n", path, version, zipHash, path, version, modHash)), nil
+		case tampered:
+			return []byte(fmt.Sprintf("%s %s %s\n%s %s/go.mod %s\n", path, version, zipHash, path, version, tamperedModHash)), nil
+		}
+		return nil, fmt.Errorf("%s not found", path)
+	})
+	assert.NoError(t, err)
+	sumdbServer := httptest.NewServer(handler)
+	t.Cleanup(sumdbServer.Close)
+	previous := upstream.GetSumDB()
+	assert.NoError(t, upstream.SetSumDB(name+"="+sumdbServer.URL))
+	t.Cleanup(func() {
+		_ = upstream.SetSumDB(previous)
+		routes.ChecksumDB = nil
+	})
+	router, blob := newTestRouter()
+	assert.NoError(t, routes.ConfigureChecksumDB(key, cache.NewMemory(), blob))
+
+	recorder := get(router, "/"+module+"/@v/v1.0.0.mod")
+	assert.Equal(t, recorder.Code, 200)
+	recorder = get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), string(zipFile))
+	_, found := blob.Get(routes.GetCacheKey(routes.ArtifactZip, module, "v1.0.0"))
+	assert.True(t, found)
+
+	for _, artifact := range []routes.Artifact{routes.ArtifactMod, routes.ArtifactZip} {
+		extension := map[routes.Artifact]string{routes.ArtifactMod: ".mod", routes.ArtifactZip: ".zip"}[artifact]
+		recorder = get(router, "/"+tampered+"/@v/v1.0.0"+extension)
+		assert.Equal(t, recorder.Code, 502)
+		assert.True(t, strings.Contains(recorder.Body.String(), "checksum mismatch"))
+		_, found = blob.Get(routes.GetCacheKey(artifact, tampered, "v1.0.0"))
+		assert.False(t, found)
+	}
+
+	// Modules the checksum database does not know are refused too
+	unknown := fmt.Sprintf("example.com/unknown%d", time.Now().UnixNano())
+	fake := newFakeUpstream(t, map[string]string{"/" + unknown + "/@v/v1.0.0.zip": string(zipFile)})
+	recorder = get(router, "/"+unknown+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 502)
+	assert.Equal(t, fake.count("/"+unknown+"/@v/v1.0.0.zip"), 1)
+}
+
+func Test_Router_ZipValidation(t *testing.T) {
+	module := fmt.Sprintf("example.com/validation%d", time.Now().UnixNano())
+	mod := "module " + module + "\n"
+	// Every case is a module of its own, named after the case
+	prefix := func(name string) string {
+		return module + "/" + name + "@v1.0.0/"
+	}
+	cases := map[string][]byte{
+		"prefix":    newZip(t, [2]string{"other.com/a@v1.0.0/a.go", "package a\n"}),
+		"duplicate": newZip(t, [2]string{prefix("duplicate") + "a.go", "package a\n"}, [2]string{prefix("duplicate") + "a.go", "package b\n"}),
+		"case":      newZip(t, [2]string{prefix("case") + "README", "a"}, [2]string{prefix("case") + "readme", "b"}),
+		"gomod":     newZip(t, [2]string{prefix("gomod") + "go.mod", "module " + module + "/gomod\n\nrequire example.com/other v1.0.0\n"}),
+		"module":    newZip(t, [2]string{prefix("module") + "go.mod", "module example.com/other\n"}),
+		"zip":       []byte("no zip"),
+	}
+	files := map[string]string{
+		"/" + module + "/@v/v1.0.0.mod": mod,
+		"/" + module + "/@v/v1.0.0.zip": string(newModuleZip(t, module, "v1.0.0", map[string]string{"go.mod": mod, "a.go": "package a\n"})),
+	}
+	for name, zipFile := range cases {
+		files["/"+module+"/"+name+"/@v/v1.0.0.mod"] = "module " + module + "/" + name + "\n"
+		files["/"+module+"/"+name+"/@v/v1.0.0.zip"] = string(zipFile)
+	}
+	newFakeUpstream(t, files)
+	router, blob := newTestRouter()
+
+	recorder := get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 200)
+
+	for name, zipFile := range cases {
+		recorder = get(router, "/"+module+"/"+name+"/@v/v1.0.0.zip")
+		assert.Equal(t, recorder.Code, 502)
+		assert.True(t, strings.Contains(recorder.Body.String(), "invalid module zip"))
+
+		cacheKey := routes.GetCacheKey(routes.ArtifactZip, module+"/"+name, "v1.0.0")
+		_, found := blob.Get(cacheKey)
+		assert.False(t, found)
+		quarantined, found := blob.Get(routes.QuarantinePrefix + cacheKey)
+		assert.True(t, found)
+		assert.Equal(t, string(quarantined), string(zipFile))
+		reason, found := blob.Get(routes.QuarantinePrefix + cacheKey + ".reason")
+		assert.True(t, found)
+		assert.True(t, strings.HasPrefix(string(reason), module+"/"+name+"@v1.0.0: invalid module zip"))
+	}
+}
+
+func Test_Router_Sumdb(t *testing.T) {
+	files := map[string]string{
+		"/latest":                          "go.sum database tree\n1\n",
+		"/lookup/example.com/sumdb@v1.0.0": "1\nexample.com/sumdb v1.0.0 h1:x\n",
+		"/tile/8/0/000":                    "tile",
+		"/tile/8/0/x001/234.p/5":           "partial tile",
+	}
+	fake := newFakeUpstream(t, files)
+	// The in-process caches are global, so every run needs its own checksum database
+	name := fmt.Sprintf("sum%d.example.com", time.Now().UnixNano())
+	previous := upstream.GetSumDB()
+	assert.NoError(t, upstream.SetSumDB(name+"="+upstream.GetGOPROXY()))
+	t.Cleanup(func() {
+		_ = upstream.SetSumDB(previous)
+	})
+	router, blob := newTestRouter()
+
+	recorder := get(router, "/sumdb/"+name+"/supported")
+	assert.Equal(t, recorder.Code, 200)
+	recorder = get(router, "/sumdb/sum.golang.org/supported")
+	assert.Equal(t, recorder.Code, 404)
+
+	for i := 0; i < 2; i++ {
+		for filePath, file := range files {
+			recorder = get(router, "/sumdb/"+name+filePath)
+			assert.Equal(t, recorder.Code, 200)
+			assert.Equal(t, recorder.Body.String(), file)
+		}
+	}
+	for filePath := range files {
+		assert.Equal(t, fake.count(filePath), 1)
+	}
+
+	// Only tiles are kept in blob storage
+	objects, err := blob.List("")
+	assert.NoError(t, err)
+	assert.Equal(t, len(objects), 2)
+
+	// Anything else is never forwarded
+	recorder = get(router, "/sumdb/"+name+"/../../etc/passwd")
+	assert.Equal(t, recorder.Code, 404)
+	recorder = get(router, "/sumdb/"+name+"/tile/8/0/abc")
+	assert.Equal(t, recorder.Code, 404)
+	assert.Equal(t, fake.count("/tile/8/0/abc"), 0)
+}
+
+func Test_Router_CaseEncoding(t *testing.T) {
+	module := fmt.Sprintf("example.com/CaseEncoding%d", time.Now().UnixNano())
+	escaped := strings.Replace(module, "/CaseEncoding", "/!case!encoding", 1)
+	fake := newFakeUpstream(t, map[string]string{
+		"/" + escaped + "/@v/v1.0.0-!r!c1.mod": "module " + module + "\n",
+	})
+	router, blob := newTestRouter()
+
+	recorder := get(router, "/"+escaped+"/@v/v1.0.0-!r!c1.mod")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "module "+module+"\n")
+	assert.Equal(t, fake.count("/"+escaped+"/@v/v1.0.0-!r!c1.mod"), 1)
+
+	// Stored under the decoded path, whatever spelling is used to look it up
+	_, found := blob.Get(routes.GetCacheKey(routes.ArtifactMod, module, "v1.0.0-RC1"))
+	assert.True(t, found)
+	assert.Equal(t, routes.GetCacheKey(routes.ArtifactMod, escaped+"/", "v1.0.0-RC1"), routes.GetCacheKey(routes.ArtifactMod, module, "v1.0.0-RC1"))
+
+	recorder = get(router, "/"+module+"/@v/v1.0.0-RC1.mod")
+	assert.Equal(t, recorder.Code, 400)
+}
+
+func Test_Router_Coalescing(t *testing.T) {
+	module := fmt.Sprintf("example.com/coalescing%d", time.Now().UnixNano())
+	files := map[string]string{
+		"/" + module + "/@v/list":        "v1.0.0\n",
+		"/" + module + "/@v/v1.0.0.info": `{"Version":"v1.0.0","Time":"2023-06-20T00:00:00Z"}`,
+		"/" + module + "/@v/v1.0.0.zip":  string(newModuleZip(t, module, "v1.0.0", map[string]string{"a.go": strings.Repeat("zip", 1024)})),
+	}
+	fake := newFakeUpstream(t, files)
+	fake.delay = 100 * time.Millisecond
+	router, _ := newTestRouter()
+
+	// Missing versions are coalesced as well
+	requests := []string{"/" + module + "/@v/v2.0.0.mod"}
+	for filePath := range files {
+		requests = append(requests, filePath)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		for _, filePath := range requests {
+			wg.Add(1)
+			go func(filePath string) {
+				defer wg.Done()
+				recorder := get(router, filePath)
+				file, found := files[filePath]
+				if !found {
+					assert.Equal(t, recorder.Code, 404)
+					return
+				}
+				assert.Equal(t, recorder.Code, 200)
+				assert.Equal(t, recorder.Body.String(), file)
+			}(filePath)
+		}
+	}
+	wg.Wait()
+
+	// Every waiter got the result of a single fetch, including the missing version
+	for _, filePath := range requests {
+		assert.Equal(t, fake.count(filePath), 1)
+	}
+}
+
+func Test_Router_Metrics(t *testing.T) {
+	module := fmt.Sprintf("example.com/metrics%d", time.Now().UnixNano())
+	mod := "module " + module + "\n"
+	newFakeUpstream(t, map[string]string{"/" + module + "/@v/v1.0.0.mod": mod})
+	router, _ := newTestRouter()
+	servedBefore := testutil.ToFloat64(bytesServed.WithLabelValues("mod"))
+
+	for i := 0; i < 2; i++ {
+		recorder := get(router, "/"+module+"/@v/v1.0.0.mod")
+		assert.Equal(t, recorder.Code, 200)
+	}
+	assert.Equal(t, testutil.ToFloat64(bytesServed.WithLabelValues("mod")), servedBefore+float64(2*len(mod)))
+
+	recorder := get(router, "/metrics")
+	assert.Equal(t, recorder.Code, 200)
+	for _, metric := range []string{
+		`gofastcache_request_duration_seconds_count{status="200",type="mod"}`,
+		`gofastcache_served_bytes_total{type="mod"}`,
+		`gofastcache_cache_lookups_total{tier="miss"}`,
+		`gofastcache_cache_lookups_total{tier="blob"}`,
+		`gofastcache_upstream_request_duration_seconds_count{status="200"}`,
+		`gofastcache_index_queue_depth`,
+		`gofastcache_index_cursor_timestamp_seconds`,
+	} {
+		assert.True(t, strings.Contains(recorder.Body.String(), metric))
+	}
+}
+
+func Test_Router_Health(t *testing.T) {
+	newFakeUpstream(t, map[string]string{})
+	router, _ := newTestRouter()
+
+	recorder := get(router, "/healthz")
+	assert.Equal(t, recorder.Code, 200)
+
+	recorder = get(router, "/readyz")
+	assert.Equal(t, recorder.Code, 200)
+	var readiness Readiness
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &readiness))
+	assert.Equal(t, readiness.Status, "ok")
+	for _, name := range []string{"blob", "cache", "database", "upstream"} {
+		assert.Equal(t, readiness.Checks[name].Status, "ok")
+	}
+
+	// An unreachable upstream makes the proxy unready
+	assert.NoError(t, upstream.SetGOPROXY("http://127.0.0.1:1"))
+	recorder = get(router, "/readyz")
+	assert.Equal(t, recorder.Code, 503)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &readiness))
+	assert.Equal(t, readiness.Status, "unavailable")
+	assert.Equal(t, readiness.Checks["upstream"].Status, "failed")
+	assert.Equal(t, readiness.Checks["blob"].Status, "ok")
+}
+
+func Test_CheckReadiness_Timeout(t *testing.T) {
+	readiness := checkReadiness(context.Background(), 10*time.Millisecond, map[string]func(ctx context.Context) error{
+		"fast": func(ctx context.Context) error {
+			return nil
+		},
+		"hanging": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	assert.Equal(t, readiness.Status, "unavailable")
+	assert.Equal(t, readiness.Checks["fast"].Status, "ok")
+	assert.Equal(t, readiness.Checks["hanging"].Status, "failed")
+	assert.Equal(t, readiness.Checks["hanging"].Error, context.DeadlineExceeded.Error())
+}
+
+func Test_Router_PrivateModule(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	module := fmt.Sprintf("example.com/private%d/repo", time.Now().UnixNano())
+	work, bare := filepath.Join(t.TempDir(), "work"), filepath.Join(t.TempDir(), "repo.git")
+	assert.NoError(t, os.MkdirAll(work, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(work, "go.mod"), []byte("module "+module+"\n"), 0o644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+		{"tag", "v1.0.0"},
+		{"clone", "-q", "--bare", work, bare},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = work
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	fake := newFakeUpstream(t, map[string]string{})
+	assert.NoError(t, upstream.SetPrivate(vcs.Config{
+		Patterns: path.Dir(module),
+		Repos:    module + "=" + bare,
+		CacheDir: t.TempDir(),
+	}))
+
+	// The checksum database knows no module, private modules are not verified against it
+	name := fmt.Sprintf("sum%d.example.com", time.Now().UnixNano())
+	key, handler, err := checksum.NewTestDatabase(name, func(path, version string) ([]byte, error) {
+		return nil, fmt.Errorf("%s not found", path)
+	})
+	assert.NoError(t, err)
+	sumdbServer := httptest.NewServer(handler)
+	t.Cleanup(sumdbServer.Close)
+	previous := upstream.GetSumDB()
+	assert.NoError(t, upstream.SetSumDB(name+"="+sumdbServer.URL))
+	t.Cleanup(func() {
+		_ = upstream.SetSumDB(previous)
+		_ = upstream.SetPrivate(vcs.Config{})
+		routes.ChecksumDB = nil
+	})
+	router, blob := newTestRouter()
+	assert.NoError(t, routes.ConfigureChecksumDB(key, cache.NewMemory(), blob))
+
+	recorder := get(router, "/"+module+"/@v/list")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "v1.0.0\n")
+	recorder = get(router, "/"+module+"/@v/v1.0.0.info")
+	assert.Equal(t, recorder.Code, 200)
+	assert.True(t, strings.Contains(recorder.Body.String(), `"Version":"v1.0.0"`))
+	recorder = get(router, "/"+module+"/@v/v1.0.0.mod")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "module "+module+"\n")
+	recorder = get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 200)
+	_, found := blob.Get(routes.GetCacheKey(routes.ArtifactZip, module, "v1.0.0"))
+	assert.True(t, found)
+	assert.Equal(t, fake.count("/"+module+"/@v/list"), 0)
+
+	// Private modules are not fetched in offline mode either
+	upstream.SetOffline(true)
+	t.Cleanup(func() {
+		upstream.SetOffline(false)
+	})
+	recorder = get(router, "/"+module+"/@v/main.info")
+	assert.Equal(t, recorder.Code, 503)
+}
+
+func Test_Router_Auth(t *testing.T) {
+	module := fmt.Sprintf("example.com/team-a/auth%d", time.Now().UnixNano())
+	denied := fmt.Sprintf("example.com/team-b/auth%d", time.Now().UnixNano())
+	newFakeUpstream(t, map[string]string{
+		"/" + module + "/@v/list": "v1.0.0\n",
+		"/" + denied + "/@v/list": "v1.0.0\n",
+	})
+	clientAuth, err := auth.New(auth.Config{Tokens: "ci=secret", Rules: "ci=example.com/team-a"})
+	assert.NoError(t, err)
+	cfg := testRouterConfig
+	cfg.Auth = clientAuth
+	router, _ := newTestRouterWith(cfg)
+
+	withToken := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	recorder := withToken("/" + module + "/@v/list")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "v1.0.0\n")
+	assert.Equal(t, withToken("/"+denied+"/@v/list").Code, 403)
+	assert.Equal(t, get(router, "/"+module+"/@v/list").Code, 401)
+
+	// Probes and scrapers work without credentials
+	assert.Equal(t, get(router, "/healthz").Code, 200)
+	assert.Equal(t, get(router, "/metrics").Code, 200)
+
+	// Unless metrics are protected
+	cfg.ProtectMetrics = true
+	router, _ = newTestRouterWith(cfg)
+	assert.Equal(t, get(router, "/metrics").Code, 401)
+	assert.Equal(t, withToken("/metrics").Code, 200)
+}
+
+func Test_Router_Policy(t *testing.T) {
+	module := fmt.Sprintf("example.com/policy%d", time.Now().UnixNano())
+	evil := fmt.Sprintf("example.com/evil%d", time.Now().UnixNano())
+	fake := newFakeUpstream(t, map[string]string{
+		"/" + module + "/@v/list":        "v1.0.0\nv1.1.0\nv1.2.0\n",
+		"/" + module + "/@latest":        `{"Version":"v1.2.0","Time":"2023-06-22T00:00:00Z"}`,
+		"/" + module + "/@v/v1.0.0.info": `{"Version":"v1.0.0","Time":"2023-06-20T00:00:00Z"}`,
+		"/" + module + "/@v/v1.1.0.zip":  string(newModuleZip(t, module, "v1.1.0", map[string]string{"a.go": "package a\n"})),
+		"/" + evil + "/@v/list":          "v1.0.0\n",
+	})
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(policyFile, []byte(fmt.Sprintf(`
+rules:
+  - path: %s
+    action: deny
+    reason: known-malicious package
+  - path: %s
+    versions: ">= 1.1.0, < 1.2.0"
+    artifacts: [zip]
+    action: deny
+    reason: compromised release
+  - path: %s
+    versions: ">= 1.2.0"
+    action: deny
+    reason: retracted
+`, evil, module, module)), 0o600))
+	var err error
+	routes.Policy, err = policy.New(policy.Config{File: policyFile})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		routes.Policy = nil
+	})
+	router, _ := newTestRouter()
+
+	recorder := get(router, "/"+evil+"/@v/list")
+	assert.Equal(t, recorder.Code, 403)
+	assert.Equal(t, recorder.Body.String(), evil+" is blocked by policy: known-malicious package")
+	recorder = get(router, "/"+module+"/@v/v1.1.0.zip")
+	assert.Equal(t, recorder.Code, 403)
+	assert.Equal(t, recorder.Body.String(), module+"@v1.1.0 is blocked by policy: compromised release")
+	// Denied requests never reach upstream
+	assert.Equal(t, fake.count("/"+evil+"/@v/list"), 0)
+	assert.Equal(t, fake.count("/"+module+"/@v/v1.1.0.zip"), 0)
+
+	// Versions whose .info is denied are left out of the list, and are never the latest version
+	recorder = get(router, "/"+module+"/@v/list")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), "v1.0.0\nv1.1.0\n")
+	recorder = get(router, "/"+module+"/@latest")
+	assert.Equal(t, recorder.Code, 403)
+	assert.True(t, strings.Contains(recorder.Body.String(), "retracted"))
+	recorder = get(router, "/"+module+"/@v/v1.0.0.info")
+	assert.Equal(t, recorder.Code, 200)
+}
+
+func Test_Router_PolicyDeniesMod(t *testing.T) {
+	module := fmt.Sprintf("example.com/policymod%d", time.Now().UnixNano())
+	goMod := "module " + module + "\n"
+	newFakeUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.mod": goMod,
+		"/" + module + "/@v/v1.0.0.zip": string(newModuleZip(t, module, "v1.0.0", map[string]string{"go.mod": goMod, "a.go": "package a\n"})),
+	})
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(policyFile, []byte(fmt.Sprintf(`
+rules:
+  - path: %s
+    artifacts: [mod]
+    action: deny
+    reason: no go.mod for clients
+`, module)), 0o600))
+	var err error
+	routes.Policy, err = policy.New(policy.Config{File: policyFile})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		routes.Policy = nil
+	})
+	router, _ := newTestRouter()
+
+	// The go.mod of the zip is still checked against the .mod, which clients may not get
+	assert.Equal(t, get(router, "/"+module+"/@v/v1.0.0.mod").Code, 403)
+	recorder := get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, get(router, "/"+module+"/@v/v1.0.0.mod").Code, 403)
+}
+
+const mitLicense = `MIT License
+
+Copyright (c) 2023 Example
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+`
+
+func Test_Router_Licenses(t *testing.T) {
+	module := fmt.Sprintf("example.com/licensed%d", time.Now().UnixNano())
+	fake := newFakeUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.zip": string(newModuleZip(t, module, "v1.0.0", map[string]string{"LICENSE": mitLicense, "a.go": "package a\n"})),
+		"/" + module + "/@v/v1.1.0.zip": string(newModuleZip(t, module, "v1.1.0", map[string]string{"a.go": "package a\n"})),
+	})
+	var err error
+	routes.Licenses, err = license.New(license.Config{Detect: true, Allow: "MIT,Apache-2.0"})
+	assert.NoError(t, err)
+	previous := routes.NegativeCache
+	routes.NegativeCache = cache.NewMemory()
+	t.Cleanup(func() {
+		routes.Licenses = nil
+		routes.NegativeCache = previous
+	})
+	router, blob := newTestRouter()
+
+	recorder := get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 200)
+
+	// Versions without an allowed license are neither stored nor served, and upstream is not asked again
+	for i := 0; i < 2; i++ {
+		recorder = get(router, "/"+module+"/@v/v1.1.0.zip")
+		assert.Equal(t, recorder.Code, 403)
+		assert.Equal(t, recorder.Body.String(), module+"@v1.1.0 is refused: no license found")
+	}
+	_, found, _ := blob.Stat(routes.GetCacheKey(routes.ArtifactZip, module, "v1.1.0"))
+	assert.False(t, found)
+	assert.Equal(t, fake.count("/"+module+"/@v/v1.1.0.zip"), 1)
+
+	recorder = get(router, "/licenses?module="+module)
+	assert.Equal(t, recorder.Code, 200)
+	var report []routes.VersionLicenses
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.DeepEqual(t, report, []routes.VersionLicenses{{Module: module, Version: "v1.0.0", Licenses: []string{"MIT"}}})
+}
+
+func Test_Router_LicensesOfStoredZips(t *testing.T) {
+	module := fmt.Sprintf("example.com/stored%d", time.Now().UnixNano())
+	fake := newFakeUpstream(t, map[string]string{
+		"/" + module + "/@v/v1.0.0.zip": string(newModuleZip(t, module, "v1.0.0", map[string]string{"LICENSE": mitLicense, "a.go": "package a\n"})),
+		"/" + module + "/@v/v1.1.0.zip": string(newModuleZip(t, module, "v1.1.0", map[string]string{"a.go": "package a\n"})),
+	})
+	t.Cleanup(func() {
+		routes.Licenses = nil
+	})
+	router, _ := newTestRouter()
+
+	// Stored without detection
+	routes.Licenses = nil
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		recorder := get(router, "/"+module+"/@v/"+version+".zip")
+		assert.Equal(t, recorder.Code, 200)
+	}
+
+	// Hits detect the licenses that were not catalogued yet
+	var err error
+	routes.Licenses, err = license.New(license.Config{Detect: true, Allow: "MIT"})
+	assert.NoError(t, err)
+	recorder := get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 200)
+	recorder = get(router, "/"+module+"/@v/v1.1.0.zip")
+	assert.Equal(t, recorder.Code, 403)
+	assert.Equal(t, recorder.Body.String(), module+"@v1.1.0 is refused: no license found")
+
+	recorder = get(router, "/licenses?module="+module)
+	assert.Equal(t, recorder.Code, 200)
+	var report []routes.VersionLicenses
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.DeepEqual(t, report, []routes.VersionLicenses{
+		{Module: module, Version: "v1.0.0", Licenses: []string{"MIT"}},
+		{Module: module, Version: "v1.1.0", Licenses: []string{}},
+	})
+
+	// A changed allow list applies to the catalogued licenses
+	routes.Licenses, err = license.New(license.Config{Detect: true, Allow: "Apache-2.0"})
+	assert.NoError(t, err)
+	recorder = get(router, "/"+module+"/@v/v1.0.0.zip")
+	assert.Equal(t, recorder.Code, 403)
+	assert.Equal(t, recorder.Body.String(), module+"@v1.0.0 is refused: license MIT is not allowed")
+	assert.Equal(t, fake.count("/"+module+"/@v/v1.0.0.zip"), 1)
+	assert.Equal(t, fake.count("/"+module+"/@v/v1.1.0.zip"), 1)
+}
+
+func Test_Router_ReportsAuth(t *testing.T) {
+	clientAuth, err := auth.New(auth.Config{Tokens: "ci=secret", Rules: "ci=example.com/team-a"})
+	assert.NoError(t, err)
+	cfg := testRouterConfig
+	cfg.Auth = clientAuth
+	gin.SetMode(gin.TestMode)
+	db := database.NewMemory()
+	router := newRouter(blobstorage.NewMemory(), cache.NewMemory(), db, cfg)
+	mit := "MIT"
+	for _, path := range []string{"example.com/team-a/Upper", "example.com/team-b/x"} {
+		assert.NoError(t, db.UpdateGoModuleVersion(path, "v1.0.0", func(gomoduleVersion *database.GomoduleVersion) {
+			gomoduleVersion.Licenses = &mit
+		}))
+	}
+	withToken := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// Modules outside the rules of the client are left out
+	recorder := withToken("/licenses")
+	assert.Equal(t, recorder.Code, 200)
+	var report []routes.VersionLicenses
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.DeepEqual(t, report, []routes.VersionLicenses{{Module: "example.com/team-a/Upper", Version: "v1.0.0", Licenses: []string{"MIT"}}})
+
+	recorder = withToken("/licenses?module=example.com/team-a/!upper")
+	assert.Equal(t, recorder.Code, 200)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, len(report), 1)
+	recorder = withToken("/licenses?module=example.com/team-b/x")
+	assert.Equal(t, recorder.Code, 403)
+	assert.Equal(t, recorder.Body.String(), "ci may not access example.com/team-b/x")
+
+	files := map[string]string{
+		"/index/db.json": `{"modified":"2023-07-01T00:00:00Z"}`,
+		"/index/modules.json": `[{"path":"example.com/team-a/Upper","vulns":[{"id":"GO-2023-0001","modified":"2023-07-01T00:00:00Z"}]},
+			{"path":"example.com/team-b/x","vulns":[{"id":"GO-2023-0001","modified":"2023-07-01T00:00:00Z"}]}]`,
+		"/index/vulns.json":     `[{"id":"GO-2023-0001","modified":"2023-07-01T00:00:00Z"}]`,
+		"/ID/GO-2023-0001.json": `{"id":"GO-2023-0001","affected":[{"module":{"path":"example.com/team-a/Upper"}},{"module":{"path":"example.com/team-b/x"}}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := gzip.NewWriter(w)
+		_, _ = writer.Write([]byte(files[strings.TrimSuffix(r.URL.Path, ".gz")]))
+		_ = writer.Close()
+	}))
+	t.Cleanup(server.Close)
+	routes.VulnDB = vulndb.New(vulndb.Config{URL: server.URL, RefreshInterval: time.Hour}, blobstorage.NewMemory())
+	t.Cleanup(func() {
+		routes.VulnDB = nil
+	})
+	_, err = routes.VulnDB.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	recorder = withToken("/vulnerabilities")
+	assert.Equal(t, recorder.Code, 200)
+	var vulnerable []vulndb.VulnerableVersion
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &vulnerable))
+	assert.DeepEqual(t, vulnerable, []vulndb.VulnerableVersion{
+		{Module: "example.com/team-a/Upper", Version: "v1.0.0", Vulns: []vulndb.Vuln{{ID: "GO-2023-0001"}}},
+	})
+	recorder = withToken("/vulnerabilities?module=example.com/team-a/!upper")
+	assert.Equal(t, recorder.Code, 200)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &vulnerable))
+	assert.Equal(t, len(vulnerable), 1)
+	recorder = withToken("/vulnerabilities?module=example.com/team-b/x")
+	assert.Equal(t, recorder.Code, 403)
+}
+
+func Test_Router_VulnDB(t *testing.T) {
+	files := map[string]string{
+		"/index/db.json":      `{"modified":"2023-07-01T00:00:00Z"}`,
+		"/index/modules.json": `[{"path":"example.com/vulnerable","vulns":[{"id":"GO-2023-0001","modified":"2023-07-01T00:00:00Z","fixed":"1.2.0"}]}]`,
+		"/index/vulns.json":   `[{"id":"GO-2023-0001","modified":"2023-07-01T00:00:00Z"}]`,
+		"/ID/GO-2023-0001.json": `{"id":"GO-2023-0001","affected":[{"module":{"path":"example.com/vulnerable"},
+			"ranges":[{"type":"SEMVER","events":[{"introduced":"0"},{"fixed":"1.2.0"}]}]}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, found := files[strings.TrimSuffix(r.URL.Path, ".gz")]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		writer := gzip.NewWriter(w)
+		_, _ = writer.Write([]byte(content))
+		_ = writer.Close()
+	}))
+	t.Cleanup(server.Close)
+
+	gin.SetMode(gin.TestMode)
+	blob, db := blobstorage.NewMemory(), database.NewMemory()
+	router := newRouter(blob, cache.NewMemory(), db, testRouterConfig)
+	recorder := get(router, "/vuln/index/db.json")
+	assert.Equal(t, recorder.Code, 404)
+
+	routes.VulnDB = vulndb.New(vulndb.Config{URL: server.URL, RefreshInterval: time.Hour}, blob)
+	t.Cleanup(func() {
+		routes.VulnDB = nil
+	})
+	_, err := routes.VulnDB.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	// govulncheck fetches the gzipped files
+	recorder = get(router, "/vuln/index/modules.json.gz")
+	assert.Equal(t, recorder.Code, 200)
+	reader, err := gzip.NewReader(recorder.Body)
+	assert.NoError(t, err)
+	modules, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, string(modules), files["/index/modules.json"])
+	recorder = get(router, "/vuln/ID/GO-2023-0001.json")
+	assert.Equal(t, recorder.Code, 200)
+	assert.Equal(t, recorder.Body.String(), files["/ID/GO-2023-0001.json"])
+	recorder = get(router, "/vuln/ID/GO-2023-0002.json")
+	assert.Equal(t, recorder.Code, 404)
+
+	for _, version := range []string{"v1.1.0", "v1.2.0"} {
+		assert.NoError(t, db.UpdateGoModuleVersion("example.com/vulnerable", version, func(*database.GomoduleVersion) {}))
+	}
+	recorder = get(router, "/vulnerabilities")
+	assert.Equal(t, recorder.Code, 200)
+	var vulnerable []vulndb.VulnerableVersion
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &vulnerable))
+	assert.DeepEqual(t, vulnerable, []vulndb.VulnerableVersion{
+		{Module: "example.com/vulnerable", Version: "v1.1.0", Vulns: []vulndb.Vuln{{ID: "GO-2023-0001", Fixed: "1.2.0"}}},
+	})
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/checksum"
+	"goFastCache/pkg/database"
+	gohash "hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// digest hashes and counts everything written to it
+type digest struct {
+	sum  gohash.Hash
+	size int64
+}
+
+func newDigest() *digest {
+	return &digest{sum: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.sum.Write(p)
+}
+
+func (d *digest) Sha256() string {
+	return hex.EncodeToString(d.sum.Sum(nil))
+}
+
+// recordStored catalogues an artifact of uri@version that was just stored.
+// content is the artifact itself, or nil if it was streamed into blob storage.
+// checked is what checking a zip found out about it.
+func recordStored(db database.Database, blob blobstorage.Storage, artifact Artifact, uri, version string, content []byte, stored *digest, checked checkedZip) {
+	if db == nil {
+		return
+	}
+	if stored == nil {
+		stored = newDigest()
+		_, _ = stored.Write(content)
+	}
+
+	var originTime *time.Time
+	switch artifact {
+	case ArtifactInfo:
+		var info struct{ Time *time.Time }
+		if err := json.Unmarshal(content, &info); err == nil {
+			originTime = info.Time
+		}
+	case ArtifactZip:
+		if checked.hash != "" {
+			break
+		}
+		var err error
+		if content != nil {
+			checked.hash, err = checksum.HashZip(bytes.NewReader(content), int64(len(content)))
+		} else {
+			checked.hash, err = hashStoredZip(blob, GetCacheKey(ArtifactZip, uri, version))
+		}
+		if err != nil {
+			zap.S().Warnf("Unable to hash zip of %s@%s: %v", uri, version, err)
+		}
+	}
+
+	err := db.UpdateGoModuleVersion(uri, version, func(gomoduleVersion *database.GomoduleVersion) {
+		if gomoduleVersion.FirstFetched.IsZero() {
+			gomoduleVersion.FirstFetched = time.Now().UTC()
+		}
+		switch artifact {
+		case ArtifactInfo:
+			gomoduleVersion.InfoSize, gomoduleVersion.InfoSha256 = stored.size, stored.Sha256()
+			if originTime != nil {
+				gomoduleVersion.OriginTime = originTime
+			}
+		case ArtifactMod:
+			gomoduleVersion.ModSize, gomoduleVersion.ModSha256 = stored.size, stored.Sha256()
+		case ArtifactZip:
+			gomoduleVersion.ZipSize, gomoduleVersion.ZipSha256 = stored.size, stored.Sha256()
+			if checked.hash != "" {
+				gomoduleVersion.ZipHash = checked.hash
+			}
+			if checked.licenses != nil {
+				licenses := strings.Join(checked.licenses, ",")
+				gomoduleVersion.Licenses = &licenses
+			}
+		}
+	})
+	if err != nil {
+		zap.S().Warnw("Failed to catalogue gomodule version", "error", err)
+	}
+}
+
+// recordAccess counts a client download of uri@version.
+// Only versions that are not catalogued yet, like the ones stored before the catalogue existed, take the locking update.
+func recordAccess(db database.Database, uri, version string) {
+	if db == nil {
+		return
+	}
+	found, err := db.RecordGoModuleVersionAccess(uri, version)
+	if err != nil {
+		zap.S().Warnw("Failed to record access of gomodule version", "error", err)
+		return
+	}
+	if found {
+		return
+	}
+	err = db.UpdateGoModuleVersion(uri, version, func(gomoduleVersion *database.GomoduleVersion) {
+		gomoduleVersion.LastAccessed = time.Now().UTC()
+		gomoduleVersion.FetchCount++
+	})
+	if err != nil {
+		zap.S().Warnw("Failed to record access of gomodule version", "error", err)
+	}
+}
+
+// hashStoredZip is like hashZip, for a zip in blob storage
+func hashStoredZip(blob blobstorage.Storage, cacheKey string) (string, error) {
+	spooled, size, err := spoolStoredZip(blob, cacheKey)
+	if err != nil {
+		return "", err
+	}
+	defer spooled.Close()
+	return checksum.HashZip(spooled.file, size)
+}
+
+// spoolStoredZip copies a zip in blob storage to a temporary file, which is removed once it is closed.
+// Zips can be large, so they are spooled to a file instead of memory.
+func spoolStoredZip(blob blobstorage.Storage, cacheKey string) (*spooledFile, int64, error) {
+	reader, _, found := blob.GetReader(cacheKey)
+	if !found {
+		return nil, 0, errors.New("zip is not stored")
+	}
+	defer reader.Close()
+
+	file, err := os.CreateTemp("", "gofastcache-*.zip")
+	if err != nil {
+		return nil, 0, err
+	}
+	spooled := &spooledFile{file: file}
+	size, err := io.Copy(file, reader)
+	if err != nil {
+		_ = spooled.Close()
+		return nil, 0, err
+	}
+	return spooled, size, nil
+}
@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"fmt"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/cache"
+	"goFastCache/pkg/checksum"
+	"goFastCache/pkg/upstream"
+	"strings"
+)
+
+// ChecksumDB verifies every fetched .mod and .zip before it is stored or served, nil disables verification
+var ChecksumDB *checksum.Verifier
+
+// ConfigureChecksumDB verifies fetched modules against the checksum database with the given verifier key, "off" disables verification.
+// The database is read through the same caches as clients use, so it has to be one of the proxied checksum databases.
+// Private modules are not verified, as the database does not know them, so upstream.SetPrivate has to be called first.
+func ConfigureChecksumDB(key string, cacheX cache.Cache, blob blobstorage.Storage) error {
+	if key == "off" {
+		ChecksumDB = nil
+		return nil
+	}
+	name, _, _ := strings.Cut(key, "+")
+	if !upstream.IsSumDBSupported(name) {
+		return fmt.Errorf("checksum database %s is not proxied, add it to the upstream checksum databases", name)
+	}
+	verifier, err := checksum.NewVerifier(key, func(path string) ([]byte, error) {
+		body, err, _ := GetSumdb(name, strings.TrimPrefix(path, "/"), SumdbExpireMap, cacheX, blob)
+		return body, err
+	})
+	if err != nil {
+		return err
+	}
+	verifier.SetSkip(upstream.GetPrivate())
+	ChecksumDB = verifier
+	return nil
+}
+
+// verifyMod checks the .mod of uri@version against ChecksumDB
+func verifyMod(uri, version string, mod []byte) (error, int) {
+	verifier := ChecksumDB
+	if verifier == nil {
+		return nil, 200
+	}
+	err := verifier.VerifyMod(uri, version, mod)
+	if err != nil {
+		return err, 502
+	}
+	return nil, 200
+}
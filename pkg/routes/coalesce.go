@@ -0,0 +1,149 @@
+package routes
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"goFastCache/pkg/cache"
+	"sync"
+	"time"
+)
+
+// ReplicaLocks de-duplicates upstream fetches across all replicas sharing it, nil limits de-duplication to this process.
+var ReplicaLocks cache.Cache
+
+// replicaLockTTL bounds how long other replicas wait for a fetch, in case the replica holding the lock dies
+var replicaLockTTL = 5 * time.Minute
+var replicaPollInterval = 100 * time.Millisecond
+
+// flight is a fetch of one key that other requests for the same key wait for
+type flight struct {
+	done   chan struct{}
+	body   []byte
+	err    error
+	status int
+}
+
+type flightGroup struct {
+	lock    sync.Mutex
+	flights map[string]*flight
+}
+
+// inFlight holds every fetch of this process that is currently running
+var inFlight = &flightGroup{
+	flights: make(map[string]*flight),
+}
+
+// join returns the flight of key, and whether the caller is its leader.
+// The leader fetches the key and calls land afterwards, everybody else waits for flight.done.
+func (g *flightGroup) join(key string) (*flight, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if f, found := g.flights[key]; found {
+		return f, false
+	}
+	f := &flight{
+		done: make(chan struct{}),
+	}
+	g.flights[key] = f
+	return f, true
+}
+
+// land publishes the result of the flight to its waiters
+func (g *flightGroup) land(key string, f *flight) {
+	g.lock.Lock()
+	delete(g.flights, key)
+	g.lock.Unlock()
+	close(f.done)
+}
+
+// finish lands the flight of a leader, it has to be deferred by the leader.
+// A panicking fetch is recorded as the error of the flight, so its waiters fail instead of blocking forever.
+func (g *flightGroup) finish(key string, f *flight) {
+	if r := recover(); r != nil {
+		f.body, f.err, f.status = nil, panicked(key, r), 500
+		g.land(key, f)
+		panic(r)
+	}
+	g.land(key, f)
+}
+
+// panicked is the error of a flight whose fetch panicked
+func panicked(key string, r any) error {
+	return fmt.Errorf("fetching %s panicked: %v", key, r)
+}
+
+// coalesce calls fetch at most once per key at a time, concurrent callers get the result of the running fetch.
+// lookup checks the shared caches, it is used to pick up results of fetches by other replicas or flights
+// that stream their result into storage.
+func coalesce(cacheKey string, lookup func() ([]byte, bool), fetch func() ([]byte, error, int)) ([]byte, error, int) {
+	f, leader := inFlight.join(cacheKey)
+	if !leader {
+		<-f.done
+		if f.err == nil && f.body == nil {
+			if body, found := lookup(); found {
+				return body, nil, 200
+			}
+			return fetch()
+		}
+		return f.body, f.err, f.status
+	}
+
+	defer inFlight.finish(cacheKey, f)
+	// The body stored by another replica is kept, as it might be gone by the time it is looked up again
+	var stored []byte
+	unlock, storedByReplica := lockAcrossReplicas(cacheKey, func() bool {
+		body, found := lookup()
+		if found {
+			stored = body
+		}
+		return found
+	})
+	defer unlock()
+	if storedByReplica {
+		f.body, f.status = stored, 200
+	} else {
+		f.body, f.err, f.status = fetch()
+	}
+	return f.body, f.err, f.status
+}
+
+// lockAcrossReplicas waits until this replica holds the lock of cacheKey, or until stored reports
+// that another replica already fetched it. The returned unlock function has to be called once the fetch is done.
+func lockAcrossReplicas(cacheKey string, stored func() bool) (unlock func(), storedByReplica bool) {
+	locks := ReplicaLocks
+	unlock = func() {}
+	if locks == nil {
+		return unlock, false
+	}
+
+	deadline := time.Now().Add(replicaLockTTL)
+	for {
+		acquired, err := locks.Lock(cacheKey, replicaLockTTL)
+		if err != nil {
+			zap.S().Warnf("Unable to lock %s across replicas: %s", cacheKey, err.Error())
+			return unlock, false
+		}
+		if acquired {
+			unlock = func() {
+				errX := locks.Unlock(cacheKey)
+				if errX != nil {
+					zap.S().Warnf("Unable to unlock %s across replicas: %s", cacheKey, errX.Error())
+				}
+			}
+			// The previous holder might have just finished
+			if stored() {
+				unlock()
+				return func() {}, true
+			}
+			return unlock, false
+		}
+		if time.Now().After(deadline) {
+			zap.S().Warnf("Timeout waiting for %s to be fetched by another replica", cacheKey)
+			return unlock, false
+		}
+		time.Sleep(replicaPollInterval)
+		if stored() {
+			return unlock, true
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package routes
+
+import (
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/cache"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	var fetches atomic.Int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err, status := coalesce("TestCoalesce", func() ([]byte, bool) {
+				return nil, false
+			}, func() ([]byte, error, int) {
+				fetches.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				return []byte("body"), nil, 200
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, status, 200)
+			assert.Equal(t, string(body), "body")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, fetches.Load(), int32(1))
+}
+
+func TestCoalesce_StoredByReplica(t *testing.T) {
+	locks := cache.NewMemory()
+	setReplicaLocks(t, locks)
+	acquired, err := locks.Lock("TestCoalesce_StoredByReplica", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Another replica stores the key, which is evicted right after it was seen
+	var lookups atomic.Int32
+	body, err, status := coalesce("TestCoalesce_StoredByReplica", func() ([]byte, bool) {
+		if lookups.Add(1) == 1 {
+			return []byte("stored"), true
+		}
+		return nil, false
+	}, func() ([]byte, error, int) {
+		t.Fatal("stored by another replica, but fetched")
+		return nil, nil, 500
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(body), "stored")
+}
+
+// leadPanicking runs lead in the background until it panics, once the test joined its flight of key
+func leadPanicking(t *testing.T, key string, lead func(fetch func())) {
+	started, release := make(chan struct{}), make(chan struct{})
+	recovered := make(chan any, 1)
+	go func() {
+		defer func() {
+			recovered <- recover()
+		}()
+		lead(func() {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+
+	<-started
+	f, leader := inFlight.join(key)
+	assert.False(t, leader)
+	close(release)
+	<-f.done
+	assert.Error(t, f.err)
+	assert.Equal(t, f.status, 500)
+	assert.Equal(t, <-recovered, "boom")
+}
+
+func TestCoalesce_Panic(t *testing.T) {
+	locks := cache.NewMemory()
+	setReplicaLocks(t, locks)
+
+	leadPanicking(t, "TestCoalesce_Panic", func(fetch func()) {
+		_, _, _ = coalesce("TestCoalesce_Panic", func() ([]byte, bool) {
+			return nil, false
+		}, func() ([]byte, error, int) {
+			fetch()
+			return nil, nil, 200
+		})
+	})
+
+	// The key is released, in this process and across replicas
+	acquired, err := locks.Lock("TestCoalesce_Panic", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	f, leader := inFlight.join("TestCoalesce_Panic")
+	assert.True(t, leader)
+	inFlight.land("TestCoalesce_Panic", f)
+}
+
+func TestGetXStream_Panic(t *testing.T) {
+	locks := cache.NewMemory()
+	setReplicaLocks(t, locks)
+	cacheKey := GetCacheKey(ArtifactMod, "example.com/panic", "v1.0.0")
+
+	leadPanicking(t, cacheKey, func(fetch func()) {
+		_, _, _, _ = GetXStream(ArtifactMod, "example.com/panic", "v1.0.0", func(string, string) (io.ReadCloser, int64, error, int) {
+			fetch()
+			return nil, 0, nil, 200
+		}, blobstorage.NewMemory(), nil)
+	})
+
+	acquired, err := locks.Lock(cacheKey, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	f, leader := inFlight.join(cacheKey)
+	assert.True(t, leader)
+	inFlight.land(cacheKey, f)
+}
+
+func setReplicaLocks(t *testing.T, locks cache.Cache) {
+	previous, previousTTL := ReplicaLocks, replicaLockTTL
+	ReplicaLocks = locks
+	t.Cleanup(func() {
+		ReplicaLocks, replicaLockTTL = previous, previousTTL
+	})
+}
+
+func TestLockAcrossReplicas_StoredByReplica(t *testing.T) {
+	locks := cache.NewMemory()
+	setReplicaLocks(t, locks)
+
+	// Another replica holds the lock, and stores the key after a while
+	acquired, err := locks.Lock("key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	var stored atomic.Bool
+	time.AfterFunc(50*time.Millisecond, func() {
+		stored.Store(true)
+	})
+
+	_, storedByReplica := lockAcrossReplicas("key", stored.Load)
+	assert.True(t, storedByReplica)
+}
+
+func TestLockAcrossReplicas_Released(t *testing.T) {
+	locks := cache.NewMemory()
+	setReplicaLocks(t, locks)
+
+	// Another replica holds the lock, and gives up without storing the key
+	acquired, err := locks.Lock("key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	time.AfterFunc(50*time.Millisecond, func() {
+		_ = locks.Unlock("key")
+	})
+
+	unlock, storedByReplica := lockAcrossReplicas("key", func() bool {
+		return false
+	})
+	assert.False(t, storedByReplica)
+	acquired, err = locks.Lock("key", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	unlock()
+	acquired, err = locks.Lock("key", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestLockAcrossReplicas_Timeout(t *testing.T) {
+	locks := cache.NewMemory()
+	setReplicaLocks(t, locks)
+	replicaLockTTL = 50 * time.Millisecond
+
+	// Another replica holds the lock forever
+	acquired, err := locks.Lock("key", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	_, storedByReplica := lockAcrossReplicas("key", func() bool {
+		return false
+	})
+	assert.False(t, storedByReplica)
+}
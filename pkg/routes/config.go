@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"errors"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/cache"
+	"time"
+)
+
+// Config controls how artifacts are cached and verified
+type Config struct {
+	// CoalesceAcrossReplicas de-duplicates upstream fetches across every replica sharing the cache
+	CoalesceAcrossReplicas bool `yaml:"coalesce_across_replicas" env:"COALESCE_ACROSS_REPLICAS"`
+	// NegativeCacheTTL is how long upstream 404 and 410 responses and license refusals are remembered, 0 disables it
+	NegativeCacheTTL time.Duration `yaml:"negative_cache_ttl" env:"NEGATIVE_CACHE_TTL"`
+	// ListTTL and LatestTTL are how long lists and latest versions are kept in the cache
+	ListTTL   time.Duration `yaml:"list_ttl" env:"LIST_TTL"`
+	LatestTTL time.Duration `yaml:"latest_ttl" env:"LATEST_TTL"`
+	// ChecksumDB uses the GOSUMDB key syntax or is "off"
+	ChecksumDB string `yaml:"checksum_db" env:"CHECKSUM_DB"`
+}
+
+// ListTTL is how long lists are kept in the cache
+var ListTTL = time.Minute
+
+// LatestTTL is how long latest versions are kept in the cache
+var LatestTTL = 30 * time.Second
+
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.NegativeCacheTTL < 0 {
+		errs = append(errs, errors.New("negative cache ttl must not be negative"))
+	}
+	if cfg.ListTTL <= 0 {
+		errs = append(errs, errors.New("list ttl has to be a positive duration"))
+	}
+	if cfg.LatestTTL <= 0 {
+		errs = append(errs, errors.New("latest ttl has to be a positive duration"))
+	}
+	if cfg.ChecksumDB == "" {
+		errs = append(errs, errors.New("checksum db is required, use off to disable verification"))
+	}
+	return errors.Join(errs...)
+}
+
+// Configure applies cfg, cacheX is shared between replicas and also holds the negative cache.
+// The settings are package variables, as both the handlers and the index prefetcher call the package functions.
+// They are only written at startup, before anything is served or prefetched.
+func Configure(cfg Config, cacheX cache.Cache, blob blobstorage.Storage) error {
+	ReplicaLocks = nil
+	if cfg.CoalesceAcrossReplicas {
+		ReplicaLocks = cacheX
+	}
+	NegativeCache, NegativeCacheTTL = nil, cfg.NegativeCacheTTL
+	if cfg.NegativeCacheTTL > 0 {
+		NegativeCache = cacheX
+	}
+	ListTTL, LatestTTL = cfg.ListTTL, cfg.LatestTTL
+	return ConfigureChecksumDB(cfg.ChecksumDB, cacheX, blob)
+}
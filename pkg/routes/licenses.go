@@ -0,0 +1,156 @@
+package routes
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"goFastCache/pkg/auth"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/license"
+	"goFastCache/pkg/modpath"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Licenses detects the licenses of stored zips, nil disables detection
+var Licenses *license.Detector
+
+// detectLicenses returns the licenses of the module zip of uri@version in file, or nil if detection is disabled
+func detectLicenses(uri, version string, file *os.File, size int64) ([]string, error) {
+	detector := Licenses
+	if detector == nil {
+		return nil, nil
+	}
+	z, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, err
+	}
+	return detector.Detect(z, uri+"@"+version+"/")
+}
+
+// errLicenseRefused marks versions whose licenses are not on the allow list
+var errLicenseRefused = errors.New("refused")
+
+// checkLicenses returns 403 with the reason if the licenses of uri@version are not on the allow list
+func checkLicenses(uri, version string, licenses []string) (error, int) {
+	err := Licenses.Check(licenses)
+	if err == nil {
+		return nil, 200
+	}
+	licenseRefusals.Inc()
+	return fmt.Errorf("%s@%s is %w: %v", uri, version, errLicenseRefused, err), 403
+}
+
+// checkStoredLicenses refuses the stored zip of uri@version if its catalogued licenses are not allowed.
+// Zips that were stored while license detection was disabled are detected now, and their licenses catalogued.
+func checkStoredLicenses(cacheKey, uri, version string, db database.Database, blob blobstorage.Storage) (error, int) {
+	if !Licenses.Enforced() {
+		return nil, 200
+	}
+	var licenses []string
+	if db != nil {
+		gomoduleVersion, found, err := db.GetGoModuleVersion(uri, version)
+		if err != nil {
+			zap.S().Warnf("Unable to look up the licenses of %s@%s, detecting them: %v", uri, version, err)
+		} else if found {
+			licenses = splitLicenses(gomoduleVersion.Licenses)
+		}
+	}
+	if licenses == nil {
+		var err error
+		licenses, err = detectStoredLicenses(cacheKey, uri, version, blob)
+		if err != nil {
+			return fmt.Errorf("detecting the licenses of %s@%s: %w", uri, version, err), 500
+		}
+		if db != nil {
+			joined := strings.Join(licenses, ",")
+			err = db.UpdateGoModuleVersion(uri, version, func(gomoduleVersion *database.GomoduleVersion) {
+				gomoduleVersion.Licenses = &joined
+			})
+			if err != nil {
+				zap.S().Warnw("Failed to catalogue licenses", "error", err)
+			}
+		}
+	}
+	return checkLicenses(uri, version, licenses)
+}
+
+// detectStoredLicenses is like detectLicenses, for a zip in blob storage
+func detectStoredLicenses(cacheKey, uri, version string, blob blobstorage.Storage) ([]string, error) {
+	spooled, size, err := spoolStoredZip(blob, cacheKey)
+	if err != nil {
+		return nil, err
+	}
+	defer spooled.Close()
+	return detectLicenses(uri, version, spooled.file, size)
+}
+
+// splitLicenses returns the licenses recorded in the catalogue, or nil if they were not detected
+func splitLicenses(licenses *string) []string {
+	if licenses == nil {
+		return nil
+	}
+	if *licenses == "" {
+		return []string{}
+	}
+	return strings.Split(*licenses, ",")
+}
+
+// VersionLicenses are the licenses of a catalogued version
+type VersionLicenses struct {
+	Module   string   `json:"module"`
+	Version  string   `json:"version"`
+	Licenses []string `json:"licenses"`
+}
+
+// HandleLicenses reports the licenses of the catalogued versions, of every module or of the module in the module query parameter.
+// Versions that were stored while license detection was disabled are left out, and so are modules the client may not access.
+func HandleLicenses(c *gin.Context) {
+	db := c.MustGet("db").(database.Database)
+
+	var paths []string
+	if path := c.Query("module"); path != "" {
+		path = modpath.Canonical(path)
+		if !auth.Allows(c, path) {
+			c.String(403, fmt.Sprintf("%s may not access %s", auth.Identity(c), path))
+			return
+		}
+		paths = append(paths, path)
+	} else {
+		gomodules, err := db.ListGoModules()
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		for _, gomodule := range gomodules {
+			if auth.Allows(c, gomodule.Path) {
+				paths = append(paths, gomodule.Path)
+			}
+		}
+		sort.Strings(paths)
+	}
+
+	report := make([]VersionLicenses, 0)
+	for _, path := range paths {
+		gomoduleVersions, err := db.ListGoModuleVersions(path)
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		for _, gomoduleVersion := range gomoduleVersions {
+			if gomoduleVersion.Licenses == nil {
+				continue
+			}
+			report = append(report, VersionLicenses{
+				Module:   path,
+				Version:  gomoduleVersion.Version,
+				Licenses: splitLicenses(gomoduleVersion.Licenses),
+			})
+		}
+	}
+	c.JSON(200, report)
+}
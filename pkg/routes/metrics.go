@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Cache tiers, memory is the in-process expiremap, cache the shared cache (Redis) and blob the blob storage (MinIO)
+const (
+	tierMemory = "memory"
+	tierCache  = "cache"
+	tierBlob   = "blob"
+	tierMiss   = "miss"
+)
+
+// cacheLookups counts where lookups were answered, misses were sent upstream
+var cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gofastcache",
+	Name:      "cache_lookups_total",
+	Help:      "Cache lookups by the tier that answered them, tier miss was sent upstream.",
+}, []string{"tier"})
+
+// policyDenials counts the requests denied by the policy
+var policyDenials = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gofastcache",
+	Name:      "policy_denials_total",
+	Help:      "Requests denied by the policy, by artifact.",
+}, []string{"artifact"})
+
+// licenseRefusals counts the zips that were refused because of their licenses
+var licenseRefusals = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "gofastcache",
+	Name:      "license_refusals_total",
+	Help:      "Zips refused because their licenses are not on the allow list.",
+})
@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"goFastCache/pkg/cache"
+	"time"
+)
+
+// NegativeCache remembers upstream 404 and 410 responses and license refusals per artifact, nil disables negative caching
+var NegativeCache cache.Cache
+
+// NegativeCacheTTL is how long a missing artifact is answered from NegativeCache before upstream is asked again
+var NegativeCacheTTL = 5 * time.Minute
+
+// getNegative returns the error upstream answered cacheKey with, if it is still remembered
+func getNegative(cacheKey string) (error, int, bool) {
+	negativeCache := NegativeCache
+	if negativeCache == nil {
+		return nil, 0, false
+	}
+	status, message, found, err := cache.GetNegative(negativeCache, cacheKey)
+	if err != nil || !found {
+		return nil, 0, false
+	}
+	return errors.New(message), status, true
+}
+
+// setNegative remembers err if upstream reported cacheKey as missing, or if its licenses are refused
+func setNegative(cacheKey string, err error, status int) {
+	negativeCache := NegativeCache
+	if negativeCache == nil || (status != 404 && status != 410 && !errors.Is(err, errLicenseRefused)) {
+		return
+	}
+	errX := cache.SetNegative(negativeCache, cacheKey, status, err.Error(), NegativeCacheTTL)
+	if errX != nil {
+		zap.S().Errorf("Error setting negative cache: %s", errX.Error())
+	}
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/hash"
+	"goFastCache/pkg/modpath"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"strings"
+)
+
+var errNothingStored = errors.New("no version of the module is stored")
+
+// upstreamUnavailable reports whether status means that upstream could not answer at all,
+// instead of answering that the module does not exist
+func upstreamUnavailable(status int) bool {
+	return status == 403 || status >= 500
+}
+
+// storedVersions returns the versions of uri for which one of the given artifacts is in blob storage
+func storedVersions(uri string, blob blobstorage.Storage, artifacts ...Artifact) ([]string, error) {
+	prefix := hash.GetModulePrefix(modpath.Canonical(uri))
+	objects, err := blob.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	versions := make([]string, 0)
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, prefix)
+		for _, artifact := range artifacts {
+			version, found := strings.CutSuffix(name, extensionOf(artifact))
+			if !found || !semver.IsValid(version) || seen[version] {
+				continue
+			}
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+	semver.Sort(versions)
+	return versions, nil
+}
+
+func extensionOf(artifact Artifact) string {
+	switch artifact {
+	case ArtifactInfo:
+		return ".info"
+	case ArtifactMod:
+		return ".mod"
+	case ArtifactZip:
+		return ".zip"
+	}
+	return ""
+}
+
+// GetStoredList builds the /@v/list response of uri from the versions in blob storage.
+// Like upstream, pseudo-versions are left out.
+func GetStoredList(uri string, blob blobstorage.Storage) ([]byte, error, int) {
+	versions, err := storedVersions(uri, blob, ArtifactInfo, ArtifactMod, ArtifactZip)
+	if err != nil {
+		return nil, err, 500
+	}
+	var list strings.Builder
+	for _, version := range versions {
+		if module.IsPseudoVersion(version) {
+			continue
+		}
+		list.WriteString(version)
+		list.WriteString("\n")
+	}
+	if list.Len() == 0 {
+		return nil, errNothingStored, 404
+	}
+	return []byte(list.String()), nil, 200
+}
+
+// GetStoredLatest answers /@latest of uri with the newest .info in blob storage.
+// Like the go command, releases win over pre-releases, which win over pseudo-versions.
+func GetStoredLatest(uri string, blob blobstorage.Storage) ([]byte, error, int) {
+	versions, err := storedVersions(uri, blob, ArtifactInfo)
+	if err != nil {
+		return nil, err, 500
+	}
+	latest := ""
+	for _, version := range versions {
+		if latest == "" || latestRank(version) >= latestRank(latest) {
+			latest = version
+		}
+	}
+	if latest == "" {
+		return nil, errNothingStored, 404
+	}
+
+	info, found := blob.Get(GetCacheKey(ArtifactInfo, uri, latest))
+	if !found {
+		return nil, errNothingStored, 404
+	}
+	var parsed struct{ Version string }
+	if err = json.Unmarshal(info, &parsed); err != nil || parsed.Version != latest {
+		return nil, errors.New("stored info of " + latest + " is invalid"), 500
+	}
+	return info, nil, 200
+}
+
+// latestRank orders the kinds of versions by preference, versions of the same rank are compared by semver
+func latestRank(version string) int {
+	switch {
+	case module.IsPseudoVersion(version):
+		return 0
+	case semver.Prerelease(version) != "":
+		return 1
+	}
+	return 2
+}
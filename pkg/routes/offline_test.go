@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/blobstorage"
+	"testing"
+)
+
+func putStored(t *testing.T, blob blobstorage.Storage, uri string, artifact Artifact, version, content string) {
+	t.Helper()
+	assert.NoError(t, blob.Put(GetCacheKey(artifact, uri, version), []byte(content)))
+}
+
+func TestGetStoredList(t *testing.T) {
+	blob := blobstorage.NewMemory()
+	_, err, status := GetStoredList("example.com/stored", blob)
+	assert.Error(t, err)
+	assert.Equal(t, status, 404)
+
+	putStored(t, blob, "example.com/stored", ArtifactZip, "v1.10.0", "zip")
+	putStored(t, blob, "example.com/stored", ArtifactMod, "v1.10.0", "mod")
+	putStored(t, blob, "example.com/stored", ArtifactInfo, "v1.2.0", "{}")
+	putStored(t, blob, "example.com/stored", ArtifactMod, "v1.3.0-rc.1", "mod")
+	putStored(t, blob, "example.com/stored", ArtifactInfo, "v0.0.0-20230620000000-abcdefabcdef", "{}")
+	// Other modules do not leak into the list
+	putStored(t, blob, "example.com/other", ArtifactInfo, "v9.0.0", "{}")
+	assert.NoError(t, blob.Put(GetCacheKey(ArtifactList, "example.com/stored", ""), []byte("v0.1.0\n")))
+
+	list, err, status := GetStoredList("example.com/stored", blob)
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(list), "v1.2.0\nv1.3.0-rc.1\nv1.10.0\n")
+}
+
+func TestGetStoredLatest(t *testing.T) {
+	const uri = "example.com/latest"
+	blob := blobstorage.NewMemory()
+	_, err, status := GetStoredLatest(uri, blob)
+	assert.Error(t, err)
+	assert.Equal(t, status, 404)
+
+	info := func(version string) string {
+		return `{"Version":"` + version + `","Time":"2023-06-20T00:00:00Z"}`
+	}
+	steps := []struct {
+		artifact Artifact
+		version  string
+		latest   string
+	}{
+		{ArtifactInfo, "v0.0.0-20230620000000-abcdefabcdef", "v0.0.0-20230620000000-abcdefabcdef"},
+		{ArtifactInfo, "v1.1.0-rc.1", "v1.1.0-rc.1"},
+		{ArtifactInfo, "v1.0.0", "v1.0.0"},
+		{ArtifactInfo, "v1.0.1-0.20230621000000-abcdefabcdef", "v1.0.0"},
+		{ArtifactInfo, "v1.0.10", "v1.0.10"},
+		{ArtifactInfo, "v1.0.9", "v1.0.10"},
+		// Only versions with a stored .info can be answered
+		{ArtifactMod, "v2.0.0", "v1.0.10"},
+	}
+	for _, step := range steps {
+		putStored(t, blob, uri, step.artifact, step.version, info(step.version))
+		latest, err, status := GetStoredLatest(uri, blob)
+		assert.NoError(t, err)
+		assert.Equal(t, status, 200)
+		assert.Equal(t, string(latest), info(step.latest))
+	}
+}
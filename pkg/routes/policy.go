@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"goFastCache/pkg/policy"
+)
+
+// Policy decides which modules are fetched and served, nil allows every module
+var Policy *policy.Engine
+
+var artifactNames = map[Artifact]string{
+	ArtifactList:   policy.ArtifactList,
+	ArtifactLatest: policy.ArtifactLatest,
+	ArtifactInfo:   policy.ArtifactInfo,
+	ArtifactMod:    policy.ArtifactMod,
+	ArtifactZip:    policy.ArtifactZip,
+}
+
+// checkPolicy returns 403 with the reason if Policy denies artifact of uri@version.
+// The go command prints the reason to the user.
+func checkPolicy(artifact Artifact, uri, version string) (error, int) {
+	engine := Policy
+	if engine == nil {
+		return nil, 200
+	}
+	decision := engine.Evaluate(uri, version, artifactNames[artifact])
+	if decision.Allowed {
+		return nil, 200
+	}
+	policyDenials.WithLabelValues(artifactNames[artifact]).Inc()
+	if version != "" {
+		uri += "@" + version
+	}
+	return fmt.Errorf("%s is blocked by policy: %s", uri, decision.Reason), 403
+}
+
+// filterList removes the versions whose .info Policy denies from list, so the go command never selects them
+func filterList(uri string, list []byte) []byte {
+	if Policy == nil {
+		return list
+	}
+	var filtered bytes.Buffer
+	for _, version := range bytes.Split(list, []byte("\n")) {
+		if len(version) == 0 {
+			continue
+		}
+		if err, _ := checkPolicy(ArtifactInfo, uri, string(version)); err == nil {
+			filtered.Write(version)
+			filtered.WriteByte('\n')
+		}
+	}
+	return filtered.Bytes()
+}
+
+// checkLatest denies a latest version whose .info Policy denies
+func checkLatest(uri string, latest []byte) (error, int) {
+	if Policy == nil {
+		return nil, 200
+	}
+	var info struct{ Version string }
+	if err := json.Unmarshal(latest, &info); err != nil || info.Version == "" {
+		return nil, 200
+	}
+	return checkPolicy(ArtifactInfo, uri, info.Version)
+}
@@ -9,19 +9,62 @@ import (
 	"goFastCache/pkg/cache"
 	"goFastCache/pkg/database"
 	"goFastCache/pkg/hash"
+	"goFastCache/pkg/modpath"
 	"goFastCache/pkg/upstream"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
 var ListExpireMap = expiremap.NewEx[string, []byte](time.Minute, time.Second*30)
 var LatestExpireMap = expiremap.NewEx[string, []byte](time.Minute, time.Second*30)
+var SumdbExpireMap = expiremap.NewEx[string, []byte](time.Minute, time.Second*30)
+
+// maxErrorSize limits how much of an upstream error response is read into memory
+const maxErrorSize = 64 * 1024
 
 var ThirtySeconds = time.Second * 30
-var OneMinute = time.Minute
+var FiveMinutes = time.Minute * 5
+
+// sumdbTileRegex matches the immutable tiles of a checksum database, including partial tiles
+var sumdbTileRegex = regexp.MustCompile(`^tile/[0-9]+/(data|[0-9]+)(/x[0-9]{3})*/[0-9]{3}(\.p/[0-9]+)?$`)
+
+// Artifact is the kind of GOPROXY file a request asks for.
+// Every artifact of a module (or module@version) is stored under its own key.
+type Artifact int
+
+const (
+	ArtifactList Artifact = iota
+	ArtifactLatest
+	ArtifactInfo
+	ArtifactMod
+	ArtifactZip
+)
+
+// GetCacheKey returns the storage key of the given artifact.
+// version is ignored for ArtifactList and ArtifactLatest.
+func GetCacheKey(artifact Artifact, uri, version string) string {
+	uri = modpath.Canonical(uri)
+	switch artifact {
+	case ArtifactList:
+		return hash.GetListPath(uri)
+	case ArtifactLatest:
+		return hash.GetLatestPath(uri)
+	case ArtifactInfo:
+		return hash.GetInfoPath(uri, version)
+	case ArtifactMod:
+		return hash.GetModPath(uri, version)
+	case ArtifactZip:
+		return hash.GetZipPath(uri, version)
+	}
+	return ""
+}
 
 func HandleList(c *gin.Context, uri string) {
-	blob := c.MustGet("blob").(*blobstorage.Blobstore)
-	cacheX := c.MustGet("cache").(*cache.Cache)
+	blob := c.MustGet("blob").(blobstorage.Storage)
+	cacheX := c.MustGet("cache").(cache.Cache)
 	list, err, status := GetList(uri, ListExpireMap, cacheX, blob)
 	if list != nil {
 		c.Data(200, "text/plain; charset=utf-8", list)
@@ -34,8 +77,9 @@ func HandleList(c *gin.Context, uri string) {
 }
 
 func HandleLatest(c *gin.Context, uri string) {
-	cacheX := c.MustGet("cache").(*cache.Cache)
-	list, err, status := GetLatest(uri, LatestExpireMap, cacheX)
+	blob := c.MustGet("blob").(blobstorage.Storage)
+	cacheX := c.MustGet("cache").(cache.Cache)
+	list, err, status := GetLatest(uri, LatestExpireMap, cacheX, blob)
 	if list != nil {
 		c.Data(200, "text/plain; charset=utf-8", list)
 		return
@@ -47,11 +91,12 @@ func HandleLatest(c *gin.Context, uri string) {
 }
 
 func HandleInfo(c *gin.Context, uri, version string) {
-	blob := c.MustGet("blob").(*blobstorage.Blobstore)
-	db := c.MustGet("db").(*database.Database)
+	blob := c.MustGet("blob").(blobstorage.Storage)
+	db := c.MustGet("db").(database.Database)
 
 	info, err, status := GetInfo(uri, version, db, blob)
 	if info != nil {
+		recordAccess(db, uri, version)
 		c.Data(200, "application/json; charset=utf-8", info)
 		return
 	}
@@ -62,11 +107,12 @@ func HandleInfo(c *gin.Context, uri, version string) {
 }
 
 func HandleMod(c *gin.Context, uri, version string) {
-	blob := c.MustGet("blob").(*blobstorage.Blobstore)
-	db := c.MustGet("db").(*database.Database)
+	blob := c.MustGet("blob").(blobstorage.Storage)
+	db := c.MustGet("db").(database.Database)
 
 	mod, err, status := GetMod(uri, version, db, blob)
 	if mod != nil {
+		recordAccess(db, uri, version)
 		c.Data(200, "text/plain; charset=utf-8", mod)
 		return
 	}
@@ -77,12 +123,19 @@ func HandleMod(c *gin.Context, uri, version string) {
 }
 
 func HandleZip(c *gin.Context, uri, version string) {
-	blob := c.MustGet("blob").(*blobstorage.Blobstore)
-	db := c.MustGet("db").(*database.Database)
+	blob := c.MustGet("blob").(blobstorage.Storage)
+	db := c.MustGet("db").(database.Database)
 
-	zip, err, status := GetZip(uri, version, db, blob)
+	zip, size, err, status := GetZipStream(uri, version, db, blob)
 	if zip != nil {
-		c.Data(200, "application/zip", zip)
+		defer func() {
+			errX := zip.Close()
+			if errX != nil {
+				zap.S().Warnf("Error closing zip stream: %s", errX.Error())
+			}
+		}()
+		c.DataFromReader(200, size, "application/zip", zip, nil)
+		recordAccess(db, uri, version)
 		return
 	}
 	if err != nil {
@@ -91,107 +144,356 @@ func HandleZip(c *gin.Context, uri, version string) {
 	c.Status(404)
 }
 
+// HandleSumdb proxies the checksum database protocol, uri is <name>/supported, <name>/latest,
+// <name>/lookup/<module>@<version> or <name>/tile/...
 func HandleSumdb(c *gin.Context, uri string) {
 	zap.S().Debugf("sumdb request: %s", uri)
-	c.AbortWithStatus(404)
+	blob := c.MustGet("blob").(blobstorage.Storage)
+	cacheX := c.MustGet("cache").(cache.Cache)
+
+	name, trail, _ := strings.Cut(uri, "/")
+	if !upstream.IsSumDBSupported(name) {
+		c.Data(404, "text/plain; charset=utf-8", []byte(fmt.Sprintf("checksum database %s is not proxied", name)))
+		return
+	}
+	if trail == "supported" {
+		c.Status(200)
+		return
+	}
+
+	body, err, status := GetSumdb(name, trail, SumdbExpireMap, cacheX, blob)
+	if body != nil {
+		c.Data(200, "text/plain; charset=utf-8", body)
+		return
+	}
+	if err != nil {
+		c.Data(status, "text/plain; charset=utf-8", []byte(err.Error()))
+	}
+	c.Status(404)
+}
+
+func GetInfo(uri string, version string, db database.Database, blob blobstorage.Storage) ([]byte, error, int) {
+	if err, status := checkPolicy(ArtifactInfo, uri, version); err != nil {
+		return nil, err, status
+	}
+	return GetX(ArtifactInfo, uri, version, upstream.CallUpstreamInfo, nil, nil, blob, nil, db)
+}
+
+// GetList returns the version list of uri.
+// The list changes with every release, so it is not kept in blob storage,
+// but is built from the stored versions if upstream is unavailable.
+func GetList(uri string, memcache *expiremap.ExpireMap[string, []byte], cacheX cache.Cache, blob blobstorage.Storage) ([]byte, error, int) {
+	if err, status := checkPolicy(ArtifactList, uri, ""); err != nil {
+		return nil, err, status
+	}
+	list, err, status := GetXNoVersion(ArtifactList, uri, upstream.CallUpstreamList, memcache, cacheX, nil, &ListTTL)
+	if err != nil && blob != nil && upstreamUnavailable(status) {
+		if stored, _, _ := GetStoredList(uri, blob); stored != nil {
+			zap.S().Infof("Upstream unavailable (%s), serving stored versions of %s", err.Error(), uri)
+			return filterList(uri, stored), nil, 200
+		}
+	}
+	if list != nil {
+		list = filterList(uri, list)
+	}
+	return list, err, status
 }
 
-func GetInfo(uri string, version string, db *database.Database, blob *blobstorage.Blobstore) ([]byte, error, int) {
-	return GetX(uri, version, upstream.CallUpstreamInfo, nil, nil, blob, nil, db)
+// GetLatest returns the latest version of uri, computed from the stored .info files if upstream is unavailable
+func GetLatest(uri string, memcache *expiremap.ExpireMap[string, []byte], cacheX cache.Cache, blob blobstorage.Storage) ([]byte, error, int) {
+	if err, status := checkPolicy(ArtifactLatest, uri, ""); err != nil {
+		return nil, err, status
+	}
+	latest, err, status := GetXNoVersion(ArtifactLatest, uri, upstream.CallUpstreamLatest, memcache, cacheX, nil, &LatestTTL)
+	if err != nil && blob != nil && upstreamUnavailable(status) {
+		if stored, _, _ := GetStoredLatest(uri, blob); stored != nil {
+			zap.S().Infof("Upstream unavailable (%s), serving latest stored version of %s", err.Error(), uri)
+			latest, err, status = stored, nil, 200
+		}
+	}
+	if latest != nil {
+		if err, status := checkLatest(uri, latest); err != nil {
+			return nil, err, status
+		}
+	}
+	return latest, err, status
 }
 
-func GetList(uri string, memcache *expiremap.ExpireMap[string, []byte], cacheX *cache.Cache, blob *blobstorage.Blobstore) ([]byte, error, int) {
-	return GetXNoVersion(uri, upstream.CallUpstreamList, memcache, cacheX, blob, &OneMinute)
+func GetMod(uri string, version string, db database.Database, blob blobstorage.Storage) ([]byte, error, int) {
+	if err, status := checkPolicy(ArtifactMod, uri, version); err != nil {
+		return nil, err, status
+	}
+	return GetX(ArtifactMod, uri, version, upstream.CallUpstreamMod, nil, nil, blob, nil, db)
 }
 
-func GetLatest(uri string, memcache *expiremap.ExpireMap[string, []byte], cacheX *cache.Cache) ([]byte, error, int) {
-	return GetXNoVersion(uri, upstream.CallUpstreamLatest, memcache, cacheX, nil, &ThirtySeconds)
+func GetZip(uri string, version string, db database.Database, blob blobstorage.Storage) ([]byte, error, int) {
+	if err, status := checkPolicy(ArtifactZip, uri, version); err != nil {
+		return nil, err, status
+	}
+	return GetX(ArtifactZip, uri, version, upstream.CallUpstreamZip, nil, nil, blob, nil, db)
 }
 
-func GetMod(uri string, version string, db *database.Database, blob *blobstorage.Blobstore) ([]byte, error, int) {
-	return GetX(uri, version, upstream.CallUpstreamMod, nil, nil, blob, nil, db)
+// GetSumdb returns a file of the checksum database called name.
+// Tiles never change, so they are kept in blob storage forever, lookups and the latest tree are only cached briefly.
+func GetSumdb(name, trail string, memcache *expiremap.ExpireMap[string, []byte], cacheX cache.Cache, blob blobstorage.Storage) ([]byte, error, int) {
+	var cacheTTL *time.Duration
+	switch {
+	case sumdbTileRegex.MatchString(trail):
+		memcache = nil
+		cacheX = nil
+	case trail == "latest":
+		blob = nil
+		cacheTTL = &ThirtySeconds
+	case strings.HasPrefix(trail, "lookup/") && strings.Contains(trail, "@"):
+		blob = nil
+		cacheTTL = &FiveMinutes
+	default:
+		return nil, fmt.Errorf("invalid checksum database path %s", trail), 404
+	}
+
+	cacheKey := hash.GetSumPath(name, trail)
+	body, foundInCache := CachedLookup(cacheKey, memcache, cacheX, blob)
+	if foundInCache {
+		return body, nil, 200
+	}
+	if err, status, found := getNegative(cacheKey); found {
+		return nil, err, status
+	}
+	return coalesce(cacheKey, func() ([]byte, bool) {
+		return CachedLookup(cacheKey, nil, cacheX, blob)
+	}, func() ([]byte, error, int) {
+		upstreamBody, err, status := upstream.CallUpstreamSumDB(name, trail)
+		if err != nil {
+			return nil, err, status
+		}
+		if status != 200 {
+			err = fmt.Errorf("upstream returned status %d (%s)", status, upstreamBody)
+			setNegative(cacheKey, err, status)
+			return nil, err, status
+		}
+
+		SetCache(cacheKey, upstreamBody, memcache, cacheX, blob, cacheTTL)
+
+		return upstreamBody, nil, status
+	})
 }
 
-func GetZip(uri string, version string, db *database.Database, blob *blobstorage.Blobstore) ([]byte, error, int) {
-	return GetX(uri, version, upstream.CallUpstreamZip, nil, nil, blob, nil, db)
+// GetZipStream is like GetZip, but never holds the zip in memory
+func GetZipStream(uri string, version string, db database.Database, blob blobstorage.Storage) (io.ReadCloser, int64, error, int) {
+	if err, status := checkPolicy(ArtifactZip, uri, version); err != nil {
+		return nil, 0, err, status
+	}
+	return GetXStream(ArtifactZip, uri, version, upstream.CallUpstreamZipStream, blob, db)
 }
 
-func GetXNoVersion(uri string, upstreamHandler func(uri string) ([]byte, error, int), memcache *expiremap.ExpireMap[string, []byte], cacheX *cache.Cache, blob *blobstorage.Blobstore, cacheTTL *time.Duration) ([]byte, error, int) {
-	cacheKey := hash.GetListPath(uri)
+func GetXNoVersion(artifact Artifact, uri string, upstreamHandler func(uri string) ([]byte, error, int), memcache *expiremap.ExpireMap[string, []byte], cacheX cache.Cache, blob blobstorage.Storage, cacheTTL *time.Duration) ([]byte, error, int) {
+	cacheKey := GetCacheKey(artifact, uri, "")
 	list, foundInCache := CachedLookup(cacheKey, memcache, cacheX, blob)
 	if foundInCache {
 		return list, nil, 200
 	}
-	upstreamList, err, status := upstreamHandler(uri)
-	if err != nil {
+	if err, status, found := getNegative(cacheKey); found {
 		return nil, err, status
 	}
-	if status != 200 {
-		return nil, fmt.Errorf("upstream returned status %d (%s)", status, upstreamList), status
-	}
+	return coalesce(cacheKey, func() ([]byte, bool) {
+		return CachedLookup(cacheKey, nil, cacheX, blob)
+	}, func() ([]byte, error, int) {
+		upstreamList, err, status := upstreamHandler(uri)
+		if err != nil {
+			return nil, err, status
+		}
+		if status != 200 {
+			err = fmt.Errorf("upstream returned status %d (%s)", status, upstreamList)
+			setNegative(cacheKey, err, status)
+			return nil, err, status
+		}
 
-	SetCache(cacheKey, upstreamList, memcache, cacheX, blob, cacheTTL)
+		SetCache(cacheKey, upstreamList, memcache, cacheX, blob, cacheTTL)
 
-	return upstreamList, nil, status
+		return upstreamList, nil, status
+	})
 }
 
-func GetX(uri, version string, upstreamHandler func(uri string, version string) ([]byte, error, int), memcache *expiremap.ExpireMap[string, []byte], cacheX *cache.Cache, blob *blobstorage.Blobstore, cacheTTL *time.Duration, db *database.Database) ([]byte, error, int) {
-	cacheKey := hash.GetListPath(uri)
+func GetX(artifact Artifact, uri, version string, upstreamHandler func(uri string, version string) ([]byte, error, int), memcache *expiremap.ExpireMap[string, []byte], cacheX cache.Cache, blob blobstorage.Storage, cacheTTL *time.Duration, db database.Database) ([]byte, error, int) {
+	cacheKey := GetCacheKey(artifact, uri, version)
 	list, foundInCache := CachedLookup(cacheKey, memcache, cacheX, blob)
 	if foundInCache {
+		if artifact == ArtifactZip {
+			if err, status := checkStoredLicenses(cacheKey, uri, version, db, blob); err != nil {
+				return nil, err, status
+			}
+		}
 		return list, nil, 200
 	}
-	upstreamList, err, status := upstreamHandler(uri, version)
-	if err != nil {
+	if err, status, found := getNegative(cacheKey); found {
 		return nil, err, status
 	}
-	if status != 200 {
-		return nil, fmt.Errorf("upstream returned status %d (%s)", status, upstreamList), status
+	return coalesce(cacheKey, func() ([]byte, bool) {
+		return CachedLookup(cacheKey, nil, cacheX, blob)
+	}, func() ([]byte, error, int) {
+		upstreamList, err, status := upstreamHandler(uri, version)
+		if err != nil {
+			return nil, err, status
+		}
+		if status != 200 {
+			err = fmt.Errorf("upstream returned status %d (%s)", status, upstreamList)
+			setNegative(cacheKey, err, status)
+			return nil, err, status
+		}
+
+		var checked checkedZip
+		switch artifact {
+		case ArtifactMod:
+			err, status = verifyMod(uri, version, upstreamList)
+		case ArtifactZip:
+			checked, err, status = checkZipBytes(cacheKey, uri, version, upstreamList, db, blob)
+		}
+		if err != nil {
+			return nil, err, status
+		}
+
+		SetCache(cacheKey, upstreamList, memcache, cacheX, blob, cacheTTL)
+
+		recordStored(db, blob, artifact, uri, version, upstreamList, nil, checked)
+
+		return upstreamList, nil, status
+	})
+}
+
+// GetXStream streams the artifact from blob storage, or from upstream on a miss.
+// Upstream responses are stored while they are streamed to the caller, the caller has to close the returned reader.
+// Concurrent misses of the same key wait until the first one is stored, and then read it from blob storage.
+func GetXStream(artifact Artifact, uri, version string, upstreamHandler func(uri string, version string) (io.ReadCloser, int64, error, int), blob blobstorage.Storage, db database.Database) (io.ReadCloser, int64, error, int) {
+	cacheKey := GetCacheKey(artifact, uri, version)
+	if blob == nil {
+		return fetchXStream(artifact, cacheKey, uri, version, upstreamHandler, blob, db, nil)
+	}
+	if reader, size, found := blob.GetReader(cacheKey); found {
+		cacheLookups.WithLabelValues(tierBlob).Inc()
+		if artifact == ArtifactZip {
+			if err, status := checkStoredLicenses(cacheKey, uri, version, db, blob); err != nil {
+				_ = reader.Close()
+				return nil, 0, err, status
+			}
+		}
+		return reader, size, nil, 200
+	}
+	cacheLookups.WithLabelValues(tierMiss).Inc()
+	if err, status, found := getNegative(cacheKey); found {
+		return nil, 0, err, status
 	}
 
-	SetCache(cacheKey, upstreamList, memcache, cacheX, blob, cacheTTL)
+	f, leader := inFlight.join(cacheKey)
+	if !leader {
+		<-f.done
+		if f.err != nil {
+			return nil, 0, f.err, f.status
+		}
+		if reader, size, found := blob.GetReader(cacheKey); found {
+			return reader, size, nil, 200
+		}
+		// The flight did not store it, so try again without coalescing
+		return fetchXStream(artifact, cacheKey, uri, version, upstreamHandler, blob, db, nil)
+	}
 
-	if db != nil {
-		// Upsert into database
-		err = db.UpsertGoModule(database.Gomodule{
-			Path:    uri,
-			Version: version,
+	return leadXStream(f, artifact, cacheKey, uri, version, upstreamHandler, blob, db)
+}
+
+// leadXStream fetches the artifact for the flight f. The flight lands and the replica lock is released as soon as
+// the returned reader stored the artifact, or right away if there is no reader or fetching panics.
+func leadXStream(f *flight, artifact Artifact, cacheKey, uri, version string, upstreamHandler func(uri string, version string) (io.ReadCloser, int64, error, int), blob blobstorage.Storage, db database.Database) (reader io.ReadCloser, size int64, err error, status int) {
+	unlock := func() {}
+	var landOnce sync.Once
+	land := func(err error, status int) {
+		landOnce.Do(func() {
+			f.err, f.status = err, status
+			unlock()
+			inFlight.land(cacheKey, f)
 		})
-		if err != nil {
-			zap.S().Warnw("Failed to upsert gomodule", "error", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			land(panicked(cacheKey, r), 500)
+			panic(r)
+		}
+		if reader == nil {
+			land(err, status)
+		}
+	}()
+
+	unlock, storedByReplica := lockAcrossReplicas(cacheKey, func() bool {
+		_, found, _ := blob.Stat(cacheKey)
+		return found
+	})
+	if storedByReplica {
+		land(nil, 0)
+		if reader, size, found := blob.GetReader(cacheKey); found {
+			return reader, size, nil, 200
 		}
+		return fetchXStream(artifact, cacheKey, uri, version, upstreamHandler, blob, db, nil)
+	}
+	return fetchXStream(artifact, cacheKey, uri, version, upstreamHandler, blob, db, func() {
+		land(nil, 0)
+	})
+}
+
+// fetchXStream streams the artifact from upstream, storing it in blob on the way.
+// onClose is called once the returned reader is closed and the artifact is stored.
+func fetchXStream(artifact Artifact, cacheKey, uri, version string, upstreamHandler func(uri string, version string) (io.ReadCloser, int64, error, int), blob blobstorage.Storage, db database.Database, onClose func()) (io.ReadCloser, int64, error, int) {
+	body, size, err, status := upstreamHandler(uri, version)
+	if err != nil {
+		return nil, 0, err, status
+	}
+	if status != 200 {
+		message, _ := io.ReadAll(io.LimitReader(body, maxErrorSize))
+		_ = body.Close()
+		err = fmt.Errorf("upstream returned status %d (%s)", status, message)
+		setNegative(cacheKey, err, status)
+		return nil, 0, err, status
+	}
+	if artifact == ArtifactZip {
+		return fetchCheckedZip(cacheKey, uri, version, body, blob, db, onClose)
+	}
+	if blob == nil {
+		return body, size, nil, status
 	}
 
-	return upstreamList, nil, status
+	return newBlobTee(cacheKey, body, size, blob, func(stored *digest) {
+		recordStored(db, blob, artifact, uri, version, nil, stored, checkedZip{})
+	}, onClose), size, nil, status
 }
 
-func CachedLookup(cacheKey string, memcache *expiremap.ExpireMap[string, []byte], cacheX *cache.Cache, blob *blobstorage.Blobstore) ([]byte, bool) {
+func CachedLookup(cacheKey string, memcache *expiremap.ExpireMap[string, []byte], cacheX cache.Cache, blob blobstorage.Storage) ([]byte, bool) {
 	if memcache != nil {
-		if k, found := ListExpireMap.Get(cacheKey); found {
+		if k, found := memcache.Get(cacheKey); found {
 			kX := *k
+			cacheLookups.WithLabelValues(tierMemory).Inc()
 			return kX, true
 		}
 	}
 	if cacheX != nil {
 		if k, found, _ := cacheX.Get(cacheKey); found {
+			cacheLookups.WithLabelValues(tierCache).Inc()
 			return k, true
 		}
 	}
 	if blob != nil {
 		if k, found := blob.Get(cacheKey); found {
+			cacheLookups.WithLabelValues(tierBlob).Inc()
 			return k, true
 		}
 	}
+	cacheLookups.WithLabelValues(tierMiss).Inc()
 	return nil, false
 }
 
-func SetCache(cacheKey string, value []byte, memcache *expiremap.ExpireMap[string, []byte], cacheX *cache.Cache, blob *blobstorage.Blobstore, cacheTTL *time.Duration) {
+func SetCache(cacheKey string, value []byte, memcache *expiremap.ExpireMap[string, []byte], cacheX cache.Cache, blob blobstorage.Storage, cacheTTL *time.Duration) {
 	if memcache != nil {
 		memcache.Set(cacheKey, value)
 	}
 	var err error
 	if cacheX != nil {
-		if cacheTTL != nil {
+		if cacheTTL == nil {
 			cacheTTLX := time.Minute
 			cacheTTL = &cacheTTLX
 		}
@@ -208,3 +510,67 @@ func SetCache(cacheKey string, value []byte, memcache *expiremap.ExpireMap[strin
 	}
 	return
 }
+
+// blobTee passes an upstream body through to its reader, while uploading a copy into blob storage.
+// A failing upload never interrupts the reader, and whatever the reader leaves unread is drained into storage on Close.
+type blobTee struct {
+	body     io.ReadCloser
+	writer   *io.PipeWriter
+	done     chan error
+	cacheKey string
+	stored   *digest
+	onStored func(stored *digest)
+	onClose  func()
+}
+
+func newBlobTee(cacheKey string, body io.ReadCloser, size int64, blob blobstorage.Storage, onStored func(stored *digest), onClose func()) *blobTee {
+	pipeReader, pipeWriter := io.Pipe()
+	done := make(chan error, 1)
+	stored := newDigest()
+	go func() {
+		err := blob.PutReader(cacheKey, io.TeeReader(pipeReader, stored), size)
+		// Unblock the writer if the upload stopped early
+		_ = pipeReader.CloseWithError(err)
+		done <- err
+	}()
+	return &blobTee{
+		body:     body,
+		writer:   pipeWriter,
+		done:     done,
+		cacheKey: cacheKey,
+		stored:   stored,
+		onStored: onStored,
+		onClose:  onClose,
+	}
+}
+
+func (t *blobTee) Read(p []byte) (int, error) {
+	n, err := t.body.Read(p)
+	if n > 0 && t.writer != nil {
+		if _, errX := t.writer.Write(p[:n]); errX != nil {
+			t.writer = nil
+		}
+	}
+	if err != nil && err != io.EOF && t.writer != nil {
+		_ = t.writer.CloseWithError(err)
+		t.writer = nil
+	}
+	return n, err
+}
+
+func (t *blobTee) Close() error {
+	if t.writer != nil {
+		_, err := io.Copy(t.writer, t.body)
+		_ = t.writer.CloseWithError(err)
+	}
+	err := <-t.done
+	if err != nil {
+		zap.S().Errorf("Error storing %s: %s", t.cacheKey, err.Error())
+	} else if t.onStored != nil {
+		t.onStored(t.stored)
+	}
+	if t.onClose != nil {
+		t.onClose()
+	}
+	return t.body.Close()
+}
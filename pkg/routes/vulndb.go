@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"goFastCache/pkg/auth"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/modpath"
+	"goFastCache/pkg/vulndb"
+	"strings"
+)
+
+// VulnDB mirrors the Go vulnerability database, nil disables the mirror
+var VulnDB *vulndb.Mirror
+
+// HandleVulnDB serves file of the mirrored vulnerability database, like index/db.json.gz or ID/GO-2022-0001.json
+func HandleVulnDB(c *gin.Context, file string) {
+	mirror := VulnDB
+	if mirror == nil {
+		c.Data(404, "text/plain; charset=utf-8", []byte("the vulnerability database is not mirrored"))
+		return
+	}
+	content, err, status := mirror.Get(file)
+	if err != nil {
+		c.Data(status, "text/plain; charset=utf-8", []byte(err.Error()))
+		return
+	}
+	contentType := "application/json"
+	if strings.HasSuffix(file, ".gz") {
+		contentType = "application/gzip"
+	}
+	c.Data(200, contentType, content)
+}
+
+// HandleVulnerabilities reports the catalogued versions with known vulnerabilities,
+// of every module the client may access or of the module in the module query parameter
+func HandleVulnerabilities(c *gin.Context) {
+	mirror := VulnDB
+	if mirror == nil {
+		c.Data(404, "text/plain; charset=utf-8", []byte("the vulnerability database is not mirrored"))
+		return
+	}
+	path := modpath.Canonical(c.Query("module"))
+	if path != "" && !auth.Allows(c, path) {
+		c.String(403, fmt.Sprintf("%s may not access %s", auth.Identity(c), path))
+		return
+	}
+	db := c.MustGet("db").(database.Database)
+	vulnerable, err := mirror.Vulnerable(db, path)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	allowed := make([]vulndb.VulnerableVersion, 0, len(vulnerable))
+	for _, version := range vulnerable {
+		if auth.Allows(c, version.Module) {
+			allowed = append(allowed, version)
+		}
+	}
+	c.JSON(200, allowed)
+}
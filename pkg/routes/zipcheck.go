@@ -0,0 +1,236 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/checksum"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/upstream"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+	"io"
+	"os"
+)
+
+// QuarantinePrefix is where zips that failed validation or verification are kept, so operators can inspect them.
+// Next to every quarantined zip, a .reason object names the module and why it was refused.
+const QuarantinePrefix = "quarantine/"
+
+// errInvalidZip marks zips that break the module zip rules
+var errInvalidZip = errors.New("invalid module zip")
+
+// fetchCheckedZip spools an upstream zip to a temporary file, and only stores and serves it once it is a valid module zip
+// that matches ChecksumDB. Refused zips are quarantined. onClose is called as soon as the zip is stored.
+func fetchCheckedZip(cacheKey, uri, version string, body io.ReadCloser, blob blobstorage.Storage, db database.Database, onClose func()) (io.ReadCloser, int64, error, int) {
+	defer body.Close()
+	file, err := os.CreateTemp("", "gofastcache-*.zip")
+	if err != nil {
+		return nil, 0, err, 500
+	}
+	spooled := &spooledFile{file: file}
+
+	// Never spool more than a valid zip can have
+	stored := newDigest()
+	size, err := io.Copy(io.MultiWriter(file, stored), io.LimitReader(body, modzip.MaxZipFile+1))
+	if err != nil {
+		_ = spooled.Close()
+		return nil, 0, err, 502
+	}
+	checked, err, status := checkZip(uri, version, file, size, db, blob)
+	if err != nil {
+		if refused(err) && size <= modzip.MaxZipFile {
+			quarantine(cacheKey, uri, version, io.NewSectionReader(file, 0, size), size, blob, err)
+		}
+		if errors.Is(err, errLicenseRefused) {
+			// Refused licenses stay refused, so upstream is not asked again for a while
+			setNegative(cacheKey, err, status)
+		}
+		_ = spooled.Close()
+		return nil, 0, err, status
+	}
+
+	if blob != nil {
+		err = blob.PutReader(cacheKey, io.NewSectionReader(file, 0, size), size)
+		if err != nil {
+			zap.S().Errorf("Error storing %s: %s", cacheKey, err.Error())
+		} else {
+			recordStored(db, blob, ArtifactZip, uri, version, nil, stored, checked)
+		}
+	}
+	if onClose != nil {
+		onClose()
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		_ = spooled.Close()
+		return nil, 0, err, 500
+	}
+	return spooled, size, nil, 200
+}
+
+// checkZipBytes is like checkZip, for a zip that is already in memory
+func checkZipBytes(cacheKey, uri, version string, content []byte, db database.Database, blob blobstorage.Storage) (checkedZip, error, int) {
+	file, err := os.CreateTemp("", "gofastcache-*.zip")
+	if err != nil {
+		return checkedZip{}, err, 500
+	}
+	spooled := &spooledFile{file: file}
+	defer spooled.Close()
+	if _, err = file.Write(content); err != nil {
+		return checkedZip{}, err, 500
+	}
+
+	size := int64(len(content))
+	checked, err, status := checkZip(uri, version, file, size, db, blob)
+	if err != nil && refused(err) {
+		quarantine(cacheKey, uri, version, bytes.NewReader(content), size, blob, err)
+	}
+	if errors.Is(err, errLicenseRefused) {
+		setNegative(cacheKey, err, status)
+	}
+	return checked, err, status
+}
+
+// checkedZip is what checking a zip found out about it
+type checkedZip struct {
+	// hash is the h1: hash of the zip, empty if it is not known yet
+	hash string
+	// licenses are the detected licenses, nil if they were not detected
+	licenses []string
+}
+
+// checkZip validates the module zip of uri@version in file, verifies it against ChecksumDB
+// and refuses it if its licenses are not allowed.
+func checkZip(uri, version string, file *os.File, size int64, db database.Database, blob blobstorage.Storage) (checkedZip, error, int) {
+	if size > modzip.MaxZipFile {
+		return checkedZip{}, fmt.Errorf("%w %s@%s: larger than %d bytes", errInvalidZip, uri, version, modzip.MaxZipFile), 502
+	}
+	// CheckZip covers the path prefix, file names, duplicate and case-colliding files and the size limits
+	_, err := modzip.CheckZip(module.Version{Path: uri, Version: version}, file.Name())
+	if err != nil {
+		return checkedZip{}, fmt.Errorf("%w %s@%s: %v", errInvalidZip, uri, version, err), 502
+	}
+	err, status := checkZipGoMod(uri, version, file, size, blob)
+	if err != nil {
+		return checkedZip{}, err, status
+	}
+
+	zipHash, err := checksum.HashZip(file, size)
+	if err != nil {
+		return checkedZip{}, fmt.Errorf("%w %s@%s: %v", errInvalidZip, uri, version, err), 502
+	}
+	if verifier := ChecksumDB; verifier != nil {
+		err = verifier.VerifyZip(uri, version, zipHash)
+		if err != nil {
+			return checkedZip{}, err, 502
+		}
+	}
+
+	licenses, err := detectLicenses(uri, version, file, size)
+	if err != nil {
+		return checkedZip{}, fmt.Errorf("%w %s@%s: %v", errInvalidZip, uri, version, err), 502
+	}
+	err, status = checkLicenses(uri, version, licenses)
+	if err != nil {
+		return checkedZip{}, err, status
+	}
+	return checkedZip{hash: zipHash, licenses: licenses}, nil, 200
+}
+
+// checkZipGoMod makes sure the go.mod in the zip is valid, declares uri and is the same as the .mod of uri@version
+func checkZipGoMod(uri, version string, file *os.File, size int64, blob blobstorage.Storage) (error, int) {
+	z, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("%w %s@%s: %v", errInvalidZip, uri, version, err), 502
+	}
+	goModFile, err := z.Open(uri + "@" + version + "/go.mod")
+	if errors.Is(err, os.ErrNotExist) {
+		// Modules without go.mod get a synthesized .mod
+		return nil, 200
+	}
+	if err != nil {
+		return fmt.Errorf("%w %s@%s: %v", errInvalidZip, uri, version, err), 502
+	}
+	goMod, err := io.ReadAll(io.LimitReader(goModFile, modzip.MaxGoMod))
+	_ = goModFile.Close()
+	if err != nil {
+		return fmt.Errorf("%w %s@%s: %v", errInvalidZip, uri, version, err), 502
+	}
+
+	parsed, err := modfile.ParseLax("go.mod", goMod, nil)
+	if err != nil {
+		return fmt.Errorf("%w %s@%s: invalid go.mod: %v", errInvalidZip, uri, version, err), 502
+	}
+	if parsed.Module == nil || parsed.Module.Mod.Path != uri {
+		return fmt.Errorf("%w %s@%s: go.mod does not declare module %s", errInvalidZip, uri, version, uri), 502
+	}
+
+	mod, err, status := getModForCheck(uri, version, blob)
+	if err != nil {
+		return fmt.Errorf("checking go.mod of %s@%s: %w", uri, version, err), status
+	}
+	if !bytes.Equal(mod, goMod) {
+		return fmt.Errorf("%w %s@%s: go.mod differs from the .mod file", errInvalidZip, uri, version), 502
+	}
+	return nil, 200
+}
+
+// getModForCheck returns the stored .mod of uri@version, or fetches and verifies it from upstream.
+// Unlike GetMod it skips the policy and the negative cache, which decide what clients are served, not what zips are checked against.
+func getModForCheck(uri, version string, blob blobstorage.Storage) ([]byte, error, int) {
+	if blob != nil {
+		if mod, found := blob.Get(GetCacheKey(ArtifactMod, uri, version)); found {
+			return mod, nil, 200
+		}
+	}
+	mod, err, status := upstream.CallUpstreamMod(uri, version)
+	if err != nil {
+		return nil, err, status
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("upstream returned status %d (%s)", status, mod), status
+	}
+	err, status = verifyMod(uri, version, mod)
+	if err != nil {
+		return nil, err, status
+	}
+	return mod, nil, 200
+}
+
+// refused reports whether err means that the zip itself is bad, as opposed to not being able to check it
+func refused(err error) bool {
+	return errors.Is(err, errInvalidZip) || errors.Is(err, checksum.ErrMismatch)
+}
+
+// quarantine keeps a refused zip under QuarantinePrefix
+func quarantine(cacheKey, uri, version string, zipFile io.Reader, size int64, blob blobstorage.Storage, reason error) {
+	zap.S().Errorf("Quarantining zip of %s@%s: %v", uri, version, reason)
+	if blob == nil {
+		return
+	}
+	err := blob.PutReader(QuarantinePrefix+cacheKey, zipFile, size)
+	if err == nil {
+		err = blob.Put(QuarantinePrefix+cacheKey+".reason", []byte(fmt.Sprintf("%s@%s: %v\n", uri, version, reason)))
+	}
+	if err != nil {
+		zap.S().Errorf("Error quarantining %s: %s", cacheKey, err.Error())
+	}
+}
+
+// spooledFile is a temporary file that is removed once it is closed
+type spooledFile struct {
+	file *os.File
+}
+
+func (s *spooledFile) Read(p []byte) (int, error) {
+	return s.file.Read(p)
+}
+
+func (s *spooledFile) Close() error {
+	err := s.file.Close()
+	return errors.Join(err, os.Remove(s.file.Name()))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"goFastCache/pkg/cache"
+	"goFastCache/pkg/database"
+	"net/http"
+	"time"
+)
+
+// shutdown stops accepting connections and waits up to timeout for active requests and the background jobs signalled by background.
+// Uploads into blob storage are part of the request that started them, so they are drained too.
+// Afterwards the connections to the cache and the database are closed.
+func shutdown(server *http.Server, timeout time.Duration, cacheX cache.Cache, db database.Database, background ...<-chan struct{}) {
+	zap.S().Infof("Shutting down, draining for up to %s", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		zap.S().Warnf("Not every request finished in time: %v", err)
+		_ = server.Close()
+	}
+	for _, done := range background {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			zap.S().Warnf("Background jobs did not stop in time")
+		}
+	}
+
+	err = cacheX.Close()
+	if err != nil {
+		zap.S().Errorf("Error closing cache: %v", err)
+	}
+	err = db.Close()
+	if err != nil {
+		zap.S().Errorf("Error closing database: %v", err)
+	}
+	zap.S().Info("Shutdown complete")
+	_ = zap.L().Sync()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/cache"
+	"goFastCache/pkg/database"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_Shutdown_DrainsRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	response := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		response <- string(body)
+	}()
+	<-started
+
+	background := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		shutdown(server, 10*time.Second, cache.NewMemory(), database.NewMemory(), background)
+		close(stopped)
+	}()
+
+	// New connections are refused while the active request is drained
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			break
+		}
+		_ = conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("listener is still accepting connections")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	assert.Equal(t, <-response, "done")
+	select {
+	case <-stopped:
+		t.Fatal("shutdown did not wait for the background jobs")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(background)
+	<-stopped
+}
+
+func Test_Shutdown_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	start := time.Now()
+	shutdown(server, 50*time.Millisecond, cache.NewMemory(), database.NewMemory(), make(chan struct{}))
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
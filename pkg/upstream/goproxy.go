@@ -0,0 +1,250 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goFastCache/pkg/vcs"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultGOPROXY is the upstream list used by default
+const DefaultGOPROXY = "https://proxy.golang.org"
+
+const (
+	proxyDirect = "direct"
+	proxyOff    = "off"
+)
+
+// proxy is one entry of a GOPROXY list
+type proxy struct {
+	// url is the base url of the proxy, or one of proxyDirect and proxyOff
+	url string
+	// fallbackOnError is set if the entry is followed by "|",
+	// in which case any error falls through to the next entry, not only 404 and 410
+	fallbackOnError bool
+}
+
+var proxiesLock sync.RWMutex
+var goproxy string
+var proxies []proxy
+
+func init() {
+	err := SetGOPROXY(DefaultGOPROXY)
+	if err != nil {
+		panic(err)
+	}
+	err = SetPrivate(vcs.Config{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Config lists the upstreams that are proxied
+type Config struct {
+	// GOPROXY uses the same syntax as the GOPROXY environment variable
+	GOPROXY string `yaml:"goproxy" env:"UPSTREAM_GOPROXY"`
+	// SumDB lists the proxied checksum databases, as accepted by SetSumDB
+	SumDB string `yaml:"sumdb" env:"UPSTREAM_SUMDB"`
+	// Offline disables every upstream, so only what is already stored is served
+	Offline bool `yaml:"offline" env:"OFFLINE_MODE"`
+	// Private modules are fetched directly from their git repositories
+	Private vcs.Config `yaml:"private"`
+}
+
+func (cfg Config) Validate() error {
+	_, err := parseGOPROXY(cfg.GOPROXY)
+	if err != nil {
+		return err
+	}
+	_, err = parseSumDB(cfg.SumDB)
+	if err != nil {
+		return err
+	}
+	return cfg.Private.Validate()
+}
+
+// Configure replaces the upstream list, the proxied checksum databases, the private modules and the offline mode
+func Configure(cfg Config) error {
+	err := SetSumDB(cfg.SumDB)
+	if err != nil {
+		return err
+	}
+	err = SetPrivate(cfg.Private)
+	if err != nil {
+		return err
+	}
+	SetOffline(cfg.Offline)
+	return SetGOPROXY(cfg.GOPROXY)
+}
+
+// SetGOPROXY replaces the upstream list.
+// Entries are separated by "," (fall through only on 404 and 410) or "|" (fall through on any error),
+// and are either proxy urls, "direct" or "off".
+func SetGOPROXY(goproxyX string) error {
+	parsed, err := parseGOPROXY(goproxyX)
+	if err != nil {
+		return err
+	}
+	proxiesLock.Lock()
+	defer proxiesLock.Unlock()
+	goproxy = goproxyX
+	proxies = parsed
+	return nil
+}
+
+// GetGOPROXY returns the upstream list as set by SetGOPROXY
+func GetGOPROXY() string {
+	proxiesLock.RLock()
+	defer proxiesLock.RUnlock()
+	return goproxy
+}
+
+func getProxies() []proxy {
+	proxiesLock.RLock()
+	defer proxiesLock.RUnlock()
+	return proxies
+}
+
+func parseGOPROXY(goproxyX string) ([]proxy, error) {
+	parsed := make([]proxy, 0)
+	for goproxyX != "" {
+		var entry string
+		fallbackOnError := false
+		if i := strings.IndexAny(goproxyX, ",|"); i >= 0 {
+			entry = goproxyX[:i]
+			fallbackOnError = goproxyX[i] == '|'
+			goproxyX = goproxyX[i+1:]
+		} else {
+			entry = goproxyX
+			goproxyX = ""
+		}
+
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry != proxyDirect && entry != proxyOff {
+			proxyUrl, err := url.Parse(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid GOPROXY entry %q: %w", entry, err)
+			}
+			if (proxyUrl.Scheme != "http" && proxyUrl.Scheme != "https") || proxyUrl.Host == "" {
+				return nil, fmt.Errorf("invalid GOPROXY entry %q: expected http(s) url, direct or off", entry)
+			}
+			entry = strings.TrimSuffix(entry, "/")
+		}
+		parsed = append(parsed, proxy{
+			url:             entry,
+			fallbackOnError: fallbackOnError,
+		})
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("GOPROXY list is empty")
+	}
+	return parsed, nil
+}
+
+var offline atomic.Bool
+
+// SetOffline enables or disables the offline mode, in which no upstream is ever contacted
+func SetOffline(offlineX bool) {
+	offline.Store(offlineX)
+}
+
+// IsOffline reports whether the offline mode is enabled
+func IsOffline() bool {
+	return offline.Load()
+}
+
+// Ping checks that at least one upstream proxy answers, with any status.
+// Nothing is contacted in offline mode, or if no proxy url is configured.
+func Ping(ctx context.Context) error {
+	if IsOffline() {
+		return nil
+	}
+	var errs []error
+	for _, p := range getProxies() {
+		if p.url == proxyDirect || p.url == proxyOff {
+			continue
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodHead, p.url+"/", nil)
+		if err != nil {
+			return err
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		_ = response.Body.Close()
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+var errOffline = errors.New("upstream is disabled by offline mode")
+var errProxyOff = errors.New("module lookup disabled by GOPROXY=off")
+
+// fallThrough reports whether the next proxy has to be tried after p answered with err or status
+func (p proxy) fallThrough(err error, status int) bool {
+	if err == nil && status == 200 {
+		return false
+	}
+	if err == nil && (status == 404 || status == 410) {
+		return true
+	}
+	return p.fallbackOnError
+}
+
+// callProxies requests path from every proxy in order, until the GOPROXY rules say to stop.
+// "direct" entries call fetchDirect instead. The response of the last proxy tried is returned.
+func callProxies(path string, fetchDirect func(*vcs.Fetcher) ([]byte, error, int)) (body []byte, err error, status int) {
+	if IsOffline() {
+		return nil, errOffline, 503
+	}
+	for _, p := range getProxies() {
+		switch p.url {
+		case proxyOff:
+			return nil, errProxyOff, 403
+		case proxyDirect:
+			body, err, status = fetchDirect(directFetcher())
+		default:
+			body, err, status = callProxy(p.url+path, false)
+		}
+		if !p.fallThrough(err, status) {
+			break
+		}
+	}
+	return body, err, status
+}
+
+// callProxiesStream is like callProxies, but returns the unread body of the final response
+func callProxiesStream(path string, fetchDirect func(*vcs.Fetcher) (io.ReadCloser, int64, error, int)) (body io.ReadCloser, size int64, err error, status int) {
+	if IsOffline() {
+		return nil, 0, errOffline, 503
+	}
+	for _, p := range getProxies() {
+		if body != nil {
+			_ = body.Close()
+			body = nil
+		}
+		switch p.url {
+		case proxyOff:
+			return nil, 0, errProxyOff, 403
+		case proxyDirect:
+			body, size, err, status = fetchDirect(directFetcher())
+		default:
+			body, size, err, status = callProxyStream(p.url + path)
+		}
+		if !p.fallThrough(err, status) {
+			break
+		}
+	}
+	return body, size, err, status
+}
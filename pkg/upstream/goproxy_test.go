@@ -0,0 +1,180 @@
+package upstream
+
+import (
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/logger"
+	"goFastCache/pkg/vcs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseGOPROXY(t *testing.T) {
+	parsed, err := parseGOPROXY("https://corp.example.com/, https://proxy.golang.org|direct,off")
+	assert.NoError(t, err)
+	assert.DeepEqual(t, parsed, []proxy{
+		{url: "https://corp.example.com", fallbackOnError: false},
+		{url: "https://proxy.golang.org", fallbackOnError: true},
+		{url: "direct", fallbackOnError: false},
+		{url: "off", fallbackOnError: false},
+	})
+
+	for _, invalid := range []string{"", ",", "proxy.golang.org", "ftp://proxy.golang.org", "https://"} {
+		_, err = parseGOPROXY(invalid)
+		assert.Error(t, err)
+	}
+}
+
+// newStatusServer answers every request with status and its own name
+func newStatusServer(t *testing.T, name string, status int, requests *[]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, name)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(name))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func setGOPROXY(t *testing.T, goproxyX string) {
+	previous := GetGOPROXY()
+	assert.NoError(t, SetGOPROXY(goproxyX))
+	t.Cleanup(func() {
+		_ = SetGOPROXY(previous)
+	})
+}
+
+func Test_CallProxies_Fallback(t *testing.T) {
+	testCases := []struct {
+		name             string
+		firstStatus      int
+		separator        string
+		expectedRequests []string
+		expectedStatus   int
+	}{
+		{"comma falls through on 404", 404, ",", []string{"first", "second"}, 200},
+		{"comma falls through on 410", 410, ",", []string{"first", "second"}, 200},
+		{"comma stops on 500", 500, ",", []string{"first"}, 500},
+		{"pipe falls through on 500", 500, "|", []string{"first", "second"}, 200},
+		{"success stops", 200, "|", []string{"first"}, 200},
+	}
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := make([]string, 0)
+			first := newStatusServer(t, "first", tc.firstStatus, &requests)
+			second := newStatusServer(t, "second", 200, &requests)
+			setGOPROXY(t, first+tc.separator+second)
+
+			// Every case needs its own path, as responses are cached
+			body, err, status := CallUpstreamList("example.com/fallback" + string(rune('a'+i)))
+			assert.NoError(t, err)
+			assert.Equal(t, status, tc.expectedStatus)
+			assert.DeepEqual(t, requests, tc.expectedRequests)
+			assert.Equal(t, string(body), tc.expectedRequests[len(tc.expectedRequests)-1])
+
+			requests = requests[:0]
+			stream, _, err, status := CallUpstreamZipStream("example.com/fallback"+string(rune('a'+i)), "v1.0.0")
+			assert.NoError(t, err)
+			defer stream.Close()
+			streamed, err := io.ReadAll(stream)
+			assert.NoError(t, err)
+			assert.Equal(t, status, tc.expectedStatus)
+			assert.DeepEqual(t, requests, tc.expectedRequests)
+			assert.Equal(t, string(streamed), tc.expectedRequests[len(tc.expectedRequests)-1])
+		})
+	}
+}
+
+func Test_CallProxies_Off(t *testing.T) {
+	requests := make([]string, 0)
+	first := newStatusServer(t, "first", 404, &requests)
+	setGOPROXY(t, first+",off")
+
+	_, err, status := CallUpstreamList("example.com/off")
+	assert.Equal(t, err, errProxyOff)
+	assert.Equal(t, status, 403)
+	assert.DeepEqual(t, requests, []string{"first"})
+}
+
+func Test_CallProxies_UnreachableFallsThroughOnPipe(t *testing.T) {
+	requests := make([]string, 0)
+	second := newStatusServer(t, "second", 200, &requests)
+	setGOPROXY(t, "http://127.0.0.1:1|"+second)
+
+	body, err, status := CallUpstreamList("example.com/unreachable")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(body), "second")
+
+	setGOPROXY(t, "http://127.0.0.1:1,"+second)
+	_, err, _ = CallUpstreamList("example.com/unreachable2")
+	assert.Error(t, err)
+}
+
+// newDirectRepo pushes a module tagged v1.0.0 to a new bare repository and returns its path
+func newDirectRepo(t *testing.T, modulePath string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	work, bare := filepath.Join(dir, "work"), filepath.Join(dir, "repo.git")
+	assert.NoError(t, os.MkdirAll(work, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(work, "go.mod"), []byte("module "+modulePath+"\n"), 0o644))
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"init", "-q", "--bare", "-b", "main", bare},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+		{"tag", "v1.0.0"},
+		{"push", "-q", "--tags", bare, "main"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = work
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return bare
+}
+
+func Test_CallProxies_Direct(t *testing.T) {
+	logger.InitLogger()
+	bare := newDirectRepo(t, "example.com/direct")
+	assert.NoError(t, SetPrivate(vcs.Config{Repos: "example.com/direct=" + bare, CacheDir: t.TempDir()}))
+	t.Cleanup(func() {
+		_ = SetPrivate(vcs.Config{})
+	})
+	requests := make([]string, 0)
+	first := newStatusServer(t, "first", 404, &requests)
+	setGOPROXY(t, first+",direct")
+
+	body, err, status := CallUpstreamList("example.com/direct")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(body), "v1.0.0\n")
+	assert.DeepEqual(t, requests, []string{"first"})
+
+	body, err, status = CallUpstreamMod("example.com/direct", "v1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(body), "module example.com/direct\n")
+
+	stream, size, err, status := CallUpstreamZipStream("example.com/direct", "v1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	zipped, err := io.ReadAll(stream)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, int64(len(zipped)), size)
+
+	// Unknown versions are not found in the repository either
+	_, err, status = CallUpstreamInfo("example.com/direct", "v2.0.0")
+	assert.Error(t, err)
+	assert.Equal(t, status, 404)
+}
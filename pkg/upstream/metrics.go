@@ -0,0 +1,20 @@
+package upstream
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"strconv"
+	"time"
+)
+
+// upstreamDuration measures every request sent upstream by response status, transport errors are reported as 502
+var upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gofastcache",
+	Name:      "upstream_request_duration_seconds",
+	Help:      "Latency of requests to the upstream proxies and checksum database, until the response headers arrived.",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+}, []string{"status"})
+
+func observeUpstream(start time.Time, status int) {
+	upstreamDuration.WithLabelValues(strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,60 @@
+package upstream
+
+import (
+	"goFastCache/pkg/vcs"
+	"sync"
+)
+
+var privateLock sync.RWMutex
+var private *vcs.Fetcher
+
+// direct fetches the modules for the "direct" entries of the GOPROXY list
+var direct *vcs.Fetcher
+
+// SetPrivate fetches the modules matching cfg.Patterns directly from their git repositories instead of the GOPROXY list,
+// empty patterns disable it. The "direct" entries of the GOPROXY list use the repositories and mirrors of cfg too.
+func SetPrivate(cfg vcs.Config) error {
+	var fetcher *vcs.Fetcher
+	if cfg.Patterns != "" {
+		var err error
+		fetcher, err = vcs.NewFetcher(cfg)
+		if err != nil {
+			return err
+		}
+	}
+	directFetcher, err := vcs.NewDirectFetcher(cfg)
+	if err != nil {
+		return err
+	}
+	privateLock.Lock()
+	defer privateLock.Unlock()
+	private = fetcher
+	direct = directFetcher
+	return nil
+}
+
+// GetPrivate returns the patterns of the private modules as set by SetPrivate
+func GetPrivate() string {
+	privateLock.RLock()
+	defer privateLock.RUnlock()
+	if private == nil {
+		return ""
+	}
+	return private.Patterns()
+}
+
+// privateFetcher returns the fetcher of uri, or nil if it is not a private module
+func privateFetcher(uri string) *vcs.Fetcher {
+	privateLock.RLock()
+	defer privateLock.RUnlock()
+	if private == nil || !private.Match(uri) {
+		return nil
+	}
+	return private
+}
+
+func directFetcher() *vcs.Fetcher {
+	privateLock.RLock()
+	defer privateLock.RUnlock()
+	return direct
+}
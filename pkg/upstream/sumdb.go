@@ -0,0 +1,96 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultSumDB is the checksum database proxied by default
+const DefaultSumDB = "sum.golang.org"
+
+var sumdbLock sync.RWMutex
+var sumdbs map[string]string
+
+func init() {
+	err := SetSumDB(DefaultSumDB)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// SetSumDB replaces the checksum databases that are proxied.
+// Entries are separated by "," and are either the name of a checksum database, which is fetched from https://<name>,
+// or name=url to fetch it from a different location.
+func SetSumDB(sumdbX string) error {
+	parsed, err := parseSumDB(sumdbX)
+	if err != nil {
+		return err
+	}
+
+	sumdbLock.Lock()
+	defer sumdbLock.Unlock()
+	sumdbs = parsed
+	return nil
+}
+
+func parseSumDB(sumdbX string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, entry := range strings.Split(sumdbX, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, sumdbUrl, found := strings.Cut(entry, "=")
+		if !found {
+			sumdbUrl = "https://" + name
+		}
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid checksum database name %q", name)
+		}
+		parsedUrl, err := url.Parse(sumdbUrl)
+		if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" {
+			return nil, fmt.Errorf("invalid checksum database url %q", sumdbUrl)
+		}
+		parsed[name] = strings.TrimSuffix(sumdbUrl, "/")
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("checksum database list is empty")
+	}
+	return parsed, nil
+}
+
+// GetSumDB returns the checksum databases as accepted by SetSumDB
+func GetSumDB() string {
+	sumdbLock.RLock()
+	defer sumdbLock.RUnlock()
+	entries := make([]string, 0, len(sumdbs))
+	for name, sumdbUrl := range sumdbs {
+		entries = append(entries, name+"="+sumdbUrl)
+	}
+	return strings.Join(entries, ",")
+}
+
+// IsSumDBSupported reports whether the checksum database called name is proxied
+func IsSumDBSupported(name string) bool {
+	sumdbLock.RLock()
+	defer sumdbLock.RUnlock()
+	_, found := sumdbs[name]
+	return found
+}
+
+func CallUpstreamSumDB(name, trail string) ([]byte, error, int) {
+	//https://:NAME/:TRAIL
+	sumdbLock.RLock()
+	sumdbUrl, found := sumdbs[name]
+	sumdbLock.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("checksum database %s is not proxied", name), 404
+	}
+	if IsOffline() {
+		return nil, errOffline, 503
+	}
+	return callProxy(fmt.Sprintf("%s/%s", sumdbUrl, trail), false)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/united-manufacturing-hub/expiremap/pkg/expiremap"
 	"github.com/zeebo/xxh3"
+	"goFastCache/pkg/modpath"
+	"goFastCache/pkg/vcs"
 	"io"
 	"net/http"
 	"time"
@@ -32,16 +34,19 @@ func callProxy(url string, forceUpstream bool) ([]byte, error, int) {
 		}
 	}
 
+	start := time.Now()
 	get, err := http.Get(url)
-
 	if err != nil {
-		return nil, err, 0
+		observeUpstream(start, 502)
+		return nil, err, 502
 	}
+	defer get.Body.Close()
+	observeUpstream(start, get.StatusCode)
 	// Read the response body
 	var body []byte
 	body, err = io.ReadAll(get.Body)
 	if err != nil {
-		return nil, err, 0
+		return nil, err, 502
 	}
 
 	// If the response body is reasonable small, cache it for later
@@ -52,33 +57,130 @@ func callProxy(url string, forceUpstream bool) ([]byte, error, int) {
 	return body, nil, get.StatusCode
 }
 
-func CallUpstreamList(uri string) ([]byte, error, int) {
-	//https://proxy.golang.org/:URI/@v/list
-	return callProxy(fmt.Sprintf("https://proxy.golang.org/%s/@v/list", uri), false)
+// callProxyStream returns the unread response body, the caller has to close it.
+// Responses are not kept in responseMap, as they can be arbitrarily large.
+func callProxyStream(url string) (io.ReadCloser, int64, error, int) {
+	start := time.Now()
+	get, err := http.Get(url)
+	if err != nil {
+		observeUpstream(start, 502)
+		return nil, 0, err, 502
+	}
+	observeUpstream(start, get.StatusCode)
+	return get.Body, get.ContentLength, nil, get.StatusCode
+}
+
+// escape returns the url encoded form of uri and version
+func escape(uri, version string) (string, string, error) {
+	uriX, err := modpath.Escape(uri)
+	if err != nil {
+		return "", "", err
+	}
+	versionX, err := modpath.Escape(version)
+	if err != nil {
+		return "", "", err
+	}
+	return uriX, versionX, nil
+}
 
+func CallUpstreamList(uri string) ([]byte, error, int) {
+	if fetcher := privateFetcher(uri); fetcher != nil {
+		if IsOffline() {
+			return nil, errOffline, 503
+		}
+		return fetcher.List(uri)
+	}
+	//:PROXY/:URI/@v/list
+	uriX, _, err := escape(uri, "")
+	if err != nil {
+		return nil, err, 400
+	}
+	return callProxies(fmt.Sprintf("/%s/@v/list", uriX), func(fetcher *vcs.Fetcher) ([]byte, error, int) {
+		return fetcher.List(uri)
+	})
 }
 
 func CallUpstreamInfo(uri, version string) ([]byte, error, int) {
-	//https://proxy.golang.org/:URI/@v/:VERSION.info
-	return callProxy(fmt.Sprintf("https://proxy.golang.org/%s/@v/%s.info", uri, version), false)
+	if fetcher := privateFetcher(uri); fetcher != nil {
+		if IsOffline() {
+			return nil, errOffline, 503
+		}
+		return fetcher.Info(uri, version)
+	}
+	//:PROXY/:URI/@v/:VERSION.info
+	uriX, versionX, err := escape(uri, version)
+	if err != nil {
+		return nil, err, 400
+	}
+	return callProxies(fmt.Sprintf("/%s/@v/%s.info", uriX, versionX), func(fetcher *vcs.Fetcher) ([]byte, error, int) {
+		return fetcher.Info(uri, version)
+	})
 }
 
 func CallUpstreamMod(uri, version string) ([]byte, error, int) {
-	//https://proxy.golang.org/:URI/@v/:VERSION.mod
-	return callProxy(fmt.Sprintf("https://proxy.golang.org/%s/@v/%s.mod", uri, version), false)
+	if fetcher := privateFetcher(uri); fetcher != nil {
+		if IsOffline() {
+			return nil, errOffline, 503
+		}
+		return fetcher.Mod(uri, version)
+	}
+	//:PROXY/:URI/@v/:VERSION.mod
+	uriX, versionX, err := escape(uri, version)
+	if err != nil {
+		return nil, err, 400
+	}
+	return callProxies(fmt.Sprintf("/%s/@v/%s.mod", uriX, versionX), func(fetcher *vcs.Fetcher) ([]byte, error, int) {
+		return fetcher.Mod(uri, version)
+	})
 }
 
 func CallUpstreamZip(uri, version string) ([]byte, error, int) {
-	//https://proxy.golang.org/:URI/@v/:VERSION.zip
-	return callProxy(fmt.Sprintf("https://proxy.golang.org/%s/@v/%s.zip", uri, version), false)
-
+	if fetcher := privateFetcher(uri); fetcher != nil {
+		if IsOffline() {
+			return nil, errOffline, 503
+		}
+		return fetcher.Zip(uri, version)
+	}
+	//:PROXY/:URI/@v/:VERSION.zip
+	uriX, versionX, err := escape(uri, version)
+	if err != nil {
+		return nil, err, 400
+	}
+	return callProxies(fmt.Sprintf("/%s/@v/%s.zip", uriX, versionX), func(fetcher *vcs.Fetcher) ([]byte, error, int) {
+		return fetcher.Zip(uri, version)
+	})
 }
 
-func CallUpstreamLatest(uri string) ([]byte, error, int) {
-	//https://proxy.golang.org/:URI/@latest
-	return callProxy(fmt.Sprintf("https://proxy.golang.org/%s/@latest", uri), false)
+func CallUpstreamZipStream(uri, version string) (io.ReadCloser, int64, error, int) {
+	if fetcher := privateFetcher(uri); fetcher != nil {
+		if IsOffline() {
+			return nil, 0, errOffline, 503
+		}
+		return fetcher.ZipStream(uri, version)
+	}
+	//:PROXY/:URI/@v/:VERSION.zip
+	uriX, versionX, err := escape(uri, version)
+	if err != nil {
+		return nil, 0, err, 400
+	}
+	return callProxiesStream(fmt.Sprintf("/%s/@v/%s.zip", uriX, versionX), func(fetcher *vcs.Fetcher) (io.ReadCloser, int64, error, int) {
+		return fetcher.ZipStream(uri, version)
+	})
 }
 
-func CallUpstreamSumDB(trail string) ([]byte, error, int) {
-	return callProxy(fmt.Sprintf("https://%s/%s", trail), false)
+func CallUpstreamLatest(uri string) ([]byte, error, int) {
+	if fetcher := privateFetcher(uri); fetcher != nil {
+		if IsOffline() {
+			return nil, errOffline, 503
+		}
+		return fetcher.Latest(uri)
+	}
+	//:PROXY/:URI/@latest
+	uriX, _, err := escape(uri, "")
+	if err != nil {
+		return nil, err, 400
+	}
+	return callProxies(fmt.Sprintf("/%s/@latest", uriX), func(fetcher *vcs.Fetcher) ([]byte, error, int) {
+		return fetcher.Latest(uri)
+	})
 }
@@ -0,0 +1,69 @@
+package vcs
+
+import (
+	"fmt"
+	"golang.org/x/mod/module"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config selects the private modules that are fetched directly from their git repositories
+type Config struct {
+	// Patterns lists the private modules as comma separated GOPRIVATE style glob patterns
+	Patterns string `yaml:"patterns" env:"UPSTREAM_PRIVATE"`
+	// Repos maps module path prefixes to repository urls, as comma separated prefix=url entries.
+	// Modules without an entry are cloned from https://<module path>.
+	Repos string `yaml:"repos" env:"UPSTREAM_PRIVATE_REPOS"`
+	// Username and Password authenticate against http(s) repositories, otherwise they are looked up in Netrc
+	Username string `yaml:"username" env:"GIT_USERNAME"`
+	Password string `yaml:"password" env:"GIT_PASSWORD"`
+	// Netrc is the netrc file holding credentials, it defaults to $NETRC or ~/.netrc like the go command
+	Netrc string `yaml:"netrc" env:"NETRC"`
+	// CacheDir holds the bare mirrors of the repositories
+	CacheDir string `yaml:"cache_dir" env:"VCS_CACHE_DIR"`
+}
+
+func (cfg Config) Validate() error {
+	_, err := parseRepos(cfg.Repos)
+	return err
+}
+
+// repo maps every module below prefix to a repository
+type repo struct {
+	prefix string
+	url    string
+}
+
+// parseRepos parses prefix=url entries, longer prefixes are matched first
+func parseRepos(reposX string) ([]repo, error) {
+	var repos []repo
+	for _, entry := range strings.Split(reposX, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, repoUrl, found := strings.Cut(entry, "=")
+		if !found || prefix == "" || repoUrl == "" {
+			return nil, fmt.Errorf("invalid private repository %q, expected prefix=url", entry)
+		}
+		prefix = strings.TrimSuffix(prefix, "/")
+		if err := module.CheckImportPath(prefix); err != nil {
+			return nil, fmt.Errorf("invalid private repository prefix %q: %w", prefix, err)
+		}
+		if !filepath.IsAbs(repoUrl) {
+			parsed, err := url.Parse(repoUrl)
+			if err != nil || parsed.Scheme == "" {
+				return nil, fmt.Errorf("invalid private repository url %q", repoUrl)
+			}
+		}
+		repos = append(repos, repo{prefix: prefix, url: repoUrl})
+	}
+	return repos, nil
+}
+
+// defaultCacheDir is used if Config.CacheDir is empty
+func defaultCacheDir() string {
+	return filepath.Join(os.TempDir(), "goFastCache-vcs")
+}
@@ -0,0 +1,117 @@
+package vcs
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxDiscoveryPage is the most that is read of a page searched for go-import meta tags
+const maxDiscoveryPage = 1 << 20
+
+var errNoRepository = errors.New("no repository found")
+
+// discoveryClient fetches the ?go-get=1 pages of module paths
+var discoveryClient = &http.Client{Timeout: 30 * time.Second}
+
+// goImport is a <meta name="go-import" content="prefix vcs url"> tag
+type goImport struct {
+	prefix  string
+	vcs     string
+	repoUrl string
+}
+
+// discover finds the repository of modulePath from the go-import meta tag served at https://<modulePath>?go-get=1,
+// the same way the go command does for hosts it does not know
+func (f *Fetcher) discover(modulePath string) (repo, error) {
+	response, err := discoveryClient.Get(f.discoveryBase + modulePath + "?go-get=1")
+	if err != nil {
+		return repo{}, fmt.Errorf("%w for %s: %v", errNoRepository, modulePath, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return repo{}, fmt.Errorf("%w for %s: ?go-get=1 returned %s", errNoRepository, modulePath, response.Status)
+	}
+	imports, err := parseGoImports(io.LimitReader(response.Body, maxDiscoveryPage))
+	if err != nil {
+		return repo{}, fmt.Errorf("%w for %s: %v", errNoRepository, modulePath, err)
+	}
+
+	var found *goImport
+	for i, imp := range imports {
+		if modulePath != imp.prefix && !strings.HasPrefix(modulePath, imp.prefix+"/") {
+			continue
+		}
+		if found != nil {
+			return repo{}, fmt.Errorf("%w for %s: multiple go-import meta tags match", errNoRepository, modulePath)
+		}
+		found = &imports[i]
+	}
+	if found == nil {
+		return repo{}, fmt.Errorf("%w for %s: no go-import meta tag matches", errNoRepository, modulePath)
+	}
+	if found.vcs != "git" {
+		return repo{}, fmt.Errorf("%w for %s: %s repositories are not supported", errNoRepository, modulePath, found.vcs)
+	}
+	if !strings.HasPrefix(found.repoUrl, "https://") {
+		return repo{}, fmt.Errorf("%w for %s: insecure repository url %q", errNoRepository, modulePath, found.repoUrl)
+	}
+	return repo{prefix: found.prefix, url: found.repoUrl}, nil
+}
+
+// parseGoImports returns the go-import meta tags in the head of an html page, it is as lenient as the go command
+func parseGoImports(r io.Reader) ([]goImport, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "ascii":
+			return input, nil
+		default:
+			return nil, fmt.Errorf("can't decode charset %q", charset)
+		}
+	}
+
+	var imports []goImport
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			// Tags before a syntax error are still used
+			if err == io.EOF || len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, err
+		}
+		if end, ok := token.(xml.EndElement); ok && strings.EqualFold(end.Name.Local, "head") {
+			return imports, nil
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(start.Name.Local, "body") {
+			return imports, nil
+		}
+		if !strings.EqualFold(start.Name.Local, "meta") || attrValue(start.Attr, "name") != "go-import" {
+			continue
+		}
+		if fields := strings.Fields(attrValue(start.Attr, "content")); len(fields) == 3 {
+			imports = append(imports, goImport{prefix: fields[0], vcs: fields[1], repoUrl: fields[2]})
+		}
+	}
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, attr := range attrs {
+		if strings.EqualFold(attr.Name.Local, name) {
+			return attr.Value
+		}
+	}
+	return ""
+}
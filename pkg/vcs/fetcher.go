@@ -0,0 +1,667 @@
+package vcs
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fetcher builds the .info, .mod and .zip of private modules from their git repositories,
+// the same way `go mod download` does with GOPRIVATE set
+type Fetcher struct {
+	patterns string
+	repos    []repo
+	cfg      Config
+
+	mirrorsLock sync.Mutex
+	mirrors     map[string]*mirror
+
+	// discoveryBase is prepended to module paths to find their repositories, discovery is disabled if it is empty
+	discoveryBase  string
+	discoveredLock sync.RWMutex
+	discovered     []repo
+}
+
+// NewFetcher returns a Fetcher for the modules matching cfg.Patterns
+func NewFetcher(cfg Config) (*Fetcher, error) {
+	repos, err := parseRepos(cfg.Repos)
+	if err != nil {
+		return nil, err
+	}
+	// Longer prefixes take precedence
+	sort.SliceStable(repos, func(i, j int) bool {
+		return len(repos[i].prefix) > len(repos[j].prefix)
+	})
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = defaultCacheDir()
+	}
+	if cfg.Netrc == "" {
+		cfg.Netrc = netrcPath()
+	}
+	return &Fetcher{
+		patterns: cfg.Patterns,
+		repos:    repos,
+		cfg:      cfg,
+		mirrors:  make(map[string]*mirror),
+	}, nil
+}
+
+// NewDirectFetcher returns a Fetcher for any module, like the go command with GOPROXY=direct.
+// Repositories not in cfg.Repos are found from their go-import meta tags, unless they are on a known host.
+// cfg.Username and cfg.Password are not sent to these hosts, only the netrc credentials of each host are.
+func NewDirectFetcher(cfg Config) (*Fetcher, error) {
+	cfg.Patterns, cfg.Username, cfg.Password = "", "", ""
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = defaultCacheDir()
+	}
+	// Separate mirrors keep the private and direct fetchers from running git on the same repository at once
+	cfg.CacheDir = filepath.Join(cfg.CacheDir, "direct")
+	f, err := NewFetcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	f.discoveryBase = "https://"
+	return f, nil
+}
+
+// Match reports whether path is a private module
+func (f *Fetcher) Match(path string) bool {
+	return f.patterns != "" && module.MatchPrefixPatterns(f.patterns, path)
+}
+
+// Patterns returns the GOPRIVATE style patterns of the private modules
+func (f *Fetcher) Patterns() string {
+	return f.patterns
+}
+
+// Info is the .info of a version, as returned by the go command
+type Info struct {
+	Version string
+	Time    time.Time
+	Origin  *Origin `json:",omitempty"`
+}
+
+// Origin records where a version was fetched from
+type Origin struct {
+	VCS    string
+	URL    string
+	Subdir string `json:",omitempty"`
+	Hash   string
+	Ref    string `json:",omitempty"`
+}
+
+// location is where the code of a module lives
+type location struct {
+	path string
+	// mirror holds the repository
+	mirror *mirror
+	url    string
+	// dir is the directory of the module within the repository, without a major version subdirectory
+	dir string
+	// pathMajor is the major version suffix of path, like /v2
+	pathMajor string
+}
+
+// tagPrefix is prepended to versions to get their tag, like the go command does for modules in subdirectories
+func (l location) tagPrefix() string {
+	if l.dir == "" {
+		return ""
+	}
+	return l.dir + "/"
+}
+
+// codeDir returns the directory holding the module at hash, which is the major version subdirectory if it has a go.mod
+func (l location) codeDir(hash string) (string, error) {
+	if l.pathMajor == "" || strings.HasPrefix(l.pathMajor, ".") {
+		return l.dir, nil
+	}
+	dir := path.Join(l.dir, l.pathMajor[1:])
+	_, found, err := l.mirror.readFile(hash, path.Join(dir, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return dir, nil
+	}
+	return l.dir, nil
+}
+
+// knownHosts have repositories at the first two path elements below the host, as known by the go command
+var knownHosts = map[string]bool{
+	"github.com":    true,
+	"gitlab.com":    true,
+	"bitbucket.org": true,
+}
+
+func (f *Fetcher) locate(modulePath string) (location, error) {
+	prefix, pathMajor, ok := module.SplitPathVersion(modulePath)
+	if !ok {
+		return location{}, fmt.Errorf("%w %s", errInvalidModulePath, modulePath)
+	}
+	loc := location{path: modulePath, pathMajor: pathMajor}
+
+	root, found := findRepo(f.repos, prefix)
+	if !found {
+		root, found = f.discoveredRepo(prefix)
+	}
+	if !found {
+		elements := strings.Split(prefix, "/")
+		switch {
+		case knownHosts[elements[0]] && len(elements) > 3:
+			root.prefix = strings.Join(elements[:3], "/")
+		case knownHosts[elements[0]] || f.discoveryBase == "":
+			root.prefix = prefix
+		default:
+			var err error
+			root, err = f.discover(modulePath)
+			if err != nil {
+				return location{}, err
+			}
+			f.discoveredLock.Lock()
+			f.discovered = append(f.discovered, root)
+			f.discoveredLock.Unlock()
+		}
+		if root.url == "" {
+			root.url = "https://" + root.prefix
+		}
+	}
+	loc.url = root.url
+	// Roots like gopkg.in/yaml.v3 include the major version, so the module is at the top of the repository
+	if dir, inRoot := strings.CutPrefix(prefix, root.prefix); inRoot {
+		loc.dir = strings.TrimPrefix(dir, "/")
+	}
+	loc.mirror = f.mirror(loc.url)
+	return loc, nil
+}
+
+// findRepo returns the repository of repos holding the module path prefix
+func findRepo(repos []repo, prefix string) (repo, bool) {
+	for _, r := range repos {
+		if prefix == r.prefix || strings.HasPrefix(prefix, r.prefix+"/") {
+			return r, true
+		}
+	}
+	return repo{}, false
+}
+
+// discoveredRepo returns the repository holding prefix among the ones found by discover
+func (f *Fetcher) discoveredRepo(prefix string) (repo, bool) {
+	f.discoveredLock.RLock()
+	defer f.discoveredLock.RUnlock()
+	return findRepo(f.discovered, prefix)
+}
+
+// mirror returns the mirror of repoUrl, creating it on first use
+func (f *Fetcher) mirror(repoUrl string) *mirror {
+	f.mirrorsLock.Lock()
+	defer f.mirrorsLock.Unlock()
+	if m, found := f.mirrors[repoUrl]; found {
+		return m
+	}
+	hash := sha256.Sum256([]byte(repoUrl))
+	m := &mirror{
+		url: repoUrl,
+		dir: filepath.Join(f.cfg.CacheDir, hex.EncodeToString(hash[:16])),
+		env: f.credentials(repoUrl),
+	}
+	f.mirrors[repoUrl] = m
+	return m
+}
+
+// credentials returns the environment passing the configured or netrc credentials of repoUrl to git
+func (f *Fetcher) credentials(repoUrl string) []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	parsed, err := url.Parse(repoUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.User != nil {
+		return env
+	}
+	username, password := f.cfg.Username, f.cfg.Password
+	if username == "" && password == "" {
+		var found bool
+		username, password, found = netrcCredentials(f.cfg.Netrc, parsed.Hostname())
+		if !found {
+			return env
+		}
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return append(env,
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic "+auth,
+	)
+}
+
+// failed converts an error into the status returned to clients
+func failed(modulePath string, err error) ([]byte, error, int) {
+	if errors.Is(err, errInvalidModulePath) {
+		return nil, err, 400
+	}
+	if errors.Is(err, errUnknownRevision) || errors.Is(err, errNoRepository) || errors.Is(err, errInvalidVersion) {
+		return nil, err, 404
+	}
+	zap.S().Warnf("Failed to fetch module %s from its repository: %v", modulePath, err)
+	return nil, err, 502
+}
+
+var errInvalidVersion = errors.New("invalid version")
+var errInvalidModulePath = errors.New("invalid module path")
+
+// versions returns the tagged versions of the module, sorted ascending
+func (f *Fetcher) versions(loc location) ([]string, error) {
+	err := loc.mirror.update()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := loc.mirror.tags("")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, tag := range tags {
+		if version, ok := loc.tagVersion(tag); ok {
+			versions = append(versions, version)
+		}
+	}
+	semver.Sort(versions)
+	return versions, nil
+}
+
+// tagVersion returns the version of the module that tag stands for
+func (l location) tagVersion(tag string) (string, bool) {
+	version, found := strings.CutPrefix(tag, l.tagPrefix())
+	if !found || version != semver.Canonical(version) || module.IsPseudoVersion(version) {
+		return "", false
+	}
+	return version, module.CheckPathMajor(version, l.pathMajor) == nil
+}
+
+func (f *Fetcher) List(modulePath string) ([]byte, error, int) {
+	loc, err := f.locate(modulePath)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	versions, err := f.versions(loc)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	var list bytes.Buffer
+	for _, version := range versions {
+		list.WriteString(version + "\n")
+	}
+	return list.Bytes(), nil, 200
+}
+
+func (f *Fetcher) Latest(modulePath string) ([]byte, error, int) {
+	loc, err := f.locate(modulePath)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	versions, err := f.versions(loc)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	// Prefer the highest release, then the highest pre-release, then the default branch
+	query := "HEAD"
+	for i := len(versions) - 1; i >= 0; i-- {
+		if query == "HEAD" || semver.Prerelease(versions[i]) == "" {
+			query = versions[i]
+		}
+		if semver.Prerelease(versions[i]) == "" {
+			break
+		}
+	}
+	return f.info(loc, query)
+}
+
+func (f *Fetcher) Info(modulePath, query string) ([]byte, error, int) {
+	loc, err := f.locate(modulePath)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	return f.info(loc, query)
+}
+
+func (f *Fetcher) info(loc location, query string) ([]byte, error, int) {
+	version, c, ref, err := f.resolve(loc, query)
+	if err != nil {
+		return failed(loc.path, err)
+	}
+	info, err := json.Marshal(Info{
+		Version: version,
+		Time:    c.time,
+		Origin: &Origin{
+			VCS:    "git",
+			URL:    redact(loc.url),
+			Subdir: loc.dir,
+			Hash:   c.hash,
+			Ref:    ref,
+		},
+	})
+	if err != nil {
+		return nil, err, 500
+	}
+	return info, nil, 200
+}
+
+// redact removes the credentials from repoUrl
+func redact(repoUrl string) string {
+	parsed, err := url.Parse(repoUrl)
+	if err != nil || parsed.User == nil {
+		return repoUrl
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// resolve returns the canonical version and commit of query, which is a version, a pseudo-version, a branch or a commit hash.
+// ref is set if query is a tag.
+func (f *Fetcher) resolve(loc location, query string) (version string, c commit, ref string, err error) {
+	if module.IsPseudoVersion(query) {
+		c, err = f.resolvePseudo(loc, query)
+		return query, c, "", err
+	}
+	if semver.IsValid(query) && query == semver.Canonical(query) {
+		if err = module.CheckPathMajor(query, loc.pathMajor); err != nil {
+			return "", commit{}, "", fmt.Errorf("%w %s: %v", errInvalidVersion, query, err)
+		}
+		ref = "refs/tags/" + loc.tagPrefix() + query
+		c, err = loc.mirror.resolve(ref)
+		return query, c, ref, err
+	}
+
+	c, err = loc.mirror.resolve(query)
+	if err != nil {
+		return "", commit{}, "", err
+	}
+	version, err = f.versionOf(loc, c)
+	return version, c, "", err
+}
+
+// resolvePseudo checks that the commit and time of the pseudo-version match the repository
+func (f *Fetcher) resolvePseudo(loc location, version string) (commit, error) {
+	if err := module.CheckPathMajor(version, loc.pathMajor); err != nil {
+		return commit{}, fmt.Errorf("%w %s: %v", errInvalidVersion, version, err)
+	}
+	rev, err := module.PseudoVersionRev(version)
+	if err != nil {
+		return commit{}, fmt.Errorf("%w %s: %v", errInvalidVersion, version, err)
+	}
+	c, err := loc.mirror.resolve(rev)
+	if err != nil {
+		return commit{}, err
+	}
+	if !strings.HasPrefix(c.hash, rev) || len(rev) != 12 {
+		return commit{}, fmt.Errorf("%w %s: revision is shortened incorrectly, expected %s", errInvalidVersion, version, c.hash[:12])
+	}
+	timestamp, err := module.PseudoVersionTime(version)
+	if err != nil || !timestamp.Equal(c.time) {
+		return commit{}, fmt.Errorf("%w %s: does not match commit time %s", errInvalidVersion, version, c.time.Format("20060102150405"))
+	}
+	base, err := module.PseudoVersionBase(version)
+	if err != nil {
+		return commit{}, fmt.Errorf("%w %s: %v", errInvalidVersion, version, err)
+	}
+	if base != "" {
+		ancestors, err := loc.mirror.tags(c.hash)
+		if err != nil {
+			return commit{}, err
+		}
+		found := false
+		for _, tag := range ancestors {
+			if tag == loc.tagPrefix()+base {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return commit{}, fmt.Errorf("%w %s: tag %s is not an ancestor of %s", errInvalidVersion, version, base, c.hash[:12])
+		}
+	}
+	return c, nil
+}
+
+// versionOf returns the highest version tagged on c, or a pseudo-version derived from its highest tagged ancestor
+func (f *Fetcher) versionOf(loc location, c commit) (string, error) {
+	highest := func(tags []string) string {
+		best := ""
+		for _, tag := range tags {
+			if version, ok := loc.tagVersion(tag); ok && semver.Compare(version, best) > 0 {
+				best = version
+			}
+		}
+		return best
+	}
+
+	tags, err := loc.mirror.pointsAt(c.hash)
+	if err != nil {
+		return "", err
+	}
+	if version := highest(tags); version != "" {
+		return version, nil
+	}
+
+	ancestors, err := loc.mirror.tags(c.hash)
+	if err != nil {
+		return "", err
+	}
+	return module.PseudoVersion(module.PathMajorPrefix(loc.pathMajor), highest(ancestors), c.time, c.hash[:12]), nil
+}
+
+// resolveVersion resolves an exact version, as requested for .mod and .zip files
+func (f *Fetcher) resolveVersion(loc location, version string) (commit, error) {
+	if !semver.IsValid(version) || version != semver.Canonical(version) {
+		return commit{}, fmt.Errorf("%w %s: not a canonical version", errInvalidVersion, version)
+	}
+	resolved, c, _, err := f.resolve(loc, version)
+	if err != nil {
+		return commit{}, err
+	}
+	if resolved != version {
+		return commit{}, fmt.Errorf("%w %s", errInvalidVersion, version)
+	}
+	return c, nil
+}
+
+func (f *Fetcher) Mod(modulePath, version string) ([]byte, error, int) {
+	loc, err := f.locate(modulePath)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	c, err := f.resolveVersion(loc, version)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	dir, err := loc.codeDir(c.hash)
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	mod, found, err := loc.mirror.readFile(c.hash, path.Join(dir, "go.mod"))
+	if err != nil {
+		return failed(modulePath, err)
+	}
+	if !found {
+		// Modules without go.mod get a synthesized one, like the go command does
+		return []byte("module " + modfile.AutoQuote(modulePath) + "\n"), nil, 200
+	}
+	if declared := modfile.ModulePath(mod); declared != modulePath {
+		return nil, fmt.Errorf("%w %s: go.mod declares module path %q", errInvalidVersion, version, declared), 404
+	}
+	return mod, nil, 200
+}
+
+func (f *Fetcher) Zip(modulePath, version string) ([]byte, error, int) {
+	file, _, err, status := f.zip(modulePath, version)
+	if err != nil {
+		return nil, err, status
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err, 500
+	}
+	return content, nil, 200
+}
+
+// ZipStream is like Zip, but returns the zip as a temporary file which is removed on close
+func (f *Fetcher) ZipStream(modulePath, version string) (io.ReadCloser, int64, error, int) {
+	file, size, err, status := f.zip(modulePath, version)
+	if err != nil {
+		return nil, 0, err, status
+	}
+	return file, size, nil, 200
+}
+
+func (f *Fetcher) zip(modulePath, version string) (*tempFile, int64, error, int) {
+	loc, err := f.locate(modulePath)
+	if err != nil {
+		_, err, status := failed(modulePath, err)
+		return nil, 0, err, status
+	}
+	c, err := f.resolveVersion(loc, version)
+	if err != nil {
+		_, err, status := failed(modulePath, err)
+		return nil, 0, err, status
+	}
+	file, size, err := f.createZip(loc, module.Version{Path: modulePath, Version: version}, c)
+	if err != nil {
+		_, err, status := failed(modulePath, err)
+		return nil, 0, err, status
+	}
+	return file, size, nil, 200
+}
+
+// createZip builds the module zip of c into a temporary file
+func (f *Fetcher) createZip(loc location, m module.Version, c commit) (*tempFile, int64, error) {
+	dir, err := loc.codeDir(c.hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	archive, err := newTempFile()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer archive.Close()
+	err = loc.mirror.archive(c.hash, dir, archive.File)
+	if err != nil {
+		return nil, 0, err
+	}
+	archiveSize, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	reader, err := zip.NewReader(archive, archiveSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var files []modzip.File
+	hasLicense := false
+	for _, zf := range reader.File {
+		name := zf.Name
+		if dir != "" {
+			var found bool
+			if name, found = strings.CutPrefix(name, dir+"/"); !found {
+				continue
+			}
+		}
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		hasLicense = hasLicense || name == "LICENSE"
+		files = append(files, archiveFile{name: name, f: zf})
+	}
+	// Modules in subdirectories inherit the LICENSE of the repository, like the go command does
+	if dir != "" && !hasLicense {
+		license, found, err := loc.mirror.readFile(c.hash, "LICENSE")
+		if err != nil {
+			return nil, 0, err
+		}
+		if found {
+			files = append(files, memFile{name: "LICENSE", content: license})
+		}
+	}
+
+	result, err := newTempFile()
+	if err != nil {
+		return nil, 0, err
+	}
+	err = modzip.Create(result, m, files)
+	if err != nil {
+		_ = result.Close()
+		return nil, 0, err
+	}
+	size, err := result.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = result.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = result.Close()
+		return nil, 0, err
+	}
+	return result, size, nil
+}
+
+// tempFile is removed once it is closed
+type tempFile struct {
+	*os.File
+}
+
+func newTempFile() (*tempFile, error) {
+	file, err := os.CreateTemp("", "goFastCache-vcs-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{File: file}, nil
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	_ = os.Remove(t.File.Name())
+	return err
+}
+
+// archiveFile is a file of a git archive
+type archiveFile struct {
+	name string
+	f    *zip.File
+}
+
+func (a archiveFile) Path() string                 { return a.name }
+func (a archiveFile) Lstat() (os.FileInfo, error)  { return a.f.FileInfo(), nil }
+func (a archiveFile) Open() (io.ReadCloser, error) { return a.f.Open() }
+
+// memFile is a regular file read from the repository
+type memFile struct {
+	name    string
+	content []byte
+}
+
+func (m memFile) Path() string                 { return m.name }
+func (m memFile) Lstat() (os.FileInfo, error)  { return m, nil }
+func (m memFile) Open() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(m.content)), nil }
+func (m memFile) Name() string                 { return path.Base(m.name) }
+func (m memFile) Size() int64                  { return int64(len(m.content)) }
+func (m memFile) Mode() fs.FileMode            { return 0o644 }
+func (m memFile) ModTime() time.Time           { return time.Time{} }
+func (m memFile) IsDir() bool                  { return false }
+func (m memFile) Sys() any                     { return nil }
@@ -0,0 +1,447 @@
+package vcs
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/checksum"
+	"goFastCache/pkg/logger"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testModule = "example.com/private/repo"
+
+// testRepo is a working repository whose commits are pushed to a bare repository
+type testRepo struct {
+	t    *testing.T
+	work string
+	bare string
+}
+
+func (r *testRepo) git(args ...string) string {
+	return r.gitEnv(nil, args...)
+}
+
+func (r *testRepo) gitEnv(env []string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.work
+	cmd.Env = append(append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1"), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit commits files at the given date and pushes it with tags, it returns the commit hash
+func (r *testRepo) commit(date time.Time, files map[string]string, tags ...string) string {
+	for name, content := range files {
+		name = filepath.Join(r.work, name)
+		assert.NoError(r.t, os.MkdirAll(filepath.Dir(name), 0o755))
+		assert.NoError(r.t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	r.git("add", "-A")
+	stamp := date.Format(time.RFC3339)
+	// The committer date is the commit time used for pseudo-versions
+	r.gitEnv([]string{"GIT_AUTHOR_DATE=" + stamp, "GIT_COMMITTER_DATE=" + stamp},
+		"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", stamp)
+	for _, tag := range tags {
+		r.git("tag", tag)
+	}
+	r.git("push", "-q", "--tags", r.bare, "main")
+	return r.git("rev-parse", "HEAD")
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	r := &testRepo{t: t, work: filepath.Join(dir, "work"), bare: filepath.Join(dir, "repo.git")}
+	assert.NoError(t, os.MkdirAll(r.work, 0o755))
+	r.git("init", "-q", "-b", "main")
+	r.git("init", "-q", "--bare", "-b", "main", r.bare)
+	return r
+}
+
+func newTestFetcher(t *testing.T, r *testRepo) *Fetcher {
+	logger.InitLogger()
+	f, err := NewFetcher(Config{
+		Patterns: "example.com/private",
+		Repos:    "example.com/private/repo=" + r.bare,
+		CacheDir: t.TempDir(),
+	})
+	assert.NoError(t, err)
+	return f
+}
+
+var (
+	day1 = time.Date(2023, 6, 20, 10, 0, 0, 0, time.UTC)
+	day2 = time.Date(2023, 6, 21, 10, 0, 0, 0, time.UTC)
+	day3 = time.Date(2023, 6, 22, 10, 0, 0, 0, time.UTC)
+)
+
+// setupRepo commits a module with a nested module in sub, tagged v1.0.0 and sub/v0.1.0, then v1.1.0 and an untagged commit.
+// It returns the hashes of the commits.
+func setupRepo(r *testRepo) []string {
+	first := r.commit(day1, map[string]string{
+		"go.mod":     "module " + testModule + "\n\ngo 1.20\n",
+		"a.go":       "package a\n",
+		"LICENSE":    "license\n",
+		"sub/go.mod": "module " + testModule + "/sub\n",
+		"sub/b.go":   "package sub\n",
+	}, "v1.0.0", "sub/v0.1.0", "v1.2", "junk")
+	second := r.commit(day2, map[string]string{"a.go": "package a\n\nconst A = 1\n"}, "v1.1.0")
+	third := r.commit(day3, map[string]string{"a.go": "package a\n\nconst A = 2\n"})
+	return []string{first, second, third}
+}
+
+// info returns the .info of path at query, or its latest version if query is "@latest"
+func info(t *testing.T, f *Fetcher, path, query string) Info {
+	var body []byte
+	var err error
+	var status int
+	if query == "@latest" {
+		body, err, status = f.Latest(path)
+	} else {
+		body, err, status = f.Info(path, query)
+	}
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	var parsed Info
+	assert.NoError(t, json.Unmarshal(body, &parsed))
+	return parsed
+}
+
+func TestFetcher_Match(t *testing.T) {
+	f, err := NewFetcher(Config{Patterns: "example.com/private,*.corp.example.com"})
+	assert.NoError(t, err)
+	assert.True(t, f.Match("example.com/private/repo"))
+	assert.True(t, f.Match("git.corp.example.com/tools"))
+	assert.False(t, f.Match("example.com/public"))
+}
+
+func TestFetcher_List(t *testing.T) {
+	r := newTestRepo(t)
+	setupRepo(r)
+	f := newTestFetcher(t, r)
+
+	list, err, status := f.List(testModule)
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(list), "v1.0.0\nv1.1.0\n")
+
+	list, err, status = f.List(testModule + "/sub")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(list), "v0.1.0\n")
+
+	// Tags pushed later are fetched
+	r.git("tag", "v1.2.0")
+	r.git("push", "-q", "--tags", r.bare)
+	list, _, _ = f.List(testModule)
+	assert.Equal(t, string(list), "v1.0.0\nv1.1.0\nv1.2.0\n")
+}
+
+func TestFetcher_Info(t *testing.T) {
+	r := newTestRepo(t)
+	hashes := setupRepo(r)
+	f := newTestFetcher(t, r)
+
+	tagged := info(t, f, testModule, "v1.0.0")
+	assert.Equal(t, tagged.Version, "v1.0.0")
+	assert.True(t, tagged.Time.Equal(day1))
+	assert.DeepEqual(t, tagged.Origin, &Origin{VCS: "git", URL: r.bare, Hash: hashes[0], Ref: "refs/tags/v1.0.0"})
+
+	// Branches and commits resolve to the tag pointing at them, or to a pseudo-version
+	pseudo := "v1.1.1-0.20230622100000-" + hashes[2][:12]
+	assert.Equal(t, info(t, f, testModule, "main").Version, pseudo)
+	assert.Equal(t, info(t, f, testModule, hashes[1]).Version, "v1.1.0")
+	assert.Equal(t, info(t, f, testModule, hashes[2][:8]).Version, pseudo)
+	resolved := info(t, f, testModule, pseudo)
+	assert.Equal(t, resolved.Version, pseudo)
+	assert.True(t, resolved.Time.Equal(day3))
+
+	sub := info(t, f, testModule+"/sub", "v0.1.0")
+	assert.Equal(t, sub.Origin.Subdir, "sub")
+	assert.Equal(t, sub.Origin.Ref, "refs/tags/sub/v0.1.0")
+
+	for _, query := range []string{
+		"v1.9.0",
+		"v2.0.0",
+		"unknown",
+		// Wrong time
+		"v1.1.1-0.20230623100000-" + hashes[2][:12],
+		// Base tag is not an ancestor
+		"v1.1.1-0.20230620100000-" + hashes[0][:12],
+	} {
+		_, err, status := f.Info(testModule, query)
+		assert.Error(t, err)
+		assert.Equal(t, status, 404)
+	}
+}
+
+func TestFetcher_Latest(t *testing.T) {
+	r := newTestRepo(t)
+	setupRepo(r)
+	f := newTestFetcher(t, r)
+	assert.Equal(t, info(t, f, testModule, "@latest").Version, "v1.1.0")
+
+	// Pre-releases are only used if there is no release
+	r.git("tag", "v1.2.0-rc.1")
+	r.git("push", "-q", "--tags", r.bare)
+	assert.Equal(t, info(t, f, testModule, "@latest").Version, "v1.1.0")
+
+	// Without tags the default branch is used
+	other := newTestRepo(t)
+	head := other.commit(day1, map[string]string{"go.mod": "module example.com/private/untagged\n"})
+	f, err := NewFetcher(Config{Patterns: "example.com/private", Repos: "example.com/private/untagged=" + other.bare, CacheDir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.Equal(t, info(t, f, "example.com/private/untagged", "@latest").Version, "v0.0.0-20230620100000-"+head[:12])
+}
+
+func TestFetcher_Mod(t *testing.T) {
+	r := newTestRepo(t)
+	setupRepo(r)
+	f := newTestFetcher(t, r)
+
+	mod, err, status := f.Mod(testModule, "v1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, string(mod), "module "+testModule+"\n\ngo 1.20\n")
+
+	mod, _, _ = f.Mod(testModule+"/sub", "v0.1.0")
+	assert.Equal(t, string(mod), "module "+testModule+"/sub\n")
+
+	// Only exact versions are served
+	_, err, status = f.Mod(testModule, "main")
+	assert.Error(t, err)
+	assert.Equal(t, status, 404)
+}
+
+// zipEntries returns the names and contents of the files in a zip
+func zipEntries(t *testing.T, content []byte) map[string]string {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+	entries := make(map[string]string)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		assert.NoError(t, err)
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(rc)
+		assert.NoError(t, err)
+		_ = rc.Close()
+		entries[file.Name] = buf.String()
+	}
+	return entries
+}
+
+func TestFetcher_Zip(t *testing.T) {
+	r := newTestRepo(t)
+	setupRepo(r)
+	f := newTestFetcher(t, r)
+
+	content, err, status := f.Zip(testModule, "v1.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	entries := zipEntries(t, content)
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// The nested module is left out
+	assert.DeepEqual(t, names, []string{testModule + "@v1.1.0/LICENSE", testModule + "@v1.1.0/a.go", testModule + "@v1.1.0/go.mod"})
+	assert.Equal(t, entries[testModule+"@v1.1.0/a.go"], "package a\n\nconst A = 1\n")
+
+	// The hash matches a zip built from the working repository
+	var expected bytes.Buffer
+	assert.NoError(t, modzip.CreateFromVCS(&expected, module.Version{Path: testModule, Version: "v1.1.0"}, r.work, "v1.1.0", ""))
+	expectedHash, err := checksum.HashZip(bytes.NewReader(expected.Bytes()), int64(expected.Len()))
+	assert.NoError(t, err)
+	actualHash, err := checksum.HashZip(bytes.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+	assert.Equal(t, actualHash, expectedHash)
+
+	// Nested modules inherit the LICENSE of the repository
+	stream, size, err, status := f.ZipStream(testModule+"/sub", "v0.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(stream)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	assert.Equal(t, int64(buf.Len()), size)
+	assert.DeepEqual(t, zipEntries(t, buf.Bytes()), map[string]string{
+		testModule + "/sub@v0.1.0/LICENSE": "license\n",
+		testModule + "/sub@v0.1.0/b.go":    "package sub\n",
+		testModule + "/sub@v0.1.0/go.mod":  "module " + testModule + "/sub\n",
+	})
+}
+
+func TestFetcher_MajorVersionSubdirectory(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit(day1, map[string]string{
+		"go.mod":    "module " + testModule + "\n",
+		"v2/go.mod": "module " + testModule + "/v2\n",
+		"v2/a.go":   "package a\n",
+	}, "v1.0.0", "v2.0.0")
+	f := newTestFetcher(t, r)
+
+	list, _, _ := f.List(testModule + "/v2")
+	assert.Equal(t, string(list), "v2.0.0\n")
+	list, _, _ = f.List(testModule)
+	assert.Equal(t, string(list), "v1.0.0\n")
+	mod, _, _ := f.Mod(testModule+"/v2", "v2.0.0")
+	assert.Equal(t, string(mod), "module "+testModule+"/v2\n")
+	content, err, _ := f.Zip(testModule+"/v2", "v2.0.0")
+	assert.NoError(t, err)
+	assert.DeepEqual(t, zipEntries(t, content), map[string]string{
+		testModule + "/v2@v2.0.0/a.go":   "package a\n",
+		testModule + "/v2@v2.0.0/go.mod": "module " + testModule + "/v2\n",
+	})
+}
+
+func TestFetcher_Credentials(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	assert.NoError(t, os.WriteFile(netrc, []byte("machine git.example.com login netrc-user password netrc-secret\n"), 0o600))
+
+	f, err := NewFetcher(Config{Patterns: "git.example.com", Netrc: netrc})
+	assert.NoError(t, err)
+	header := "GIT_CONFIG_VALUE_0=Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("netrc-user:netrc-secret"))
+	assert.True(t, contains(f.credentials("https://git.example.com/tools"), header))
+	assert.DeepEqual(t, f.credentials("https://other.example.com/tools"), []string{"GIT_TERMINAL_PROMPT=0"})
+	assert.DeepEqual(t, f.credentials("/srv/git/tools.git"), []string{"GIT_TERMINAL_PROMPT=0"})
+
+	// Configured credentials take precedence
+	f, err = NewFetcher(Config{Patterns: "git.example.com", Netrc: netrc, Username: "user", Password: "secret"})
+	assert.NoError(t, err)
+	header = "GIT_CONFIG_VALUE_0=Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	assert.True(t, contains(f.credentials("https://other.example.com/tools"), header))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseNetrc(t *testing.T) {
+	lines := parseNetrc(`
+machine a.example.com login a password pa
+macdef init
+machine hidden.example.com login h password ph
+
+machine b.example.com
+  login b
+  password pb
+default login d password pd
+machine c.example.com login c password pc
+`)
+	assert.DeepEqual(t, lines, []netrcLine{
+		{machine: "a.example.com", login: "a", password: "pa"},
+		{machine: "b.example.com", login: "b", password: "pb"},
+	})
+}
+
+func TestParseRepos(t *testing.T) {
+	repos, err := parseRepos("example.com/a=https://git.example.com/a.git, example.com/b/=/srv/b.git")
+	assert.NoError(t, err)
+	assert.DeepEqual(t, repos, []repo{
+		{prefix: "example.com/a", url: "https://git.example.com/a.git"},
+		{prefix: "example.com/b", url: "/srv/b.git"},
+	})
+	for _, invalid := range []string{"example.com/a", "=https://git.example.com", "example.com/a=relative/path", "-bad=https://x"} {
+		_, err = parseRepos(invalid)
+		assert.Error(t, err)
+	}
+}
+
+func TestParseGoImports(t *testing.T) {
+	imports, err := parseGoImports(strings.NewReader(`<!DOCTYPE html>
+<html><head>
+<meta name="go-import" content="example.org/m git https://git.example.org/m">
+<meta name="go-source" content="example.org/m https://example.org/m https://example.org/m/tree{/dir}">
+<META NAME="go-import" CONTENT="example.org/n  hg  https://hg.example.org/n">
+<meta name="go-import" content="incomplete">
+</head><body>
+<meta name="go-import" content="example.org/o git https://git.example.org/o">
+</body></html>`))
+	assert.NoError(t, err)
+	assert.DeepEqual(t, imports, []goImport{
+		{prefix: "example.org/m", vcs: "git", repoUrl: "https://git.example.org/m"},
+		{prefix: "example.org/n", vcs: "hg", repoUrl: "https://hg.example.org/n"},
+	})
+}
+
+func TestFetcher_Discover(t *testing.T) {
+	logger.InitLogger()
+	requests := make(map[string]int)
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		lock.Unlock()
+		assert.Equal(t, r.URL.Query().Get("go-get"), "1")
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/example.org/m"):
+			_, _ = w.Write([]byte(`<meta name="go-import" content="example.org/m git https://git.example.org/m">`))
+		case strings.HasPrefix(r.URL.Path, "/example.org/hg"):
+			_, _ = w.Write([]byte(`<meta name="go-import" content="example.org/hg hg https://hg.example.org/hg">`))
+		case strings.HasPrefix(r.URL.Path, "/example.org/insecure"):
+			_, _ = w.Write([]byte(`<meta name="go-import" content="example.org/insecure git http://example.org/insecure">`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	f, err := NewDirectFetcher(Config{CacheDir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.False(t, f.Match("example.org/m"))
+	f.discoveryBase = server.URL + "/"
+
+	loc, err := f.locate("example.org/m/sub/v2")
+	assert.NoError(t, err)
+	assert.Equal(t, loc.url, "https://git.example.org/m")
+	assert.Equal(t, loc.dir, "sub")
+	assert.Equal(t, loc.pathMajor, "/v2")
+	// Discovered repositories are remembered
+	loc, err = f.locate("example.org/m")
+	assert.NoError(t, err)
+	assert.Equal(t, loc.url, "https://git.example.org/m")
+	assert.Equal(t, loc.dir, "")
+	assert.Equal(t, requests["/example.org/m"], 0)
+	assert.Equal(t, requests["/example.org/m/sub/v2"], 1)
+
+	// Known hosts are not asked
+	loc, err = f.locate("github.com/owner/repo/sub")
+	assert.NoError(t, err)
+	assert.Equal(t, loc.url, "https://github.com/owner/repo")
+	assert.Equal(t, len(requests), 1)
+
+	for _, modulePath := range []string{"example.org/unknown", "example.org/hg", "example.org/insecure"} {
+		_, err, status := f.List(modulePath)
+		assert.Error(t, err)
+		assert.Equal(t, status, 404)
+	}
+	_, err, status := f.List("example.org/m/v1")
+	assert.Error(t, err)
+	assert.Equal(t, status, 400)
+}
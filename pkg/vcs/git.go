@@ -0,0 +1,188 @@
+package vcs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errUnknownRevision is returned if a revision does not exist in the repository, even after fetching
+var errUnknownRevision = errors.New("unknown revision")
+
+// mirror is a bare clone of a repository, which is kept in sync by fetching
+type mirror struct {
+	// lock serializes clones and fetches, reads run concurrently
+	lock sync.RWMutex
+	url  string
+	dir  string
+	// env holds the credentials passed to git
+	env []string
+}
+
+// commit is a resolved revision
+type commit struct {
+	hash string
+	time time.Time
+}
+
+func (m *mirror) git(args ...string) ([]byte, error) {
+	return m.gitIn(m.dir, args...)
+}
+
+func (m *mirror) gitIn(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), m.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// update clones the repository on first use, later calls fetch new commits and tags
+func (m *mirror) update() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, err := os.Stat(filepath.Join(m.dir, "HEAD")); err == nil {
+		_, err = m.git("fetch", "--prune", "--quiet", "origin")
+		return err
+	}
+	parent := filepath.Dir(m.dir)
+	err := os.MkdirAll(parent, 0o755)
+	if err != nil {
+		return err
+	}
+	// Clone next to the mirror, so an interrupted clone is never mistaken for a mirror
+	tmp, err := os.MkdirTemp(parent, filepath.Base(m.dir)+".clone")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	_, err = m.gitIn(parent, "clone", "--mirror", "--quiet", m.url, tmp)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.dir)
+}
+
+// ensure clones the repository if it has not been cloned yet
+func (m *mirror) ensure() error {
+	if _, err := os.Stat(filepath.Join(m.dir, "HEAD")); err == nil {
+		return nil
+	}
+	return m.update()
+}
+
+// resolve returns the commit rev points to, fetching once if it is unknown
+func (m *mirror) resolve(rev string) (commit, error) {
+	err := m.ensure()
+	if err != nil {
+		return commit{}, err
+	}
+	c, err := m.lookup(rev)
+	if errors.Is(err, errUnknownRevision) {
+		err = m.update()
+		if err != nil {
+			return commit{}, err
+		}
+		c, err = m.lookup(rev)
+	}
+	return c, err
+}
+
+func (m *mirror) lookup(rev string) (commit, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if strings.HasPrefix(rev, "-") {
+		return commit{}, errUnknownRevision
+	}
+	out, err := m.git("log", "-n1", "--format=%H %ct", rev+"^{commit}", "--")
+	if err != nil {
+		return commit{}, fmt.Errorf("%w %s", errUnknownRevision, rev)
+	}
+	hash, timestamp, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return commit{}, fmt.Errorf("invalid commit time %q of %s", timestamp, rev)
+	}
+	return commit{hash: hash, time: time.Unix(seconds, 0).UTC()}, nil
+}
+
+// tags returns the tags of the repository, or only the tags that are ancestors of hash, if it is set
+func (m *mirror) tags(mergedInto string) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	args := []string{"tag", "--list"}
+	if mergedInto != "" {
+		args = append(args, "--merged", mergedInto)
+	}
+	out, err := m.git(args...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// pointsAt returns the tags pointing to hash
+func (m *mirror) pointsAt(hash string) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	out, err := m.git("tag", "--list", "--points-at", hash)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// readFile returns the content of file at hash
+func (m *mirror) readFile(hash, file string) ([]byte, bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	object := hash + ":" + file
+	if _, err := m.git("cat-file", "-e", object); err != nil {
+		return nil, false, nil
+	}
+	content, err := m.git("cat-file", "blob", object)
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+// archive writes a zip of dir at hash into file, like the go command does
+func (m *mirror) archive(hash, dir string, file *os.File) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	// Normalize line endings, so the archive is the same on every platform
+	args := []string{"-c", "core.autocrlf=input", "-c", "core.eol=lf", "archive", "--format=zip", hash}
+	if dir != "" {
+		args = append(args, dir)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = m.dir
+	cmd.Env = append(os.Environ(), m.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = file
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("git archive: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type netrcLine struct {
+	machine  string
+	login    string
+	password string
+}
+
+// netrcPath returns the netrc file used if none is configured, like the go command does
+func netrcPath() string {
+	if path, found := os.LookupEnv("NETRC"); found {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc returns the machine entries of a netrc file, macros are skipped
+func parseNetrc(data string) []netrcLine {
+	var lines []netrcLine
+	var l netrcLine
+	inMacro := false
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			if line == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		f := strings.Fields(line)
+		for i := 0; i < len(f)-1; i += 2 {
+			// Reset at each "machine" token
+			switch f[i] {
+			case "machine":
+				l = netrcLine{machine: f[i+1]}
+			case "default":
+				// "default" ends the list of machines, its credentials are not used
+				return lines
+			case "login":
+				l.login = f[i+1]
+			case "password":
+				l.password = f[i+1]
+			case "macdef":
+				// Macros run until the next empty line
+				inMacro = true
+			}
+			if l.machine != "" && l.login != "" && l.password != "" {
+				lines = append(lines, l)
+				l = netrcLine{}
+			}
+		}
+
+		if len(f)%2 == 1 && f[len(f)-1] == "default" {
+			break
+		}
+	}
+	return lines
+}
+
+// netrcCredentials looks up the login and password for host in the netrc file at path
+func netrcCredentials(path, host string) (string, string, bool) {
+	if path == "" {
+		return "", "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+	for _, l := range parseNetrc(string(data)) {
+		if l.machine == host {
+			return l.login, l.password, true
+		}
+	}
+	return "", "", false
+}
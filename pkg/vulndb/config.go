@@ -0,0 +1,34 @@
+package vulndb
+
+import (
+	"errors"
+	"net/url"
+	"time"
+)
+
+// DefaultURL is the Go vulnerability database, setting it as URL enables the mirror
+const DefaultURL = "https://vuln.go.dev"
+
+// Config controls the mirror of the Go vulnerability database
+type Config struct {
+	// URL is the vulnerability database that is mirrored, like DefaultURL. It is empty by default, which disables the mirror,
+	// as the mirror contacts URL on every refresh and stores the whole database.
+	URL string `yaml:"url" env:"VULNDB_URL"`
+	// RefreshInterval is the delay between two checks for changes of the database
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"VULNDB_REFRESH_INTERVAL"`
+}
+
+func (cfg Config) Validate() error {
+	if cfg.URL == "" {
+		return nil
+	}
+	var errs []error
+	parsed, err := url.Parse(cfg.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		errs = append(errs, errors.New("vulndb url has to be an http or https url"))
+	}
+	if cfg.RefreshInterval <= 0 {
+		errs = append(errs, errors.New("vulndb refresh interval has to be a positive duration"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,22 @@
+package vulndb
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// databaseModified is the modification time of the mirrored database
+var databaseModified = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "gofastcache",
+	Subsystem: "vulndb",
+	Name:      "modified_timestamp_seconds",
+	Help:      "Time the mirrored vulnerability database was last changed.",
+})
+
+// refreshFailures counts the failed refreshes of the mirror
+var refreshFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "gofastcache",
+	Subsystem: "vulndb",
+	Name:      "refresh_failures_total",
+	Help:      "Failed refreshes of the vulnerability database mirror.",
+})
@@ -0,0 +1,443 @@
+package vulndb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/modpath"
+	"goFastCache/pkg/upstream"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix is where the mirrored files are kept in blob storage, they are stored gzipped like <Prefix>index/db.json.gz
+const Prefix = "vulndb/"
+
+// Endpoints of the vulnerability database protocol, without the .json or .json.gz extension
+const (
+	endpointDB      = "index/db"
+	endpointModules = "index/modules"
+	endpointVulns   = "index/vulns"
+	idDir           = "ID/"
+)
+
+// idRegex matches the ids of the Go vulnerability database, which are used in blob storage keys
+var idRegex = regexp.MustCompile(`^GO-[0-9]{4}-[0-9]{4,}$`)
+
+// maxFile bounds every file of the database, after decompression
+const maxFile = 256 << 20
+
+// fetchWorkers is the number of entries that are fetched concurrently
+const fetchWorkers = 8
+
+// Mirror keeps a copy of a vulnerability database in blob storage
+type Mirror struct {
+	url  string
+	blob blobstorage.Storage
+
+	lock sync.RWMutex
+	// modified and modules describe the mirrored database, modified is zero if nothing is mirrored yet
+	modified time.Time
+	modules  []ModuleMeta
+}
+
+// New returns a Mirror of the database in cfg, or nil if mirroring is disabled.
+// A database that was mirrored before is served right away.
+func New(cfg Config, blob blobstorage.Storage) *Mirror {
+	if cfg.URL == "" || blob == nil {
+		return nil
+	}
+	m := &Mirror{url: strings.TrimSuffix(cfg.URL, "/"), blob: blob}
+	err := m.load()
+	if err != nil {
+		zap.S().Warnf("Ignoring the mirrored vulnerability database: %v", err)
+	}
+	return m
+}
+
+// load reads the index of the mirrored database from blob storage
+func (m *Mirror) load() error {
+	dbGzip, found := m.blob.Get(Prefix + endpointDB + ".json.gz")
+	if !found {
+		return nil
+	}
+	modulesGzip, found := m.blob.Get(Prefix + endpointModules + ".json.gz")
+	if !found {
+		return errors.New("index/modules.json is missing")
+	}
+	var meta DBMeta
+	var modules []ModuleMeta
+	if err := decode(dbGzip, &meta); err != nil {
+		return fmt.Errorf("index/db.json: %w", err)
+	}
+	if err := decode(modulesGzip, &modules); err != nil {
+		return fmt.Errorf("index/modules.json: %w", err)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.modified, m.modules = meta.Modified, modules
+	return nil
+}
+
+// Modified returns the time the mirrored database was last changed, it is zero if nothing is mirrored yet
+func (m *Mirror) Modified() time.Time {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.modified
+}
+
+// Refresh mirrors the database if it changed since it was last mirrored.
+// Only the entries that changed are fetched, and the index is replaced once every entry is stored,
+// so the mirror is always consistent. Nothing is fetched in offline mode.
+func (m *Mirror) Refresh(ctx context.Context) (bool, error) {
+	if upstream.IsOffline() {
+		return false, nil
+	}
+	dbGzip, err := m.fetch(ctx, endpointDB)
+	if err != nil {
+		return false, err
+	}
+	var meta DBMeta
+	if err = decode(dbGzip, &meta); err != nil {
+		return false, fmt.Errorf("index/db.json: %w", err)
+	}
+	m.lock.RLock()
+	modified, previous := m.modified, m.modules
+	m.lock.RUnlock()
+	if !meta.Modified.After(modified) {
+		return false, nil
+	}
+
+	modulesGzip, err := m.fetch(ctx, endpointModules)
+	if err != nil {
+		return false, err
+	}
+	var modules []ModuleMeta
+	if err = decode(modulesGzip, &modules); err != nil {
+		return false, fmt.Errorf("index/modules.json: %w", err)
+	}
+	vulnsGzip, err := m.fetch(ctx, endpointVulns)
+	if err != nil {
+		return false, err
+	}
+	var vulns []json.RawMessage
+	if err = decode(vulnsGzip, &vulns); err != nil {
+		return false, fmt.Errorf("index/vulns.json: %w", err)
+	}
+
+	ids, err := changedEntries(previous, modules)
+	if err != nil {
+		return false, err
+	}
+	if err = m.mirrorEntries(ctx, ids); err != nil {
+		return false, err
+	}
+	// db.json goes last, as it decides whether the database is mirrored again
+	for _, file := range []struct {
+		endpoint string
+		content  []byte
+	}{{endpointModules, modulesGzip}, {endpointVulns, vulnsGzip}, {endpointDB, dbGzip}} {
+		if err = m.blob.Put(Prefix+file.endpoint+".json.gz", file.content); err != nil {
+			return false, err
+		}
+	}
+
+	m.lock.Lock()
+	m.modified, m.modules = meta.Modified, modules
+	m.lock.Unlock()
+	databaseModified.Set(float64(meta.Modified.UnixNano()) / 1e9)
+	zap.S().Infof("Mirrored vulnerability database modified at %s, %d entries changed", meta.Modified.Format(time.RFC3339), len(ids))
+	return true, nil
+}
+
+// changedEntries returns the ids of the entries in modules that are not in previous or were modified since
+func changedEntries(previous, modules []ModuleMeta) ([]string, error) {
+	known := make(map[string]time.Time)
+	for _, module := range previous {
+		for _, vuln := range module.Vulns {
+			known[vuln.ID] = vuln.Modified
+		}
+	}
+	changed := make(map[string]bool)
+	for _, module := range modules {
+		for _, vuln := range module.Vulns {
+			if !idRegex.MatchString(vuln.ID) {
+				return nil, fmt.Errorf("invalid vulnerability id %q in index/modules.json", vuln.ID)
+			}
+			if modified, found := known[vuln.ID]; !found || vuln.Modified.After(modified) {
+				changed[vuln.ID] = true
+			}
+		}
+	}
+	ids := make([]string, 0, len(changed))
+	for id := range changed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// mirrorEntries fetches and stores the entries with ids, fetchWorkers at a time
+func (m *Mirror) mirrorEntries(ctx context.Context, ids []string) error {
+	work := make(chan string)
+	errs := make(chan error, len(ids))
+	var wg sync.WaitGroup
+	for i := 0; i < fetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range work {
+				errs <- m.mirrorEntry(ctx, id)
+			}
+		}()
+	}
+	for _, id := range ids {
+		work <- id
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+
+	var first error
+	failed := 0
+	for err := range errs {
+		if err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("mirroring %d of %d entries failed: %w", failed, len(ids), first)
+	}
+	return nil
+}
+
+func (m *Mirror) mirrorEntry(ctx context.Context, id string) error {
+	entryGzip, err := m.fetch(ctx, idDir+id)
+	if err != nil {
+		return err
+	}
+	var entry Entry
+	if err = decode(entryGzip, &entry); err != nil {
+		return fmt.Errorf("%s.json: %w", id, err)
+	}
+	if entry.ID != id {
+		return fmt.Errorf("%s.json contains entry %s", id, entry.ID)
+	}
+	return m.blob.Put(Prefix+idDir+id+".json.gz", entryGzip)
+}
+
+// fetch returns the gzipped content of endpoint.
+// Files are requested gzipped like govulncheck does, databases that answer with plain JSON are gzipped here.
+func (m *Mirror) fetch(ctx context.Context, endpoint string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url+"/"+endpoint+".json.gz", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("%s returned status %d", request.URL, response.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(response.Body, maxFile))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		return content, nil
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write(content)
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// gunzip returns the decompressed content of a mirrored file
+func gunzip(content []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxFile))
+}
+
+// decode unmarshals the gzipped JSON in content into v
+func decode(content []byte, v any) error {
+	decompressed, err := gunzip(content)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decompressed, v)
+}
+
+// Get returns a file of the mirrored database, like index/db.json, index/modules.json.gz or ID/GO-2022-0001.json.
+// Files ending in .json.gz are returned gzipped, .json files decompressed.
+func (m *Mirror) Get(file string) ([]byte, error, int) {
+	endpoint, gzipped := strings.CutSuffix(file, ".json.gz")
+	if !gzipped {
+		var found bool
+		endpoint, found = strings.CutSuffix(file, ".json")
+		if !found {
+			return nil, fmt.Errorf("%s is no file of the vulnerability database", file), 404
+		}
+	}
+	id, isEntry := strings.CutPrefix(endpoint, idDir)
+	valid := idRegex.MatchString(id)
+	if !isEntry {
+		valid = endpoint == endpointDB || endpoint == endpointModules || endpoint == endpointVulns
+	}
+	if !valid {
+		return nil, fmt.Errorf("%s is no file of the vulnerability database", file), 404
+	}
+	if m.Modified().IsZero() {
+		return nil, errors.New("the vulnerability database is not mirrored yet"), 503
+	}
+
+	content, found := m.blob.Get(Prefix + endpoint + ".json.gz")
+	if !found {
+		return nil, fmt.Errorf("%s not found", file), 404
+	}
+	if gzipped {
+		return content, nil, 200
+	}
+	content, err := gunzip(content)
+	if err != nil {
+		return nil, err, 500
+	}
+	return content, nil, 200
+}
+
+// Vuln is a vulnerability of a cached version
+type Vuln struct {
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases,omitempty"`
+	Summary string   `json:"summary,omitempty"`
+	// Fixed is the latest version that fixes the vulnerability, empty if there is no fix
+	Fixed string `json:"fixed,omitempty"`
+}
+
+// VulnerableVersion is a catalogued version with known vulnerabilities
+type VulnerableVersion struct {
+	Module  string `json:"module"`
+	Version string `json:"version"`
+	Vulns   []Vuln `json:"vulns"`
+}
+
+// Vulnerable returns the catalogued versions of db that are affected by a vulnerability of the mirrored database,
+// of every module or only of the module at path if it is not empty.
+func (m *Mirror) Vulnerable(db database.Database, path string) ([]VulnerableVersion, error) {
+	m.lock.RLock()
+	modules := m.modules
+	m.lock.RUnlock()
+
+	var paths []string
+	if path != "" {
+		paths = append(paths, modpath.Canonical(path))
+	} else {
+		gomodules, err := db.ListGoModules()
+		if err != nil {
+			return nil, err
+		}
+		for _, gomodule := range gomodules {
+			paths = append(paths, gomodule.Path)
+		}
+		sort.Strings(paths)
+	}
+	vulnsByPath := make(map[string][]ModuleVuln, len(modules))
+	for _, module := range modules {
+		vulnsByPath[module.Path] = module.Vulns
+	}
+
+	vulnerable := make([]VulnerableVersion, 0)
+	for _, path := range paths {
+		moduleVulns := vulnsByPath[path]
+		if len(moduleVulns) == 0 {
+			continue
+		}
+		gomoduleVersions, err := db.ListGoModuleVersions(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(gomoduleVersions) == 0 {
+			continue
+		}
+		entries := make([]*Entry, len(moduleVulns))
+		for i, moduleVuln := range moduleVulns {
+			entries[i], err = m.entry(moduleVuln.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, gomoduleVersion := range gomoduleVersions {
+			v := VulnerableVersion{Module: path, Version: gomoduleVersion.Version}
+			for i, entry := range entries {
+				if entry.Affects(path, gomoduleVersion.Version) {
+					v.Vulns = append(v.Vulns, Vuln{ID: entry.ID, Aliases: entry.Aliases, Summary: entry.Summary, Fixed: moduleVulns[i].Fixed})
+				}
+			}
+			if len(v.Vulns) > 0 {
+				vulnerable = append(vulnerable, v)
+			}
+		}
+	}
+	return vulnerable, nil
+}
+
+// entry reads a mirrored entry
+func (m *Mirror) entry(id string) (*Entry, error) {
+	content, found := m.blob.Get(Prefix + idDir + id + ".json.gz")
+	if !found {
+		return nil, fmt.Errorf("entry %s is not mirrored", id)
+	}
+	var entry Entry
+	if err := decode(content, &entry); err != nil {
+		return nil, fmt.Errorf("%s.json: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// RefreshInBackground mirrors the database right away and then every interval, until ctx is cancelled.
+// The returned channel is closed once it stopped.
+func (m *Mirror) RefreshInBackground(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	if m == nil {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		for {
+			_, err := m.Refresh(ctx)
+			if err != nil && ctx.Err() == nil {
+				refreshFailures.Inc()
+				zap.S().Errorf("Error mirroring the vulnerability database: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,118 @@
+package vulndb
+
+import (
+	"golang.org/x/mod/semver"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DBMeta is the content of index/db.json
+type DBMeta struct {
+	// Modified is the time the database was last changed
+	Modified time.Time `json:"modified"`
+}
+
+// ModuleMeta is an entry of index/modules.json, it lists the vulnerabilities of a module
+type ModuleMeta struct {
+	Path  string       `json:"path"`
+	Vulns []ModuleVuln `json:"vulns"`
+}
+
+// ModuleVuln is a vulnerability of a module in index/modules.json
+type ModuleVuln struct {
+	ID       string    `json:"id"`
+	Modified time.Time `json:"modified"`
+	// Fixed is the latest version that fixes the vulnerability, empty if there is no fix
+	Fixed string `json:"fixed,omitempty"`
+}
+
+// Entry is the part of an OSV entry in ID/<id>.json that is needed to find the affected versions
+type Entry struct {
+	ID       string     `json:"id"`
+	Summary  string     `json:"summary,omitempty"`
+	Aliases  []string   `json:"aliases,omitempty"`
+	Affected []Affected `json:"affected"`
+}
+
+// Affected lists the affected versions of a module
+type Affected struct {
+	Module struct {
+		Path string `json:"path"`
+	} `json:"module"`
+	Ranges []Range `json:"ranges,omitempty"`
+}
+
+// Range is a series of introduced and fixed events
+type Range struct {
+	Type   string  `json:"type"`
+	Events []Event `json:"events"`
+}
+
+// Event introduces or fixes a vulnerability, versions have no v prefix
+type Event struct {
+	Introduced string `json:"introduced,omitempty"`
+	Fixed      string `json:"fixed,omitempty"`
+}
+
+// Affects reports whether version of the module at path is affected by e
+func (e *Entry) Affects(path, version string) bool {
+	for _, affected := range e.Affected {
+		if affected.Module.Path != path {
+			continue
+		}
+		// A module without ranges is affected in every version
+		if len(affected.Ranges) == 0 {
+			return true
+		}
+		for _, r := range affected.Ranges {
+			if r.contains(version) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains reports whether version is in r, following the OSV rules for SEMVER ranges
+func (r Range) contains(version string) bool {
+	if r.Type != "SEMVER" {
+		return false
+	}
+	if len(r.Events) == 0 {
+		return true
+	}
+	events := make([]Event, len(r.Events))
+	copy(events, r.Events)
+	// The introduction at the beginning of time always comes first
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i].version(), events[j].version()
+		if a == "0" || b == "0" {
+			return a == "0" && b != "0"
+		}
+		return semver.Compare(canonical(a), canonical(b)) < 0
+	})
+
+	version = canonical(version)
+	affected := false
+	for _, e := range events {
+		if !affected && e.Introduced != "" {
+			affected = e.Introduced == "0" || semver.Compare(version, canonical(e.Introduced)) >= 0
+		} else if affected && e.Fixed != "" {
+			affected = semver.Compare(version, canonical(e.Fixed)) < 0
+		}
+	}
+	return affected
+}
+
+func (e Event) version() string {
+	if e.Introduced != "" {
+		return e.Introduced
+	}
+	return e.Fixed
+}
+
+// canonical adds the v prefix OSV versions lack
+func canonical(version string) string {
+	return "v" + strings.TrimPrefix(version, "v")
+}
@@ -0,0 +1,244 @@
+package vulndb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/zeebo/assert"
+	"goFastCache/pkg/blobstorage"
+	"goFastCache/pkg/database"
+	"goFastCache/pkg/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB serves a vulnerability database, every file gzipped as .json.gz
+type fakeDB struct {
+	lock     sync.Mutex
+	files    map[string]string
+	requests map[string]int
+}
+
+func newFakeDB(t *testing.T, files map[string]string) (*fakeDB, string) {
+	f := &fakeDB{files: files, requests: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		f.requests[r.URL.Path]++
+		content, found := f.files[strings.TrimSuffix(r.URL.Path, ".gz")]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		writer := gzip.NewWriter(w)
+		_, _ = writer.Write([]byte(content))
+		_ = writer.Close()
+	}))
+	t.Cleanup(server.Close)
+	return f, server.URL
+}
+
+func (f *fakeDB) set(path, content string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.files[path] = content
+}
+
+func (f *fakeDB) count(path string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests[path]
+}
+
+const (
+	modified1 = "2023-07-01T00:00:00Z"
+	modified2 = "2023-07-02T00:00:00Z"
+)
+
+func testFiles() map[string]string {
+	return map[string]string{
+		"/index/db.json": `{"modified":"` + modified1 + `"}`,
+		"/index/modules.json": `[
+			{"path":"example.com/a","vulns":[{"id":"GO-2023-0001","modified":"` + modified1 + `","fixed":"1.2.0"}]},
+			{"path":"example.com/b","vulns":[{"id":"GO-2023-0002","modified":"` + modified1 + `"}]}
+		]`,
+		"/index/vulns.json": `[{"id":"GO-2023-0001","modified":"` + modified1 + `"},{"id":"GO-2023-0002","modified":"` + modified1 + `"}]`,
+		"/ID/GO-2023-0001.json": `{"id":"GO-2023-0001","summary":"Crash in a","aliases":["CVE-2023-1111"],"affected":[
+			{"module":{"path":"example.com/a"},"ranges":[{"type":"SEMVER","events":[{"introduced":"1.1.0"},{"fixed":"1.2.0"}]}]}]}`,
+		"/ID/GO-2023-0002.json": `{"id":"GO-2023-0002","affected":[{"module":{"path":"example.com/b"},"ranges":[{"type":"SEMVER","events":[{"introduced":"0"}]}]}]}`,
+	}
+}
+
+func gunzipString(t *testing.T, content []byte) string {
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	assert.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return string(decompressed)
+}
+
+func TestRange_Contains(t *testing.T) {
+	r := Range{Type: "SEMVER", Events: []Event{{Fixed: "1.2.0"}, {Introduced: "0"}, {Introduced: "1.4.0"}, {Fixed: "1.4.2"}}}
+	for version, expected := range map[string]bool{
+		"v0.1.0":   true,
+		"v1.1.9":   true,
+		"v1.2.0":   false,
+		"v1.3.0":   false,
+		"v1.4.0":   true,
+		"v1.4.1":   true,
+		"v1.4.2":   false,
+		"v2.0.0":   false,
+		"v1.2.0-0": true,
+	} {
+		assert.Equal(t, r.contains(version), expected)
+	}
+	assert.False(t, Range{Type: "ECOSYSTEM", Events: []Event{{Introduced: "0"}}}.contains("v1.0.0"))
+}
+
+func TestEntry_Affects(t *testing.T) {
+	var entry Entry
+	assert.NoError(t, json.Unmarshal([]byte(testFiles()["/ID/GO-2023-0001.json"]), &entry))
+	assert.True(t, entry.Affects("example.com/a", "v1.1.5"))
+	assert.False(t, entry.Affects("example.com/a", "v1.2.0"))
+	assert.False(t, entry.Affects("example.com/other", "v1.1.5"))
+}
+
+func TestMirror_Refresh(t *testing.T) {
+	logger.InitLogger()
+	fake, url := newFakeDB(t, testFiles())
+	blob := blobstorage.NewMemory()
+	m := New(Config{URL: url, RefreshInterval: time.Hour}, blob)
+
+	content, err, status := m.Get("index/db.json")
+	assert.Error(t, err)
+	assert.Equal(t, status, 503)
+	assert.Nil(t, content)
+
+	refreshed, err := m.Refresh(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, refreshed)
+	assert.Equal(t, m.Modified().Format(time.RFC3339), modified1)
+
+	// Unchanged databases are not fetched again
+	refreshed, err = m.Refresh(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, refreshed)
+	assert.Equal(t, fake.count("/index/modules.json.gz"), 1)
+
+	// Only modified entries are fetched
+	fake.set("/index/db.json", `{"modified":"`+modified2+`"}`)
+	fake.set("/index/modules.json", strings.Replace(testFiles()["/index/modules.json"], `"GO-2023-0002","modified":"`+modified1, `"GO-2023-0002","modified":"`+modified2, 1))
+	fake.set("/ID/GO-2023-0002.json", `{"id":"GO-2023-0002","summary":"updated","affected":[]}`)
+	refreshed, err = m.Refresh(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, refreshed)
+	assert.Equal(t, fake.count("/ID/GO-2023-0001.json.gz"), 1)
+	assert.Equal(t, fake.count("/ID/GO-2023-0002.json.gz"), 2)
+
+	content, err, status = m.Get("ID/GO-2023-0002.json")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.True(t, strings.Contains(string(content), "updated"))
+	content, err, status = m.Get("index/db.json.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, status, 200)
+	assert.Equal(t, gunzipString(t, content), `{"modified":"`+modified2+`"}`)
+
+	for _, file := range []string{"index/db", "index/other.json", "ID/../index/db.json", "ID/GO-2023-9999.json"} {
+		_, err, status = m.Get(file)
+		assert.Error(t, err)
+		assert.Equal(t, status, 404)
+	}
+
+	// A restarted mirror serves the stored database right away
+	m = New(Config{URL: url, RefreshInterval: time.Hour}, blob)
+	assert.Equal(t, m.Modified().Format(time.RFC3339), modified2)
+}
+
+func TestMirror_RefreshFailure(t *testing.T) {
+	logger.InitLogger()
+	files := testFiles()
+	fake, url := newFakeDB(t, files)
+	m := New(Config{URL: url, RefreshInterval: time.Hour}, blobstorage.NewMemory())
+	_, err := m.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	// A missing entry keeps the previous database, until it can be fetched
+	fake.set("/index/db.json", `{"modified":"`+modified2+`"}`)
+	fake.set("/index/modules.json", `[{"path":"example.com/c","vulns":[{"id":"GO-2023-0003","modified":"`+modified2+`"}]}]`)
+	_, err = m.Refresh(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, m.Modified().Format(time.RFC3339), modified1)
+	content, _, _ := m.Get("index/modules.json")
+	assert.True(t, strings.Contains(string(content), "example.com/a"))
+
+	fake.set("/ID/GO-2023-0003.json", `{"id":"GO-2023-0003","affected":[]}`)
+	refreshed, err := m.Refresh(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, refreshed)
+	assert.Equal(t, m.Modified().Format(time.RFC3339), modified2)
+}
+
+func TestMirror_Vulnerable(t *testing.T) {
+	logger.InitLogger()
+	_, url := newFakeDB(t, testFiles())
+	m := New(Config{URL: url, RefreshInterval: time.Hour}, blobstorage.NewMemory())
+	_, err := m.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	db := database.NewMemory()
+	for _, v := range [][2]string{{"example.com/a", "v1.0.0"}, {"example.com/a", "v1.1.3"}, {"example.com/b", "v0.1.0"}, {"example.com/c", "v1.0.0"}} {
+		assert.NoError(t, db.UpdateGoModuleVersion(v[0], v[1], func(*database.GomoduleVersion) {}))
+	}
+
+	vulnerable, err := m.Vulnerable(db, "")
+	assert.NoError(t, err)
+	assert.DeepEqual(t, vulnerable, []VulnerableVersion{
+		{Module: "example.com/a", Version: "v1.1.3", Vulns: []Vuln{{ID: "GO-2023-0001", Aliases: []string{"CVE-2023-1111"}, Summary: "Crash in a", Fixed: "1.2.0"}}},
+		{Module: "example.com/b", Version: "v0.1.0", Vulns: []Vuln{{ID: "GO-2023-0002"}}},
+	})
+
+	vulnerable, err = m.Vulnerable(db, "example.com/b")
+	assert.NoError(t, err)
+	assert.Equal(t, len(vulnerable), 1)
+	assert.Equal(t, vulnerable[0].Module, "example.com/b")
+}
+
+func TestMirror_RefreshInBackground(t *testing.T) {
+	logger.InitLogger()
+	_, url := newFakeDB(t, testFiles())
+	m := New(Config{URL: url, RefreshInterval: time.Hour}, blobstorage.NewMemory())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := m.RefreshInBackground(ctx, time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Modified().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, m.Modified().IsZero())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refreshing did not stop")
+	}
+
+	// Without a url nothing is mirrored
+	m = New(Config{}, blobstorage.NewMemory())
+	assert.Nil(t, m)
+	<-m.RefreshInBackground(context.Background(), time.Hour)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.NoError(t, Config{URL: DefaultURL, RefreshInterval: time.Hour}.Validate())
+	assert.Error(t, Config{URL: "vuln.go.dev", RefreshInterval: time.Hour}.Validate())
+	assert.Error(t, Config{URL: DefaultURL}.Validate())
+}